@@ -0,0 +1,74 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/alvinbaena/pwd-checker/internal/api"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+)
+
+var (
+	apiKeyCmd = &cobra.Command{
+		Use:   "api-key [NAME]",
+		Short: "Generate an API key for the serve command",
+		Long: "Generate a random API key for the serve command. The key is printed to stdout, and only its SHA256 hash " +
+			"is added to the --api-keys-file, with the optional name. Without --api-keys-file the hash is printed to stderr.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := ""
+			if len(args) > 0 {
+				name = args[0]
+			}
+			return apiKeyCommand(name)
+		},
+	}
+)
+
+//goland:noinspection GoUnhandledErrorResult
+func init() {
+	apiKeyCmd.Flags().StringVar(&apiKeysFile, "api-keys-file", "", "API keys file of the serve command, the hash of the new key is appended to it")
+
+	rootCmd.AddCommand(apiKeyCmd)
+}
+
+func apiKeyCommand(name string) error {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	applyCliSettings(verbose, profile, pprofPort)
+
+	if strings.ContainsAny(name, "\r\n") {
+		return fmt.Errorf("the key name can't have line breaks")
+	}
+
+	key, hash, err := api.GenerateAPIKey()
+	if err != nil {
+		return err
+	}
+
+	line := strings.TrimSpace(hash + " " + name)
+	if apiKeysFile == "" {
+		log.Info().Msgf("add this line to the API keys file: %s", line)
+	} else {
+		file, err := os.OpenFile(apiKeysFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+
+		if _, err = fmt.Fprintln(file, line); err != nil {
+			_ = file.Close()
+			return err
+		}
+		if err = file.Close(); err != nil {
+			return err
+		}
+		log.Info().Msgf("key hash added to %s, the server loads it on the next reload", apiKeysFile)
+	}
+
+	fmt.Println(key)
+	return nil
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/alvinbaena/pwd-checker/gcs"
+	"github.com/alvinbaena/pwd-checker/hibp"
+	"github.com/alvinbaena/pwd-checker/internal/util"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+// About a thousand hashes per range, 1048576000 in total. The v8 file has 847223402.
+const estimatedHibpHashes = hibp.Ranges * 1000
+
+var (
+	buildFromHibpCmd = &cobra.Command{
+		Use:   "build-from-hibp",
+		Short: "Download the latest haveibeenpwned hashes (SHA1) straight into a GCS database",
+		Long: "Download the latest haveibeenpwned hashes (SHA1) and create a GCS database from them, " +
+			"without writing the Pwned Passwords file to disk unless a text copy is asked for.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return buildFromHibpCommand()
+		},
+	}
+)
+
+//goland:noinspection GoUnhandledErrorResult
+func init() {
+	buildFromHibpCmd.Flags().StringVarP(&outFile, "out-file", "o", "./pwned.gcs", "GCS file output path")
+	buildFromHibpCmd.Flags().BoolVar(&overwrite, "overwrite", false, "Overwrite any existing files while writing the results.")
+	buildFromHibpCmd.Flags().StringVar(&textCopy, "text-copy", "",
+		"Also write the downloaded hashes to this Pwned Passwords file. It needs about 40GiB of disk.")
+	buildFromHibpCmd.Flags().Uint64VarP(&probability, "false-positive-rate", "p", gcs.DefaultProbability, "False positive rate for queries, 1-in-p.")
+	buildFromHibpCmd.Flags().Uint64VarP(&indexGranularity, "index-granularity", "g", gcs.DefaultIndexGranularity, "Entries per index point (16 bytes each).")
+	buildFromHibpCmd.Flags().BoolVar(&compactIndex, "compact-index", false,
+		"Write an Elias-Fano coded index. It uses less than half the memory of the default index (16 bytes per entry) when querying, allowing a finer index granularity.")
+	buildFromHibpCmd.Flags().BoolVar(&exactSidecar, "exact-sidecar", false,
+		"Also write a table of the full SHA1 hashes next to the GCS file (adding .exact to its name). "+
+			"It's created from the text copy, so --text-copy is required.")
+	buildFromHibpCmd.Flags().BoolVar(&ordered, "ordered", false, "Write the ranges to the text copy in prefix order, so it's sorted by hash.")
+	buildFromHibpCmd.Flags().StringVar(&previousFile, "previous", "",
+		"Output file of a previous download. Only the ranges that changed since then are downloaded, the others are copied from it.")
+	buildFromHibpCmd.Flags().StringVar(&baseURL, "base-url", hibp.DefaultBaseURL, "Base URL of the Pwned Passwords range API, ranges are requested from {base-url}/range/{prefix}.")
+	buildFromHibpCmd.Flags().StringSliceVar(&mirrors, "mirror", nil,
+		"Base URL of a mirror of the range API, used when a request to the base URL fails. Can be repeated, mirrors are used in order.")
+	buildFromHibpCmd.Flags().StringVar(&proxyURL, "proxy", "", "HTTP(S) proxy URL for the requests. By default the HTTP_PROXY and HTTPS_PROXY environment variables are used.")
+	buildFromHibpCmd.Flags().StringVar(&caBundle, "ca-bundle", "", "PEM file with CA certificates to trust besides the system ones, e.g. the CA of a TLS inspecting proxy.")
+	buildFromHibpCmd.Flags().IntVar(&rateLimit, "rate-limit", 0, "Max requests per second, retries included. 0 means no limit.")
+	buildFromHibpCmd.Flags().IntVarP(&threads, "threads", "t", 0, "Number of threads to use for the download. If omitted or less than 2, defaults to eight times the number of logical processors of the machine.")
+
+	rootCmd.AddCommand(buildFromHibpCmd)
+}
+
+func buildFromHibpCommand() error {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	applyCliSettings(verbose, profile, pprofPort)
+
+	reporter, closeProgress, err := progressReporter()
+	if err != nil {
+		return err
+	}
+	defer closeProgress()
+
+	if exactSidecar && textCopy == "" {
+		log.Fatal().Msg("the exact sidecar is created from the text copy, set --text-copy to create it")
+	}
+
+	abs, err := filepath.Abs(outFile)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("could not get absolute path of file")
+	}
+
+	outputs := []string{abs}
+	if exactSidecar {
+		outputs = append(outputs, gcs.SidecarFileName(abs))
+	}
+	var text *os.File
+	if textCopy != "" {
+		textAbs, err := filepath.Abs(textCopy)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("could not get absolute path of file")
+		}
+		outputs = append(outputs, textAbs)
+
+		defer func() {
+			if text != nil {
+				if err := text.Close(); err != nil {
+					log.Error().Err(err).Msg("error closing Pwned Passwords file")
+				}
+			}
+		}()
+	}
+
+	if !overwrite {
+		for _, name := range outputs {
+			if _, err = os.Stat(name); !os.IsNotExist(err) {
+				log.Fatal().Msgf("file %s exists and overwrite flag is not set", name)
+			}
+		}
+	}
+
+	if textCopy != "" {
+		if text, err = os.Create(outputs[len(outputs)-1]); err != nil {
+			return err
+		}
+	} else {
+		// Only the GCS file is written, a few GiB
+		util.CheckDiskSpace(abs, 4)
+	}
+
+	// The database is written to a temporary file, and only renamed once complete
+	tmp := abs + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	defer func(out *os.File) {
+		if err := out.Close(); err != nil {
+			log.Error().Err(err).Msg("error closing GCS file")
+		}
+		if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+			log.Error().Err(err).Msg("error removing temporary GCS file")
+		}
+	}(out)
+
+	builder, err := gcs.NewStreamBuilder(out, estimatedHibpHashes,
+		gcs.WithProbability(probability),
+		gcs.WithIndexGranularity(indexGranularity),
+		gcs.WithCompactIndex(compactIndex),
+		gcs.WithLogger(log.Logger),
+		gcs.WithProgress(reporter),
+	)
+	if err != nil {
+		return err
+	}
+
+	// Stop the process if not enough ram to actually hold all the entries downloaded.
+	util.CheckRam(builder.EstimatedItems(), false)
+
+	// Stop the download cleanly on ^C, nothing is written
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	d, err := hibp.NewDownloader(text, threads,
+		hibp.WithRangeWriter(&builderRangeWriter{builder: builder}),
+		hibp.WithOrderedOutput(ordered),
+		hibp.WithPrevious(previousFile),
+		hibp.WithBaseURL(baseURL),
+		hibp.WithMirrors(mirrors...),
+		hibp.WithProxy(proxyURL),
+		hibp.WithCABundle(caBundle),
+		hibp.WithRateLimit(rateLimit),
+		hibp.WithProgress(reporter),
+	)
+	if err != nil {
+		return err
+	}
+
+	s := util.Stats()
+	defer s()
+
+	// A database missing the hashes of a failed range would answer they are not pwned
+	if err = d.ProcessRanges(ctx, hibp.Ranges, false); err != nil {
+		return fmt.Errorf("the GCS database was not created: %w", err)
+	}
+
+	if err = builder.Finish(); err != nil {
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, abs); err != nil {
+		return err
+	}
+
+	if exactSidecar {
+		return createSidecar(text, abs, reporter)
+	}
+
+	return nil
+}
+
+// builderRangeWriter adds the hashes of the downloaded ranges to a GCS builder.
+type builderRangeWriter struct {
+	builder *gcs.Builder
+}
+
+func (w *builderRangeWriter) WriteRange(_ int, lines []byte) error {
+	for len(lines) > 0 {
+		line, rest, _ := bytes.Cut(lines, []byte("\n"))
+		lines = rest
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if err := w.addLine(line); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// addLine adds the hash of a Pwned Passwords line, HASH:COUNT. The downloader gives one range at a
+// time, so it's not called concurrently.
+func (w *builderRangeWriter) addLine(line []byte) error {
+	if len(line) < 16 {
+		return fmt.Errorf("invalid line in Pwned Passwords range: %q", line)
+	}
+
+	hash, err := gcs.U64FromHex(line[0:16])
+	if err != nil {
+		return fmt.Errorf("invalid line in Pwned Passwords range: %q: %w", line, err)
+	}
+
+	w.builder.Add(hash)
+	return nil
+}
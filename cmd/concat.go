@@ -0,0 +1,66 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"github.com/alvinbaena/pwd-checker/hibp"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+var (
+	concatCmd = &cobra.Command{
+		Use:   "concat SHARD...",
+		Short: "Put together the output files of a download split in shards with --from and --to",
+		Long: "Put together the output files of a download split in shards with --from and --to. " +
+			"The prefixes of the shards must not overlap, and their ranges are written in prefix order. " +
+			"Every range must be in exactly one of the shards, and the shards must match their manifests. " +
+			"The output file gets a manifest of its own.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return concatCommand(args)
+		},
+	}
+)
+
+//goland:noinspection GoUnhandledErrorResult
+func init() {
+	concatCmd.Flags().StringVarP(&outFile, "out-file", "o", "./pwned-sha1.txt", "Output file path. Can be absolute or relative.")
+	concatCmd.Flags().BoolVar(&overwrite, "overwrite", false, "Overwrite any existing files while writing the results.")
+
+	rootCmd.AddCommand(concatCmd)
+}
+
+func concatCommand(shards []string) error {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	applyCliSettings(verbose, profile, pprofPort)
+
+	abs, err := filepath.Abs(outFile)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("could not get absolute path of file")
+	}
+
+	if !overwrite {
+		if _, err = os.Stat(abs); !os.IsNotExist(err) {
+			log.Fatal().Msgf("file %s exists and overwrite flag is not set", abs)
+		}
+	}
+
+	for _, shard := range shards {
+		if shardAbs, err := filepath.Abs(shard); err == nil && shardAbs == abs {
+			log.Fatal().Msgf("the shard %s can't be the output file", shard)
+		}
+	}
+
+	m, err := hibp.Concat(abs, shards...)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("file %s has %d hashes in %d ranges, SHA256 %s", abs, m.Lines, len(m.Ranges), m.SHA256)
+	return nil
+}
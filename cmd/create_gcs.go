@@ -6,18 +6,22 @@ package cmd
 import (
 	"fmt"
 	"github.com/alvinbaena/pwd-checker/gcs"
+	"github.com/alvinbaena/pwd-checker/hibp"
+	"github.com/alvinbaena/pwd-checker/internal/util"
+	"github.com/alvinbaena/pwd-checker/progress"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	_ "net/http/pprof"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 var (
 	createCmd = &cobra.Command{
 		Use:   "create",
-		Short: "Create a GCS database from a Pwned Passwords file (SHA1)",
+		Short: "Create a GCS database from a Pwned Passwords file (SHA1), or a directory of ranges",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return createCommand()
 		},
@@ -26,12 +30,21 @@ var (
 
 //goland:noinspection GoUnhandledErrorResult
 func init() {
-	createCmd.Flags().Uint64VarP(&probability, "false-positive-rate", "p", 16777216, "False positive rate for queries, 1-in-p.")
-	createCmd.Flags().Uint64VarP(&indexGranularity, "index-granularity", "g", 1024, "Entries per index point (16 bytes each).")
-	createCmd.Flags().StringVarP(&inputFile, "in-file", "i", "", "Pwned passwords input file path (required)")
+	createCmd.Flags().Uint64VarP(&probability, "false-positive-rate", "p", gcs.DefaultProbability, "False positive rate for queries, 1-in-p.")
+	createCmd.Flags().Uint64VarP(&indexGranularity, "index-granularity", "g", gcs.DefaultIndexGranularity, "Entries per index point (16 bytes each).")
+	createCmd.Flags().BoolVar(&compactIndex, "compact-index", false,
+		"Write an Elias-Fano coded index. It uses less than half the memory of the default index (16 bytes per entry) when querying, allowing a finer index granularity.")
+	createCmd.Flags().BoolVar(&exactSidecar, "exact-sidecar", false,
+		"Also write a table of the full SHA1 hashes next to the GCS file (adding .exact to its name). "+
+			"The query and serve commands use it to confirm matches, so results have no false positives. "+
+			"The table uses about 18 bytes per hash.")
+	createCmd.Flags().StringVarP(&inputFile, "in-file", "i", "",
+		"Pwned passwords input file path (required). Can also be a directory with a file per range (download --out-dir), or a tarball of one (.tar, .tar.gz or .tgz).")
 	createCmd.MarkFlagRequired("in-file")
 	createCmd.Flags().StringVarP(&outFile, "out-file", "o", fmt.Sprintf("./pwned.gcs"), "GCS file output path")
 	createCmd.Flags().BoolVar(&overwrite, "overwrite", false, "Overwrite any existing files while writing the results.")
+	createCmd.Flags().BoolVar(&allowIncomplete, "allow-incomplete", false,
+		"Create the database from a directory or tarball of ranges that doesn't have all of them. The database answers that the hashes of the missing ranges are not pwned.")
 
 	rootCmd.AddCommand(createCmd)
 }
@@ -40,6 +53,16 @@ func createCommand() error {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	applyCliSettings(verbose, profile, pprofPort)
 
+	reporter, closeProgress, err := progressReporter()
+	if err != nil {
+		return err
+	}
+	defer closeProgress()
+
+	if isRangeInput(inputFile) {
+		return createFromRanges(reporter)
+	}
+
 	file, err := os.Open(inputFile)
 	if err != nil {
 		return err
@@ -61,6 +84,12 @@ func createCommand() error {
 		if !os.IsNotExist(err) {
 			log.Fatal().Msgf("file %s exists and overwrite flag is not set", outFile)
 		}
+
+		if exactSidecar {
+			if _, err = os.Stat(gcs.SidecarFileName(abs)); !os.IsNotExist(err) {
+				log.Fatal().Msgf("file %s exists and overwrite flag is not set", gcs.SidecarFileName(abs))
+			}
+		}
 	}
 
 	out, err := os.Create(abs)
@@ -74,10 +103,185 @@ func createCommand() error {
 		}
 	}(out)
 
-	builder := gcs.NewBuilder(file, out, probability, indexGranularity)
-	if err = builder.Process(false); err != nil {
+	builder, err := gcs.NewBuilder(file, out,
+		gcs.WithProbability(probability),
+		gcs.WithIndexGranularity(indexGranularity),
+		gcs.WithCompactIndex(compactIndex),
+		gcs.WithLogger(log.Logger),
+		gcs.WithProgress(reporter),
+	)
+	if err != nil {
 		return err
 	}
 
+	// Stop the process if not enough ram to actually hold all the entries read.
+	util.CheckRam(builder.EstimatedItems(), false)
+
+	s := util.Stats()
+	defer s()
+
+	if err = builder.Process(); err != nil {
+		return err
+	}
+
+	if exactSidecar {
+		return createSidecar(file, abs, reporter)
+	}
+
 	return nil
 }
+
+// createSidecar creates the exact sidecar of the GCS file gcsFileName, from the Pwned Passwords file
+// it was created from.
+func createSidecar(file *os.File, gcsFileName string, reporter progress.Reporter) error {
+	out, err := os.Create(gcs.SidecarFileName(gcsFileName))
+	if err != nil {
+		return err
+	}
+
+	defer func(out *os.File) {
+		if err = out.Close(); err != nil {
+			log.Error().Err(err).Msg("error closing exact sidecar file")
+		}
+	}(out)
+
+	builder, err := gcs.NewSidecarBuilder(file, out, gcsFileName, gcs.WithLogger(log.Logger), gcs.WithProgress(reporter))
+	if err != nil {
+		return err
+	}
+
+	return builder.Process()
+}
+
+// isRangeInput is true if the input is a range directory or a tarball of one, instead of a Pwned
+// Passwords file.
+func isRangeInput(fileName string) bool {
+	if info, err := os.Stat(fileName); err == nil && info.IsDir() {
+		return true
+	}
+
+	name := strings.ToLower(fileName)
+	return strings.HasSuffix(name, ".tar") || strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// createFromRanges creates the GCS database from the range files of a directory or tarball.
+func createFromRanges(reporter progress.Reporter) error {
+	if exactSidecar {
+		log.Fatal().Msg("the exact sidecar can only be created from a Pwned Passwords file")
+	}
+
+	abs, err := filepath.Abs(outFile)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("could not get absolute path of file")
+	}
+
+	if !overwrite {
+		if _, err = os.Stat(abs); !os.IsNotExist(err) {
+			log.Fatal().Msgf("file %s exists and overwrite flag is not set", outFile)
+		}
+	}
+
+	// A tarball is only checked once it's read
+	if info, err := os.Stat(inputFile); err == nil && info.IsDir() && !allowIncomplete {
+		dir, err := hibp.NewRangeDirectory(inputFile)
+		if err != nil {
+			return err
+		}
+		written, err := dir.WrittenRanges()
+		if err != nil {
+			return err
+		}
+		if len(written) < hibp.Ranges {
+			return incompleteRangesError(len(written))
+		}
+	}
+
+	estimated, err := estimateRangeItems(inputFile)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(abs)
+	if err != nil {
+		return err
+	}
+
+	defer func(out *os.File) {
+		if err = out.Close(); err != nil {
+			log.Error().Err(err).Msg("error closing GCS file")
+		}
+	}(out)
+
+	builder, err := gcs.NewStreamBuilder(out, estimated,
+		gcs.WithProbability(probability),
+		gcs.WithIndexGranularity(indexGranularity),
+		gcs.WithCompactIndex(compactIndex),
+		gcs.WithLogger(log.Logger),
+		gcs.WithProgress(reporter),
+	)
+	if err != nil {
+		return err
+	}
+
+	// Stop the process if not enough ram to actually hold all the entries read.
+	util.CheckRam(builder.EstimatedItems(), false)
+
+	s := util.Stats()
+	defer s()
+
+	log.Info().Msgf("reading ranges from %s", inputFile)
+	w := &builderRangeWriter{builder: builder}
+	read, err := hibp.ReadRanges(inputFile, w.addLine)
+	if err != nil {
+		return err
+	}
+	if read < hibp.Ranges {
+		if !allowIncomplete {
+			_ = os.Remove(abs)
+			return incompleteRangesError(read)
+		}
+		log.Warn().Msgf("%s has %d of the %d ranges, the database won't have the hashes of the missing ones", inputFile, read, hibp.Ranges)
+	}
+
+	return builder.Finish()
+}
+
+// incompleteRangesError is the error of a directory or tarball of ranges missing some of them,
+// as the database would answer that their hashes are not pwned.
+func incompleteRangesError(read int) error {
+	return fmt.Errorf("%s has %d of the %d ranges, the database would answer that the hashes of the missing ones are not pwned. "+
+		"Download the missing ranges with --resume, or use --allow-incomplete to create it anyway", inputFile, read, hibp.Ranges)
+}
+
+// estimateRangeItems estimates the hashes of a range directory or tarball from its size, about 40
+// bytes per line. A compressed tarball is about half the size.
+func estimateRangeItems(fileName string) (uint64, error) {
+	const lineSize = 40
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return 0, err
+	}
+
+	if !info.IsDir() {
+		size := uint64(info.Size())
+		if !strings.HasSuffix(strings.ToLower(fileName), ".tar") {
+			size *= 2
+		}
+		return size / lineSize, nil
+	}
+
+	entries, err := os.ReadDir(fileName)
+	if err != nil {
+		return 0, err
+	}
+
+	size := uint64(0)
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			size += uint64(info.Size())
+		}
+	}
+
+	return size / lineSize, nil
+}
@@ -0,0 +1,288 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/alvinbaena/pwd-checker/gcs"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"math"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// z-score of the 95% confidence interval
+const fprateZ = 1.96
+
+var (
+	fprateCmd = &cobra.Command{
+		Use:   "fprate",
+		Short: "Measure the real false positive rate of a GCS database",
+		Long: "Query a GCS database with hashes that are not in it, and compare the observed false positive rate " +
+			"with the configured one. Exits with an error if the configured rate is far off the observed one.",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return fprateCommand()
+		},
+	}
+)
+
+//goland:noinspection GoUnhandledErrorResult
+func init() {
+	fprateCmd.Flags().StringVarP(&inputFile, "in-file", "i", "", "Pwned Passwords GCS input file (required)")
+	fprateCmd.MarkFlagRequired("in-file")
+	fprateCmd.Flags().Uint64VarP(&samples, "samples", "n", 10_000_000, "Number of random SHA1 hashes to query. Ignored if --negatives is set.")
+	fprateCmd.Flags().Int64Var(&seed, "seed", 0, "Seed for the random SHA1 hashes. If omitted or 0 a random seed is used.")
+	fprateCmd.Flags().StringVar(&sourceFile, "source", "",
+		"Pwned Passwords file (SHA1) the database was created from. Random hashes present in it are not queried, "+
+			"guaranteeing that every match is a false positive.")
+	fprateCmd.Flags().StringVar(&negativesFile, "negatives", "",
+		"File with known negative SHA1 hashes, one per line, to use instead of random hashes.")
+	fprateCmd.Flags().Float64Var(&tolerance, "tolerance", 2,
+		"How many times the configured false positive rate can be off the 95% confidence interval of the observed rate before failing.")
+	fprateCmd.Flags().IntVarP(&threads, "threads", "t", 0, "Number of threads to use for the queries. If omitted or less than 1, defaults to the number of logical processors of the machine.")
+
+	rootCmd.AddCommand(fprateCmd)
+}
+
+func fprateCommand() error {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	applyCliSettings(verbose, profile, pprofPort)
+
+	if tolerance < 1 {
+		return fmt.Errorf("tolerance must be at least 1")
+	}
+
+	// No cache, every query must decode the file
+	reader, err := gcs.NewReader(inputFile, gcs.WithCache(0, 0), gcs.WithLogger(log.Logger))
+	if err != nil {
+		return err
+	}
+
+	if err = reader.Initialize(); err != nil {
+		return err
+	}
+
+	defer func(reader *gcs.Reader) {
+		if err := reader.Close(); err != nil {
+			log.Error().Err(err).Msg("error closing GCS file")
+		}
+	}(reader)
+
+	var keys []uint64
+	if negativesFile != "" {
+		if keys, err = readNegatives(negativesFile); err != nil {
+			return err
+		}
+	} else {
+		keys = randomKeys(samples, seed)
+		if sourceFile != "" {
+			if keys, err = removeSourceKeys(keys, sourceFile); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("there are no hashes to query")
+	}
+
+	matches, err := countMatches(reader, keys)
+	if err != nil {
+		return err
+	}
+
+	expected := 1 / float64(reader.Probability())
+	observed := float64(matches) / float64(len(keys))
+	low, high := wilsonInterval(matches, uint64(len(keys)), fprateZ)
+
+	log.Info().Msgf("%d false positives in %d queries", matches, len(keys))
+	log.Info().Msgf("configured false positive rate: %.3e (1 in %d)", expected, reader.Probability())
+	log.Info().Msgf("observed false positive rate:   %.3e (95%% confidence interval %.3e - %.3e)", observed, low, high)
+
+	if expected < low/tolerance || expected > high*tolerance {
+		return fmt.Errorf("configured false positive rate %.3e is outside the observed interval %.3e - %.3e (tolerance %.1fx)",
+			expected, low, high, tolerance)
+	}
+
+	log.Info().Msg("the observed false positive rate matches the configured one")
+	return nil
+}
+
+// randomKeys creates query keys of random SHA1 hashes
+func randomKeys(n uint64, seed int64) []uint64 {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	log.Info().Msgf("generating %d random hashes with seed %d", n, seed)
+
+	rnd := rand.New(rand.NewSource(seed))
+	keys := make([]uint64, n)
+	for i := range keys {
+		keys[i] = rnd.Uint64()
+	}
+
+	return keys
+}
+
+// removeSourceKeys removes the keys present in a Pwned Passwords file.
+func removeSourceKeys(keys []uint64, fileName string) ([]uint64, error) {
+	log.Info().Msgf("removing hashes present in %s", fileName)
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(file *os.File) {
+		if err := file.Close(); err != nil {
+			log.Error().Err(err).Msg("error closing Pwned Passwords file")
+		}
+	}(file)
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	present := make(map[uint64]bool)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) < 16 {
+			continue
+		}
+
+		hash, err := gcs.U64FromHex([]byte(line)[0:16])
+		if err != nil {
+			return nil, err
+		}
+
+		i := sort.Search(len(keys), func(i int) bool { return keys[i] >= hash })
+		if i < len(keys) && keys[i] == hash {
+			present[hash] = true
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	filtered := keys[:0]
+	for _, key := range keys {
+		if !present[key] {
+			filtered = append(filtered, key)
+		}
+	}
+
+	log.Info().Msgf("%d random hashes are present in the source file", len(keys)-len(filtered))
+	return filtered, nil
+}
+
+// readNegatives reads the query keys of a file with SHA1 hashes. Anything after the hash on each
+// line is ignored, so Pwned Passwords files can also be used.
+func readNegatives(fileName string) ([]uint64, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(file *os.File) {
+		if err := file.Close(); err != nil {
+			log.Error().Err(err).Msg("error closing negatives file")
+		}
+	}(file)
+
+	keys := make([]uint64, 0)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		key, err := gcs.KeyFromHex(text[0:min(len(text), 40)])
+		if err != nil {
+			return nil, fmt.Errorf("line %d of %s: %w", line, fileName, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, scanner.Err()
+}
+
+// countMatches queries all keys in parallel, returning the number of keys found.
+func countMatches(reader *gcs.Reader, keys []uint64) (uint64, error) {
+	workers := threads
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	log.Info().Msgf("querying %d hashes with %d threads", len(keys), workers)
+	var matches, done uint64
+	var firstErr error
+	var em sync.Mutex
+	wg := sync.WaitGroup{}
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	finished := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-finished:
+				return
+			case <-ticker.C:
+				log.Info().Msgf("%.2f%% queried", float64(atomic.LoadUint64(&done))*100/float64(len(keys)))
+			}
+		}
+	}()
+
+	chunk := (len(keys) + workers - 1) / workers
+	for start := 0; start < len(keys); start += chunk {
+		part := keys[start:min(start+chunk, len(keys))]
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			for _, key := range part {
+				found, err := reader.Exists(key)
+				if err != nil {
+					em.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					em.Unlock()
+					return
+				}
+
+				if found {
+					atomic.AddUint64(&matches, 1)
+				}
+				atomic.AddUint64(&done, 1)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(finished)
+	return matches, firstErr
+}
+
+// wilsonInterval is the Wilson score interval of a binomial proportion, it behaves well with very
+// small proportions like false positive rates.
+func wilsonInterval(successes uint64, trials uint64, z float64) (float64, float64) {
+	n := float64(trials)
+	p := float64(successes) / n
+	z2 := z * z
+
+	center := (p + z2/(2*n)) / (1 + z2/n)
+	margin := z / (1 + z2/n) * math.Sqrt(p*(1-p)/n+z2/(4*n*n))
+
+	return math.Max(0, center-margin), math.Min(1, center+margin)
+}
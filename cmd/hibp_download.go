@@ -4,12 +4,18 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
 	"github.com/alvinbaena/pwd-checker/hibp"
+	"github.com/alvinbaena/pwd-checker/internal/util"
+	"github.com/alvinbaena/pwd-checker/progress"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 )
 
 var (
@@ -25,7 +31,30 @@ var (
 //goland:noinspection GoUnhandledErrorResult
 func init() {
 	downloadCmd.Flags().StringVarP(&outFile, "out-file", "o", "./pwned-sha1.txt", "Output file path. Can be absolute or relative.")
+	downloadCmd.Flags().StringVar(&outDir, "out-dir", "",
+		"Write each range to its own file in this directory (00000.txt to FFFFF.txt), like the official PwnedPasswordsDownloader, instead of to the output file. "+
+			"With --resume only the ranges without a file are downloaded.")
 	downloadCmd.Flags().BoolVar(&overwrite, "overwrite", false, "Overwrite any existing files while writing the results.")
+	downloadCmd.Flags().BoolVar(&resume, "resume", false,
+		"Resume a previous download to the same output file, downloading only the ranges missing from its checkpoint file (the output file name with .checkpoint added). "+
+			"The checkpoint is saved while downloading and when the download is stopped with ^C.")
+	downloadCmd.Flags().BoolVar(&retryFailed, "retry-failed", false,
+		"Download only the ranges that failed in a previous download to the same output file, listed in its failed ranges file (the output file name with .failed added).")
+	downloadCmd.Flags().BoolVar(&ordered, "ordered", false,
+		"Write the ranges in prefix order, so the output file is sorted by hash and the same data always produces the same file. "+
+			"Ranges downloaded with --resume or --retry-failed are appended, and the file is rewritten in prefix order once it's complete, which needs disk space for a copy of it. Not available with --out-dir.")
+	downloadCmd.Flags().StringVar(&previousFile, "previous", "",
+		"Output file of a previous download to refresh. Only the ranges that changed since then are downloaded, the others are copied from it. "+
+			"The ranges that changed are listed in a file next to the output (the output file name with .changed added).")
+	downloadCmd.Flags().StringVar(&baseURL, "base-url", hibp.DefaultBaseURL, "Base URL of the Pwned Passwords range API, ranges are requested from {base-url}/range/{prefix}.")
+	downloadCmd.Flags().StringSliceVar(&mirrors, "mirror", nil,
+		"Base URL of a mirror of the range API, used when a request to the base URL fails. Can be repeated, mirrors are used in order.")
+	downloadCmd.Flags().StringVar(&proxyURL, "proxy", "", "HTTP(S) proxy URL for the requests. By default the HTTP_PROXY and HTTPS_PROXY environment variables are used.")
+	downloadCmd.Flags().StringVar(&caBundle, "ca-bundle", "", "PEM file with CA certificates to trust besides the system ones, e.g. the CA of a TLS inspecting proxy.")
+	downloadCmd.Flags().IntVar(&rateLimit, "rate-limit", 0, "Max requests per second, retries included. 0 means no limit.")
+	downloadCmd.Flags().StringVar(&fromPrefix, "from", "00000",
+		"First range prefix to download. With --to, the download can be split in shards run on different machines, put together with the concat command.")
+	downloadCmd.Flags().StringVar(&toPrefix, "to", "FFFFF", "Last range prefix to download, included.")
 	downloadCmd.Flags().IntVarP(&threads, "threads", "t", 0, "Number of threads to use for the download. If omitted or less than 2, defaults to eight times the number of logical processors of the machine.")
 
 	rootCmd.AddCommand(downloadCmd)
@@ -35,19 +64,51 @@ func downloadCommand() error {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	applyCliSettings(verbose, profile, pprofPort)
 
+	reporter, closeProgress, err := progressReporter()
+	if err != nil {
+		return err
+	}
+	defer closeProgress()
+
+	first, last, err := prefixes(fromPrefix, toPrefix)
+	if err != nil {
+		return err
+	}
+
+	if outDir != "" {
+		return downloadToDirectory(first, last, reporter)
+	}
+
 	abs, err := filepath.Abs(outFile)
 	if err != nil {
 		log.Fatal().Err(err).Msgf("could not get absolute path of file")
 	}
 
-	if !overwrite {
-		_, err := os.Stat(abs)
-		if err == nil {
-			log.Fatal().Msgf("file %s exists and overwrite flag is not set", abs)
+	if previousFile != "" {
+		previous, err := filepath.Abs(previousFile)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("could not get absolute path of file")
+		}
+
+		if previous == abs {
+			log.Fatal().Msg("the previous download can't be refreshed in place, use a different output file")
 		}
 	}
 
-	file, err := os.Create(abs)
+	var file *os.File
+	if resume || retryFailed {
+		// Keep the contents of the file, the downloader truncates it to the saved checkpoint
+		file, err = os.OpenFile(abs, os.O_RDWR|os.O_CREATE, 0644)
+	} else {
+		if !overwrite {
+			_, err := os.Stat(abs)
+			if err == nil {
+				log.Fatal().Msgf("file %s exists and overwrite flag is not set", abs)
+			}
+		}
+
+		file, err = os.Create(abs)
+	}
 	if err != nil {
 		return err
 	}
@@ -58,10 +119,100 @@ func downloadCommand() error {
 		}
 	}(file)
 
-	d := hibp.NewDownloader(file, threads)
-	if err = d.ProcessRanges(1024*1024, false); err != nil {
+	// Stop the download cleanly on ^C, saving the checkpoint
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	d, err := hibp.NewDownloader(file, threads,
+		hibp.WithResume(resume),
+		hibp.WithRetryFailed(retryFailed),
+		hibp.WithOrderedOutput(ordered),
+		hibp.WithPrevious(previousFile),
+		hibp.WithBaseURL(baseURL),
+		hibp.WithMirrors(mirrors...),
+		hibp.WithProxy(proxyURL),
+		hibp.WithCABundle(caBundle),
+		hibp.WithRateLimit(rateLimit),
+		hibp.WithProgress(reporter),
+	)
+	if err != nil {
+		return err
+	}
+
+	if err = d.ProcessPrefixes(ctx, first, last, false); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+func downloadToDirectory(first int, last int, reporter progress.Reporter) error {
+	if retryFailed || previousFile != "" {
+		log.Fatal().Msg("--retry-failed and --previous need an output file, use --resume to download the missing ranges of a directory")
+	}
+	if ordered {
+		log.Fatal().Msg("--ordered needs an output file, the ranges of a directory are in their own files")
+	}
+
+	abs, err := filepath.Abs(outDir)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("could not get absolute path of directory")
+	}
+
+	dir, err := hibp.NewRangeDirectory(abs)
+	if err != nil {
+		return err
+	}
+
+	if !overwrite && !resume {
+		written, err := dir.WrittenRanges()
+		if err != nil {
+			return err
+		}
+		if len(written) > 0 {
+			log.Fatal().Msgf("directory %s has %d ranges and neither the overwrite nor the resume flag is set", abs, len(written))
+		}
+	}
+
+	// 40GiB for all the ranges
+	util.CheckDiskSpace(abs, (40*(last-first+1)+hibp.Ranges-1)/hibp.Ranges)
+
+	// Stop the download cleanly on ^C, the ranges already written are kept
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	d, err := hibp.NewDownloader(nil, threads,
+		hibp.WithRangeWriter(dir),
+		hibp.WithResume(resume),
+		hibp.WithBaseURL(baseURL),
+		hibp.WithMirrors(mirrors...),
+		hibp.WithProxy(proxyURL),
+		hibp.WithCABundle(caBundle),
+		hibp.WithRateLimit(rateLimit),
+		hibp.WithProgress(reporter),
+	)
+	if err != nil {
+		return err
+	}
+
+	return d.ProcessPrefixes(ctx, first, last, false)
+}
+
+// prefixes parses the first and last prefixes to download.
+func prefixes(from string, to string) (int, int, error) {
+	first, err := hibp.ParsePrefix(from)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid --from prefix %q: %w", from, err)
+	}
+
+	last, err := hibp.ParsePrefix(to)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid --to prefix %q: %w", to, err)
+	}
+
+	if first > last {
+		return 0, 0, fmt.Errorf("the --from prefix %s is after the --to prefix %s", from, to)
+	}
+
+	return first, last, nil
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"github.com/alvinbaena/pwd-checker/progress"
+	"github.com/rs/zerolog/log"
+	"os"
+)
+
+// progressReporter reports the progress to the log, and also as JSON lines to the --progress-json
+// file (- for stdout) if set. The returned function closes the file.
+func progressReporter() (progress.Reporter, func(), error) {
+	reporter := progress.NewLogReporter(log.Logger)
+	if progressJSON == "" {
+		return reporter, func() {}, nil
+	}
+
+	if progressJSON == "-" {
+		return progress.Multi(reporter, progress.NewJSONReporter(os.Stdout)), func() {}, nil
+	}
+
+	file, err := os.Create(progressJSON)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return progress.Multi(reporter, progress.NewJSONReporter(file)), func() {
+		if err := file.Close(); err != nil {
+			log.Error().Err(err).Msg("error closing progress file")
+		}
+	}, nil
+}
@@ -6,9 +6,12 @@ package main
 import (
 	"github.com/alvinbaena/pwd-checker/cmd"
 	"github.com/rs/zerolog"
+	"os"
 )
 
 func main() {
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	_ = cmd.Execute()
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
 }
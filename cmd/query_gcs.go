@@ -4,8 +4,6 @@
 package cmd
 
 import (
-	"crypto/sha1"
-	"encoding/binary"
 	"fmt"
 	"github.com/alvinbaena/pwd-checker/gcs"
 	"github.com/manifoldco/promptui"
@@ -13,8 +11,6 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"os"
-	"regexp"
-	"strings"
 )
 
 var (
@@ -55,12 +51,27 @@ func queryCommand(password string) (err error) {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	applyCliSettings(verbose, profile, pprofPort)
 
-	searcher := gcs.NewReader(inputFile)
+	opts := []gcs.Option{gcs.WithLogger(log.Logger)}
+	// The exact sidecar is optional, and used if it's next to the GCS file
+	if _, err = os.Stat(gcs.SidecarFileName(inputFile)); err == nil {
+		opts = append(opts, gcs.WithExactSidecar(gcs.SidecarFileName(inputFile)))
+	}
+
+	searcher, err := gcs.NewReader(inputFile, opts...)
+	if err != nil {
+		return
+	}
+
 	if err = searcher.Initialize(); err != nil {
 		return
 	}
 
-	var hash uint64
+	defer func(searcher *gcs.Reader) {
+		if err := searcher.Close(); err != nil {
+			log.Error().Err(err).Msg("error closing GCS file")
+		}
+	}(searcher)
+
 	if interactive {
 		var label string
 		if hashed {
@@ -77,9 +88,8 @@ func queryCommand(password string) (err error) {
 				}
 
 				if hashed {
-					match, _ := regexp.MatchString("^[a-fA-F\\d]{40}$", password)
-					if !match {
-						return fmt.Errorf("input is not a valid SHA1 Hexadecimal hash")
+					if _, err := gcs.KeyFromHex(input); err != nil {
+						return err
 					}
 				}
 				return nil
@@ -103,9 +113,9 @@ func queryCommand(password string) (err error) {
 			return nil
 		}
 	} else {
-		hash, err = processPassword(password)
+		hash, err := processPassword(password)
 		if err != nil {
-			return
+			return err
 		}
 
 		return queryDatabase(hash, searcher)
@@ -124,6 +134,7 @@ func runInteractiveSession(prompt promptui.Prompt, searcher *gcs.Reader) error {
 		hash, err := processPassword(result)
 		if err != nil {
 			log.Error().Err(err).Msg("error processing input")
+			continue
 		}
 
 		if err = queryDatabase(hash, searcher); err != nil {
@@ -132,14 +143,16 @@ func runInteractiveSession(prompt promptui.Prompt, searcher *gcs.Reader) error {
 	}
 }
 
-func queryDatabase(hash uint64, searcher *gcs.Reader) error {
-	exists, err := searcher.Exists(hash)
+func queryDatabase(hash gcs.Hash, searcher *gcs.Reader) error {
+	result, err := searcher.Lookup(hash)
 	if err != nil {
 		return err
 	}
 
-	if exists {
+	if result.Found && result.Exact {
 		log.Info().Msgf("password is present")
+	} else if result.Found {
+		log.Info().Msgf("password is probably present")
 	} else {
 		log.Info().Msgf("password is not present")
 	}
@@ -147,19 +160,10 @@ func queryDatabase(hash uint64, searcher *gcs.Reader) error {
 	return nil
 }
 
-func processPassword(password string) (uint64, error) {
+func processPassword(password string) (gcs.Hash, error) {
 	if hashed {
-		if match, _ := regexp.MatchString("^[a-fA-F\\d]{40}$", password); !match {
-			return 0, fmt.Errorf("input is not a valid SHA1 Hexadecimal hash")
-		}
-
-		// The hash must be uppercase
-		return gcs.U64FromHex([]byte(strings.ToUpper(password))[0:16]), nil
+		return gcs.HashFromHex(password)
 	} else {
-		h := sha1.New()
-		h.Write([]byte(password))
-		buf := h.Sum(nil)
-		val := binary.BigEndian.Uint64(buf)
-		return val, nil
+		return gcs.HashFromPassword(password), nil
 	}
 }
@@ -0,0 +1,78 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"github.com/alvinbaena/pwd-checker/hibp"
+	"github.com/alvinbaena/pwd-checker/internal/util"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+var (
+	rangeStoreCmd = &cobra.Command{
+		Use:   "range-store",
+		Short: "Create a range store from a Pwned Passwords file (SHA1), for serving the /range/{prefix} API",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rangeStoreCommand()
+		},
+	}
+)
+
+//goland:noinspection GoUnhandledErrorResult
+func init() {
+	rangeStoreCmd.Flags().StringVarP(&inputFile, "in-file", "i", "", "Pwned passwords input file path, as written by the download command (required)")
+	rangeStoreCmd.MarkFlagRequired("in-file")
+	rangeStoreCmd.Flags().StringVarP(&outFile, "out-file", "o", "./pwned.range", "Range store output path")
+	rangeStoreCmd.Flags().BoolVar(&overwrite, "overwrite", false, "Overwrite any existing files while writing the results.")
+
+	rootCmd.AddCommand(rangeStoreCmd)
+}
+
+func rangeStoreCommand() error {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	applyCliSettings(verbose, profile, pprofPort)
+
+	file, err := os.Open(inputFile)
+	if err != nil {
+		return err
+	}
+
+	defer func(file *os.File) {
+		if err = file.Close(); err != nil {
+			log.Error().Err(err).Msg("error closing Pwned Passwords file")
+		}
+	}(file)
+
+	abs, err := filepath.Abs(outFile)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("could not get absolute path of file")
+	}
+
+	if !overwrite {
+		_, err = os.Stat(abs)
+		if !os.IsNotExist(err) {
+			log.Fatal().Msgf("file %s exists and overwrite flag is not set", outFile)
+		}
+	}
+
+	out, err := os.Create(abs)
+	if err != nil {
+		return err
+	}
+
+	defer func(out *os.File) {
+		if err = out.Close(); err != nil {
+			log.Error().Err(err).Msg("error closing range store file")
+		}
+	}(out)
+
+	s := util.Stats()
+	defer s()
+
+	return hibp.NewStoreBuilder(file, out).Process()
+}
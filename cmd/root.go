@@ -9,8 +9,13 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"net/http"
+	"runtime/debug"
 )
 
+// version of the build, set with -ldflags "-X github.com/alvinbaena/pwd-checker/cmd.version=v1.2.3". When not
+// set, the version of the module or the VCS revision is used.
+var version string
+
 var (
 	rootCmd = &cobra.Command{
 		Use:   "pwdcheck [COMMAND] [OPTIONS]",
@@ -21,11 +26,36 @@ var (
 )
 
 func init() {
+	rootCmd.Version = buildVersion()
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Print more information on the processing")
 	rootCmd.PersistentFlags().BoolVar(&profile, "profile", false, "Enable the profiling server (pprof) when running commands")
+	rootCmd.PersistentFlags().StringVar(&progressJSON, "progress-json", "",
+		"Also write the progress of the create, download and build-from-hibp commands to this file as JSON lines, - for stdout.")
 	rootCmd.PersistentFlags().Uint16Var(&pprofPort, "profile-port", 6060, "The port to use for the pprof server. Only used if the profile flag is set")
 }
 
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+
+	return "devel"
+}
+
 func Execute() error {
 	return rootCmd.Execute()
 }
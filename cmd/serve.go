@@ -6,19 +6,21 @@ package cmd
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
-	"encoding/pem"
 	"fmt"
+	"github.com/alvinbaena/pwd-checker/hibp"
 	"github.com/alvinbaena/pwd-checker/internal/api"
 	"github.com/gin-contrib/logger"
 	"github.com/gin-gonic/gin"
-	"github.com/likexian/selfca"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 	"time"
 )
@@ -38,10 +40,48 @@ func init() {
 	serveCmd.Flags().StringVarP(&inputFile, "in-file", "i", "", "Pwned Passwords GCS input file (required)")
 	serveCmd.MarkFlagRequired("in-file")
 	serveCmd.Flags().BoolVar(&selfTLS, "self-tls", false,
-		"If the server should use a self-signed certificate when starting. The certificate is renewed on each server restart")
-	serveCmd.Flags().StringVar(&tlsCert, "tls-cert", "", "Path to the PEM encoded TLS certificate to be used by the server")
-	serveCmd.Flags().StringVar(&tlsCert, "tls-key", "", "Path to the PEM encoded TLS private key to be used by the server")
-	serveCmd.Flags().Uint16VarP(&port, "port", "p", 3100, "Port to be used by the server")
+		"If the server should use a self-signed certificate. Without --tls-state-dir the certificate is renewed on each server restart")
+	serveCmd.Flags().StringVar(&tlsStateDir, "tls-state-dir", "",
+		"Directory where the self-signed certificate and its key are saved, to be reused by the next server starts until they expire")
+	serveCmd.Flags().StringSliceVar(&tlsSANs, "tls-san", defaultSANs(),
+		"Host names and IP addresses of the self-signed certificate. Can be repeated")
+	serveCmd.Flags().DurationVar(&selfTLSValidity, "self-tls-validity", 30*24*time.Hour,
+		"Validity of the self-signed certificate. It is renewed when less than a tenth of it is left")
+	serveCmd.Flags().StringVar(&tlsCert, "tls-cert", "",
+		"Path to the PEM encoded TLS certificate to be used by the server. It is reloaded when it changes, like the database")
+	serveCmd.Flags().StringVar(&tlsKey, "tls-key", "", "Path to the PEM encoded TLS private key to be used by the server")
+	serveCmd.Flags().StringVar(&tlsMinVersion, "tls-min-version", "1.2", "Minimum TLS version accepted by the server: 1.0, 1.1, 1.2 or 1.3")
+	serveCmd.Flags().StringSliceVar(&tlsCiphers, "tls-ciphers", nil,
+		"Comma separated TLS 1.0-1.2 cipher suites accepted by the server, like TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. By default Go's secure cipher suites are used")
+	serveCmd.Flags().StringVar(&clientCA, "client-ca", "",
+		"Path to the PEM encoded CA certificates of the clients. When set, clients must present a certificate signed by one of them")
+	serveCmd.Flags().StringVar(&apiKeysFile, "api-keys-file", "",
+		"File with the SHA256 hashes of the API keys allowed to call the API, one per line, optionally followed by a name for the key. "+
+			"It is reloaded with the database")
+	serveCmd.Flags().StringSliceVar(&apiKeyHashes, "api-key-hash", nil, "SHA256 hash of an API key allowed to call the API. Can be repeated")
+	serveCmd.Flags().StringSliceVar(&rateLimits, "rate-limit", nil,
+		"Requests per second allowed to each client, by API key or IP address, as [ROUTE=]RATE[:BURST]. Without a route it's the limit of the "+
+			"routes without their own, like /v1/check/password=5:10 or /pwdchecker.v1.PwdChecker/CheckHash=50. Can be repeated")
+	serveCmd.Flags().IntVar(&maxConcurrent, "max-concurrent", 0, "Maximum number of queries running at the same time, 0 for no limit")
+	serveCmd.Flags().DurationVar(&queueTimeout, "queue-timeout", 0,
+		"How long a query waits for its turn when --max-concurrent queries are running, before failing with a 429 response. 0 fails right away")
+	serveCmd.Flags().Uint16VarP(&port, "port", "p", 3100, "Port to be used by the server when --listen is not set")
+	serveCmd.Flags().StringSliceVar(&listen, "listen", nil,
+		"Address of the REST API as tls://HOST:PORT, tcp://HOST:PORT (plain HTTP) or unix://PATH (plain HTTP on a Unix socket). "+
+			"Can be repeated. Defaults to tls://:PORT")
+	serveCmd.Flags().BoolVar(&insecurePlaintext, "insecure-plaintext", false,
+		"Allows serving the REST API on tcp:// addresses and the gRPC API without TLS, for servers behind a proxy that terminates TLS")
+	serveCmd.Flags().StringSliceVar(&trustedProxies, "trusted-proxies", nil,
+		"IP addresses or CIDR ranges of the proxies in front of the server, whose X-Forwarded-For and X-Real-IP headers are used as the client IP "+
+			"in logs and rate limits, also in the metadata of gRPC calls. Requests of Unix sockets come from 127.0.0.1")
+	serveCmd.Flags().Uint16Var(&grpcPort, "grpc-port", 0,
+		"Port of the gRPC API, served with the same TLS configuration as the REST API, or without TLS with --insecure-plaintext "+
+			"when there is none. 0 disables the gRPC API")
+	serveCmd.Flags().DurationVar(&reloadInterval, "reload-interval", time.Minute,
+		"How often to check the GCS input file, the API keys file and the TLS certificate for changes, reloading them when they change. 0 disables the check. "+
+			"They can also be reloaded by sending a SIGHUP signal to the process")
+	serveCmd.Flags().StringVar(&rangeStore, "range-store", "",
+		"Range store file, created with the range-store command. When set, the server also exposes the Pwned Passwords compatible GET /range/{prefix} API")
 
 	rootCmd.AddCommand(serveCmd)
 }
@@ -53,88 +93,266 @@ func serveCommand() error {
 	}
 
 	router := gin.New()
+	// Without trusted proxies, the client IP is the address of the connection
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		return fmt.Errorf("invalid trusted proxies: %s", err)
+	}
 	router.Use(gin.Recovery())
 	router.Use(logger.SetLogger(logger.WithLogger(func(c *gin.Context, z zerolog.Logger) zerolog.Logger {
 		return zerolog.New(gin.DefaultWriter).With().Timestamp().Logger()
 	})))
 
-	v1 := router.Group("/v1")
+	metrics := api.NewMetrics()
+	router.Use(metrics.Middleware())
 
-	pwned := v1.Group("/check")
-	if err := api.RegisterQueryApi(pwned, inputFile); err != nil {
-		return fmt.Errorf("error initializing API: %s", err)
+	db := api.NewDatabase(inputFile, metrics)
+	metrics.RegisterDatabase(db)
+	router.GET("/metrics", metrics.Handler())
+	api.RegisterHealthApi(&router.RouterGroup, db)
+	api.RegisterOpenAPI(&router.RouterGroup)
+	router.NoRoute(api.NotFound)
+
+	defer func(db *api.Database) {
+		if err := db.Close(); err != nil {
+			log.Error().Err(err).Msg("error closing GCS database")
+		}
+	}(db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var keys *api.APIKeys
+	if apiKeysFile != "" || len(apiKeyHashes) > 0 {
+		var err error
+		if keys, err = api.NewAPIKeys(apiKeysFile, apiKeyHashes); err != nil {
+			return fmt.Errorf("error loading API keys: %s", err)
+		}
 	}
 
-	srvAddr := fmt.Sprintf(":%d", port)
-	srv := &http.Server{
-		Addr:    srvAddr,
-		Handler: router,
+	if reloadInterval > 0 && keys != nil {
+		go keys.Watch(ctx, reloadInterval)
 	}
 
-	go func() {
-		log.Info().Msgf("starting TLS Server on address: %s", srvAddr)
-		if tlsCert != "" && tlsKey != "" {
-			// service connections with tls certs
-			if err := srv.ListenAndServeTLS(tlsCert, tlsKey); err != nil && err != http.ErrServerClosed {
-				log.Fatal().Err(err).Msg("error starting server")
-			}
-		} else if selfTLS {
-			log.Warn().Msgf("using auto self-signed certificate for TLS. This is not recommended for production. Please consider using your own certificates.")
-			caConfig := selfca.Certificate{
-				IsCA:      true,
-				KeySize:   2048,
-				NotBefore: time.Now(),
-				// 30 day self-signed cert.
-				NotAfter: time.Now().Add(time.Duration(30*24) * time.Hour),
-			}
+	// Checks of the API routes, in order
+	var middleware []gin.HandlerFunc
+	unaryInterceptors := []grpc.UnaryServerInterceptor{metrics.UnaryInterceptor()}
+	streamInterceptors := []grpc.StreamServerInterceptor{metrics.StreamInterceptor()}
+	if keys != nil {
+		middleware = append(middleware, keys.Middleware())
+		unaryInterceptors = append(unaryInterceptors, keys.UnaryInterceptor())
+		streamInterceptors = append(streamInterceptors, keys.StreamInterceptor())
+	}
 
-			// generating the certificate
-			certificate, key, err := selfca.GenerateCertificate(caConfig)
+	if len(rateLimits) > 0 || maxConcurrent > 0 {
+		limits := make(map[string]api.RateLimit)
+		for _, text := range rateLimits {
+			route, limit, err := api.ParseRateLimit(text)
 			if err != nil {
-				log.Fatal().Err(err).Msg("error generating auto self-signed certificate")
+				return err
 			}
+			limits[route] = limit
+		}
 
-			pair, err := tls.X509KeyPair(
-				pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}),
-				pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
-			)
-			if err != nil {
-				log.Fatal().Err(err).Msg("error using auto self-signed certificate")
+		limiter := api.NewLimiter(limits, maxConcurrent, queueTimeout)
+		if err := limiter.SetTrustedProxies(trustedProxies); err != nil {
+			return err
+		}
+		middleware = append(middleware, limiter.Middleware())
+		unaryInterceptors = append(unaryInterceptors, limiter.UnaryInterceptor())
+		streamInterceptors = append(streamInterceptors, limiter.StreamInterceptor())
+	}
+
+	validator, err := api.NewValidator()
+	if err != nil {
+		return err
+	}
+
+	v1 := router.Group("/v1", middleware...)
+	v1.Use(validator.Middleware())
+
+	pwned := v1.Group("/check")
+	api.RegisterQueryApi(pwned, db)
+	api.RegisterInfoApi(v1, db, buildVersion())
+
+	if rangeStore != "" {
+		store, err := hibp.OpenStore(rangeStore)
+		if err != nil {
+			return fmt.Errorf("error opening range store: %s", err)
+		}
+
+		defer func(store *hibp.Store) {
+			if err := store.Close(); err != nil {
+				log.Error().Err(err).Msg("error closing range store")
 			}
+		}(store)
+
+		rangeGroup := router.Group("/", middleware...)
+		rangeGroup.Use(validator.TextMiddleware())
+		api.RegisterRangeApi(rangeGroup, store)
+	}
+
+	addrs, err := listenAddrs()
+	if err != nil {
+		return err
+	}
 
-			srv.TLSConfig = &tls.Config{
-				Certificates: []tls.Certificate{pair},
+	// TLS is only configured if some server uses it
+	tlsListener := slices.ContainsFunc(addrs, func(a listenAddr) bool { return a.scheme == "tls" })
+	var tlsConfig *tls.Config
+	var certs *serverCertificates
+	if tlsConfigured() || tlsListener {
+		if certs, err = newServerCertificates(); err != nil {
+			return err
+		}
+		if tlsConfig, err = serverTLSConfig(certs); err != nil {
+			return err
+		}
+		if reloadInterval > 0 {
+			go certs.Watch(ctx, reloadInterval)
+		}
+	}
+
+	if grpcPort > 0 && tlsConfig == nil && !insecurePlaintext {
+		return fmt.Errorf("serving the gRPC API without TLS requires the --insecure-plaintext flag")
+	}
+
+	if clientCA != "" && !tlsListener && (grpcPort == 0 || tlsConfig == nil) {
+		return fmt.Errorf("the --client-ca flag requires a tls:// listener or the gRPC API with TLS, client certificates are not checked without TLS")
+	}
+
+	// The keys are sent in plain text, they should not leave the host or the network of the proxy
+	if keys != nil {
+		for _, addr := range addrs {
+			if addr.scheme == "tcp" && !addr.loopback() {
+				log.Warn().Msgf("API keys are sent without TLS to %s, which is not a loopback address. "+
+					"Only expose it to a proxy that terminates TLS", addr)
 			}
+		}
+		if grpcPort > 0 && tlsConfig == nil {
+			log.Warn().Msgf("API keys are sent without TLS to the gRPC API on port %d. Only expose it to a proxy that terminates TLS", grpcPort)
+		}
+	}
 
-			// service connections with tls config, no need to pass files
-			if err = srv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
-				log.Fatal().Err(err).Msg("error starting server")
+	// A server for each address, the HTTP/2 setup of a server depends on if it uses TLS
+	servers := make([]*http.Server, 0, len(addrs))
+	for _, addr := range addrs {
+		lis, err := addr.listen()
+		if err != nil {
+			return fmt.Errorf("error starting server on %s: %s", addr, err)
+		}
+
+		srv := &http.Server{Handler: unixAsLoopback(router)}
+		if addr.scheme == "tls" {
+			srv.TLSConfig = tlsConfig
+		}
+		servers = append(servers, srv)
+
+		go func(addr listenAddr, lis net.Listener) {
+			log.Info().Msgf("starting server on address: %s", addr)
+			var err error
+			if addr.scheme == "tls" {
+				// service connections with tls config, no need to pass files
+				err = srv.ServeTLS(lis, "", "")
+			} else {
+				err = srv.Serve(lis)
 			}
-		} else {
-			log.Fatal().Msg("server requires TLS configuration to start. " +
-				"Please use either the --self-tls flag or set a certificate with the --tls-cert and --tls-key flags")
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatal().Err(err).Msgf("error starting server on %s", addr)
+			}
+		}(addr, lis)
+	}
+
+	var grpcSrv *grpc.Server
+	if grpcPort > 0 {
+		opts := []grpc.ServerOption{
+			grpc.ChainUnaryInterceptor(unaryInterceptors...),
+			grpc.ChainStreamInterceptor(streamInterceptors...),
+		}
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+
+		grpcSrv = grpc.NewServer(opts...)
+		api.RegisterGrpcApi(grpcSrv, db)
+
+		grpcAddr := fmt.Sprintf(":%d", grpcPort)
+		lis, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			return fmt.Errorf("error starting gRPC server: %s", err)
+		}
+
+		go func() {
+			log.Info().Msgf("starting gRPC Server on address: %s", grpcAddr)
+			if err := grpcSrv.Serve(lis); err != nil {
+				log.Fatal().Err(err).Msg("error starting gRPC server")
+			}
+		}()
+	}
+
+	// The database is loaded once the servers are up, so /healthz answers and /readyz fails until
+	// the database is ready
+	go func() {
+		if err := db.Reload(); err != nil {
+			log.Fatal().Err(err).Msg("error initializing API")
+		}
+
+		if reloadInterval > 0 {
+			db.Watch(ctx, reloadInterval)
 		}
 	}()
 
-	gracefulShutdown(srv)
+	gracefulShutdown(servers, grpcSrv, func() {
+		if err := db.Reload(); err != nil {
+			log.Error().Err(err).Msg("error reloading GCS database, keeping the current one")
+		} else {
+			log.Info().Msg("GCS database reloaded")
+		}
+
+		if keys != nil {
+			if err := keys.Reload(); err != nil {
+				log.Error().Err(err).Msg("error reloading API keys, keeping the current ones")
+			} else {
+				log.Info().Msg("API keys reloaded")
+			}
+		}
+
+		if certs != nil {
+			if err := certs.Reload(); err != nil {
+				log.Error().Err(err).Msg("error reloading TLS certificate, keeping the current one")
+			} else {
+				log.Info().Msg("TLS certificate reloaded")
+			}
+		}
+	})
 	return nil
 }
 
-func gracefulShutdown(srv *http.Server) {
+// gracefulShutdown waits for a signal to stop the servers, calling reload in the background on SIGHUP.
+func gracefulShutdown(servers []*http.Server, grpcSrv *grpc.Server, reload func()) {
 	// Wait for interrupt signal to gracefully shut down the server with
 	// a timeout.
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	// kill (no param) default send syscall.SIGTERM
 	// kill -2 is syscall.SIGINT
 	// kill -9 is syscall. SIGKILL but can't be a catch, so don't need to add it
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	// kill -1 is syscall.SIGHUP, used to reload the database, API keys and TLS certificate
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := <-quit; sig == syscall.SIGHUP; sig = <-quit {
+		log.Info().Msg("SIGHUP received, reloading database, API keys and TLS certificate")
+		go reload()
+	}
 	log.Info().Msg("shutting down server")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Warn().Err(err).Msg("server Shutdown.")
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Warn().Err(err).Msg("server Shutdown.")
+		}
+	}
+	if grpcSrv != nil {
+		// Running streams are not waited for, they may never end
+		grpcSrv.Stop()
 	}
 	// catching ctx.Done(). timeout of 5 seconds.
 	select {
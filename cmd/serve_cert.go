@@ -0,0 +1,283 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/alvinbaena/pwd-checker/internal/util"
+	"github.com/likexian/selfca"
+	"github.com/rs/zerolog/log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	selfSignedCertFile = "self-signed.crt"
+	selfSignedKeyFile  = "self-signed.key"
+)
+
+// serverCertificates holds the certificate of the servers, which is replaced without a restart when
+// its files change, or before it expires when it's self-signed.
+type serverCertificates struct {
+	current atomic.Pointer[tls.Certificate]
+	// Only one reload at a time
+	rm                sync.Mutex
+	certInfo, keyInfo os.FileInfo
+	// Files that could not be loaded, not retried until they change
+	failedCert, failedKey os.FileInfo
+}
+
+// newServerCertificates loads the certificate of the --tls-cert and --tls-key files, or a
+// self-signed one with --self-tls.
+func newServerCertificates() (*serverCertificates, error) {
+	if (tlsCert == "") != (tlsKey == "") {
+		return nil, fmt.Errorf("the --tls-cert and --tls-key flags must be used together")
+	}
+
+	if tlsCert == "" && !selfTLS {
+		return nil, fmt.Errorf("server requires TLS configuration to start. " +
+			"Please use either the --self-tls flag or set a certificate with the --tls-cert and --tls-key flags")
+	}
+
+	if tlsCert == "" {
+		log.Warn().Msg("using auto self-signed certificate for TLS. This is not recommended for production. Please consider using your own certificates.")
+	}
+
+	s := &serverCertificates{}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// GetCertificate is the tls.Config callback, so new connections use the current certificate.
+func (s *serverCertificates) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.current.Load(), nil
+}
+
+// Reload reads the certificate files again, or gets a self-signed certificate if the current one
+// expires soon. If the files are not valid the current certificate is kept.
+func (s *serverCertificates) Reload() error {
+	s.rm.Lock()
+	defer s.rm.Unlock()
+
+	if tlsCert == "" {
+		if current := s.current.Load(); current != nil && !expiresSoon(current.Leaf) {
+			return nil
+		}
+
+		pair, err := selfSignedCertificate()
+		if err != nil {
+			return err
+		}
+
+		s.current.Store(&pair)
+		return nil
+	}
+
+	certInfo, err := os.Stat(tlsCert)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(tlsKey)
+	if err != nil {
+		return err
+	}
+
+	pair, err := loadKeyPair(tlsCert, tlsKey)
+	if err != nil {
+		s.failedCert, s.failedKey = certInfo, keyInfo
+		return fmt.Errorf("error loading TLS certificate: %s", err)
+	}
+
+	s.current.Store(&pair)
+	s.certInfo, s.keyInfo = certInfo, keyInfo
+	return nil
+}
+
+// Watch checks every interval if the certificate files changed, or if the self-signed certificate
+// expires soon, and reloads the certificate.
+func (s *serverCertificates) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.changed() {
+				continue
+			}
+
+			log.Info().Msg("TLS certificate changed or expires soon, reloading it")
+			if err := s.Reload(); err != nil {
+				log.Error().Err(err).Msg("error reloading TLS certificate, keeping the current one")
+			} else {
+				log.Info().Msg("TLS certificate reloaded")
+			}
+		}
+	}
+}
+
+func (s *serverCertificates) changed() bool {
+	if tlsCert == "" {
+		return expiresSoon(s.current.Load().Leaf)
+	}
+
+	certInfo, err := os.Stat(tlsCert)
+	if err != nil {
+		log.Warn().Err(err).Msgf("error checking TLS certificate file %s", tlsCert)
+		return false
+	}
+	keyInfo, err := os.Stat(tlsKey)
+	if err != nil {
+		log.Warn().Err(err).Msgf("error checking TLS key file %s", tlsKey)
+		return false
+	}
+
+	s.rm.Lock()
+	defer s.rm.Unlock()
+
+	if s.failedCert != nil && util.SameFileInfo(s.failedCert, certInfo) && util.SameFileInfo(s.failedKey, keyInfo) {
+		return false
+	}
+
+	return !util.SameFileInfo(s.certInfo, certInfo) || !util.SameFileInfo(s.keyInfo, keyInfo)
+}
+
+// expiresSoon checks if the certificate is in the last tenth of its validity, when a self-signed
+// certificate is renewed.
+func expiresSoon(cert *x509.Certificate) bool {
+	return time.Now().After(cert.NotAfter.Add(-cert.NotAfter.Sub(cert.NotBefore) / 10))
+}
+
+// selfSignedCertificate reuses the self-signed certificate of the --tls-state-dir until it expires
+// soon, or its SANs are not the ones of --tls-san. Otherwise it generates a new one, and saves it
+// in the state directory if set.
+func selfSignedCertificate() (tls.Certificate, error) {
+	certFile := filepath.Join(tlsStateDir, selfSignedCertFile)
+	keyFile := filepath.Join(tlsStateDir, selfSignedKeyFile)
+	if tlsStateDir != "" {
+		pair, err := loadKeyPair(certFile, keyFile)
+		if err == nil && !expiresSoon(pair.Leaf) && sameSANs(pair.Leaf, tlsSANs) {
+			log.Info().Msgf("using self-signed certificate of %s, valid until %s", tlsStateDir, pair.Leaf.NotAfter.Format(time.RFC3339))
+			return pair, nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			log.Warn().Err(err).Msgf("error loading self-signed certificate of %s, generating a new one", tlsStateDir)
+		}
+	}
+
+	caConfig := selfca.Certificate{
+		IsCA:      true,
+		KeySize:   2048,
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(selfTLSValidity),
+		Hosts:     tlsSANs,
+	}
+	if len(tlsSANs) > 0 {
+		caConfig.CommonName = tlsSANs[0]
+	}
+
+	// generating the certificate
+	certificate, key, err := selfca.GenerateCertificate(caConfig)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("error generating auto self-signed certificate: %s", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err == nil {
+		pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0])
+	}
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("error using auto self-signed certificate: %s", err)
+	}
+
+	if tlsStateDir != "" {
+		if err = os.MkdirAll(tlsStateDir, 0700); err != nil {
+			return tls.Certificate{}, fmt.Errorf("error creating TLS state directory: %s", err)
+		}
+		// The key first, so a certificate is never saved without its key
+		if err = writeFileAtomic(keyFile, keyPEM, 0600); err != nil {
+			return tls.Certificate{}, fmt.Errorf("error saving self-signed key: %s", err)
+		}
+		if err = writeFileAtomic(certFile, certPEM, 0644); err != nil {
+			return tls.Certificate{}, fmt.Errorf("error saving self-signed certificate: %s", err)
+		}
+		log.Info().Msgf("saved new self-signed certificate to %s, valid until %s", certFile, pair.Leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	return pair, nil
+}
+
+// loadKeyPair loads the certificate and key files, with the parsed certificate in Leaf.
+func loadKeyPair(certFile string, keyFile string) (tls.Certificate, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return pair, nil
+}
+
+// sameSANs checks if the certificate is valid for exactly the names and IP addresses.
+func sameSANs(cert *x509.Certificate, sans []string) bool {
+	var have, want []string
+	have = append(have, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		have = append(have, ip.String())
+	}
+
+	for _, san := range sans {
+		if ip := net.ParseIP(san); ip != nil {
+			want = append(want, ip.String())
+		} else {
+			want = append(want, san)
+		}
+	}
+
+	slices.Sort(have)
+	slices.Sort(want)
+	return slices.Equal(have, want)
+}
+
+// writeFileAtomic writes the file through a temporary file, so it's never seen half written.
+func writeFileAtomic(fileName string, data []byte, perm os.FileMode) error {
+	tmp := fileName + ".tmp"
+	// A leftover temporary file would keep its permissions
+	_ = os.Remove(tmp)
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, fileName)
+}
+
+// defaultSANs are the names of the self-signed certificate without --tls-san: the host name and
+// the loopback addresses.
+func defaultSANs() []string {
+	sans := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" && hostname != "localhost" {
+		sans = append([]string{hostname}, sans...)
+	}
+
+	return sans
+}
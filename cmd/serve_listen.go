@@ -0,0 +1,111 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// listenAddr is an address of the REST API, like tls://:3100, tcp://127.0.0.1:8080 or
+// unix:///run/pwd-checker.sock.
+type listenAddr struct {
+	scheme  string
+	address string
+}
+
+func (a listenAddr) String() string {
+	return a.scheme + "://" + a.address
+}
+
+func parseListenAddr(text string) (listenAddr, error) {
+	scheme, address, ok := strings.Cut(text, "://")
+	if !ok || address == "" {
+		return listenAddr{}, fmt.Errorf("invalid listen address %s, must be tcp://HOST:PORT, tls://HOST:PORT or unix://PATH", text)
+	}
+
+	switch scheme {
+	case "tcp", "tls":
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return listenAddr{}, fmt.Errorf("invalid listen address %s: %s", text, err)
+		}
+	case "unix":
+	default:
+		return listenAddr{}, fmt.Errorf("invalid listen address %s, the scheme must be tcp, tls or unix", text)
+	}
+
+	return listenAddr{scheme: scheme, address: address}, nil
+}
+
+// listenAddrs parses the --listen addresses, by default TLS on --port. Plain TCP addresses need
+// --insecure-plaintext, Unix sockets don't as they are only reachable from the host.
+func listenAddrs() ([]listenAddr, error) {
+	if len(listen) == 0 {
+		return []listenAddr{{scheme: "tls", address: fmt.Sprintf(":%d", port)}}, nil
+	}
+
+	addrs := make([]listenAddr, 0, len(listen))
+	for _, text := range listen {
+		addr, err := parseListenAddr(text)
+		if err != nil {
+			return nil, err
+		}
+
+		if addr.scheme == "tcp" && !insecurePlaintext {
+			return nil, fmt.Errorf("serving %s without TLS requires the --insecure-plaintext flag", addr)
+		}
+
+		addrs = append(addrs, addr)
+	}
+
+	return addrs, nil
+}
+
+// loopback checks if the address is only reachable from the host. Addresses without a host listen
+// on all the interfaces.
+func (a listenAddr) loopback() bool {
+	if a.scheme == "unix" {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(a.address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (a listenAddr) listen() (net.Listener, error) {
+	if a.scheme != "unix" {
+		return net.Listen("tcp", a.address)
+	}
+
+	// Removes the socket left by a previous server that didn't shut down cleanly
+	if info, err := os.Lstat(a.address); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err = os.Remove(a.address); err != nil {
+			return nil, err
+		}
+	}
+
+	return net.Listen("unix", a.address)
+}
+
+// unixAsLoopback makes the requests of Unix sockets come from 127.0.0.1, instead of having no
+// address, so their client IP can be taken from the headers of a trusted local proxy.
+func unixAsLoopback(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr); ok {
+			r.RemoteAddr = "127.0.0.1:0"
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseListenAddr(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		want listenAddr
+		fail bool
+	}{
+		{name: "tls", text: "tls://:3100", want: listenAddr{scheme: "tls", address: ":3100"}},
+		{name: "tcp", text: "tcp://127.0.0.1:8080", want: listenAddr{scheme: "tcp", address: "127.0.0.1:8080"}},
+		{name: "tcp IPv6", text: "tcp://[::1]:8080", want: listenAddr{scheme: "tcp", address: "[::1]:8080"}},
+		{name: "unix", text: "unix:///run/pwd-checker.sock", want: listenAddr{scheme: "unix", address: "/run/pwd-checker.sock"}},
+		{name: "relative unix", text: "unix://pwd-checker.sock", want: listenAddr{scheme: "unix", address: "pwd-checker.sock"}},
+		{name: "no scheme", text: "127.0.0.1:8080", fail: true},
+		{name: "no address", text: "tcp://", fail: true},
+		{name: "no port", text: "tcp://127.0.0.1", fail: true},
+		{name: "unknown scheme", text: "http://127.0.0.1:8080", fail: true},
+		{name: "upper case scheme", text: "TLS://:3100", fail: true},
+	}
+
+	for _, c := range cases {
+		addr, err := parseListenAddr(c.text)
+		if c.fail {
+			if err == nil {
+				t.Errorf("%s: should fail", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: should not fail: %s", c.name, err)
+			continue
+		}
+		if addr != c.want {
+			t.Errorf("%s: address %s, want: %s", c.name, addr, c.want)
+		}
+	}
+}
+
+func TestListenAddrs(t *testing.T) {
+	defer func(l []string, p uint16, i bool) {
+		listen, port, insecurePlaintext = l, p, i
+	}(listen, port, insecurePlaintext)
+
+	cases := []struct {
+		name      string
+		listen    []string
+		plaintext bool
+		want      []string
+		fail      bool
+	}{
+		{name: "default", want: []string{"tls://:3100"}},
+		{name: "tls", listen: []string{"tls://:443"}, want: []string{"tls://:443"}},
+		{name: "unix", listen: []string{"unix:///run/pwd-checker.sock"}, want: []string{"unix:///run/pwd-checker.sock"}},
+		{name: "plaintext", listen: []string{"tls://:443", "tcp://127.0.0.1:8080"}, plaintext: true, want: []string{"tls://:443", "tcp://127.0.0.1:8080"}},
+		{name: "plaintext without flag", listen: []string{"tls://:443", "tcp://127.0.0.1:8080"}, fail: true},
+		{name: "loopback plaintext without flag", listen: []string{"tcp://localhost:8080"}, fail: true},
+		{name: "invalid", listen: []string{"tls://:443", "udp://:53"}, plaintext: true, fail: true},
+	}
+
+	for _, c := range cases {
+		listen, port, insecurePlaintext = c.listen, 3100, c.plaintext
+
+		addrs, err := listenAddrs()
+		if c.fail {
+			if err == nil {
+				t.Errorf("%s: should fail", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: should not fail: %s", c.name, err)
+			continue
+		}
+
+		got := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			got = append(got, addr.String())
+		}
+		if !slices.Equal(got, c.want) {
+			t.Errorf("%s: addresses %v, want: %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestListenAddr_Loopback(t *testing.T) {
+	cases := []struct {
+		addr listenAddr
+		want bool
+	}{
+		{addr: listenAddr{scheme: "unix", address: "/run/pwd-checker.sock"}, want: true},
+		{addr: listenAddr{scheme: "tcp", address: "127.0.0.1:8080"}, want: true},
+		{addr: listenAddr{scheme: "tcp", address: "127.0.0.2:8080"}, want: true},
+		{addr: listenAddr{scheme: "tcp", address: "[::1]:8080"}, want: true},
+		{addr: listenAddr{scheme: "tcp", address: "localhost:8080"}, want: true},
+		{addr: listenAddr{scheme: "tcp", address: ":8080"}, want: false},
+		{addr: listenAddr{scheme: "tcp", address: "0.0.0.0:8080"}, want: false},
+		{addr: listenAddr{scheme: "tcp", address: "192.0.2.1:8080"}, want: false},
+		{addr: listenAddr{scheme: "tcp", address: "example.com:8080"}, want: false},
+	}
+
+	for _, c := range cases {
+		if got := c.addr.loopback(); got != c.want {
+			t.Errorf("%s: loopback %v, want: %v", c.addr, got, c.want)
+		}
+	}
+}
+
+func TestListenAddr_ListenUnix(t *testing.T) {
+	addr := listenAddr{scheme: "unix", address: filepath.Join(t.TempDir(), "pc.sock")}
+
+	// The socket left by a server that didn't shut down cleanly is replaced
+	stale, err := net.Listen("unix", addr.address)
+	if err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err = stale.Close(); err != nil {
+		t.Fatalf("Should not fail closing listener: %s", err)
+	}
+
+	l, err := addr.listen()
+	if err != nil {
+		t.Fatalf("Should not fail listening over a stale socket: %s", err)
+	}
+	defer l.Close()
+
+	// Files that are not sockets are not removed
+	other := listenAddr{scheme: "unix", address: filepath.Join(t.TempDir(), "pc.txt")}
+	if err = os.WriteFile(other.address, []byte("data"), 0644); err != nil {
+		t.Fatalf("Should not fail writing file: %s", err)
+	}
+	if _, err = other.listen(); err == nil {
+		t.Errorf("Should fail listening over a file")
+	}
+	if _, err = os.Stat(other.address); err != nil {
+		t.Errorf("File should not be removed: %s", err)
+	}
+}
+
+func TestUnixAsLoopback(t *testing.T) {
+	var remoteAddr string
+	handler := unixAsLoopback(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+	}))
+
+	cases := []struct {
+		name  string
+		local net.Addr
+		want  string
+	}{
+		{name: "unix", local: &net.UnixAddr{Name: "/run/pwd-checker.sock", Net: "unix"}, want: "127.0.0.1:0"},
+		{name: "tcp", local: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8080}, want: "192.0.2.1:1234"},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, c.local))
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if remoteAddr != c.want {
+			t.Errorf("%s: remote address %s, want: %s", c.name, remoteAddr, c.want)
+		}
+	}
+}
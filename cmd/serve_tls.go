@@ -0,0 +1,87 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"os"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// serverTLSConfig creates the TLS configuration of the servers from the flags: the TLS versions and
+// cipher suites, and the CA of the client certificates. The certificate is the current one of certs.
+func serverTLSConfig(certs *serverCertificates) (*tls.Config, error) {
+	minVersion, ok := tlsVersions[tlsMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid TLS version %s, must be one of 1.0, 1.1, 1.2 or 1.3", tlsMinVersion)
+	}
+
+	config := &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     minVersion,
+	}
+
+	if len(tlsCiphers) > 0 {
+		var err error
+		if config.CipherSuites, err = cipherSuites(tlsCiphers); err != nil {
+			return nil, err
+		}
+		if minVersion == tls.VersionTLS13 {
+			log.Warn().Msg("the TLS 1.3 cipher suites are not configurable, --tls-ciphers has no effect with --tls-min-version 1.3")
+		}
+	}
+
+	if clientCA != "" {
+		caPEM, err := os.ReadFile(clientCA)
+		if err != nil {
+			return nil, fmt.Errorf("error reading client CA file: %s", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no PEM encoded certificates in client CA file %s", clientCA)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// tlsConfigured checks if the flags configure a certificate, so plain HTTP listeners can be used
+// without one.
+func tlsConfigured() bool {
+	return selfTLS || tlsCert != "" || tlsKey != ""
+}
+
+// cipherSuites finds the IDs of the cipher suites by their names, like
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Insecure cipher suites are not allowed.
+func cipherSuites(names []string) ([]uint16, error) {
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suites[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure TLS cipher suite %s", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
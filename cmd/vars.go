@@ -3,8 +3,10 @@
 
 package cmd
 
+import "time"
+
 var (
-	// create, query, serve
+	// create, query, serve, fprate, range-store
 	inputFile string
 	// root
 	verbose bool
@@ -12,20 +14,54 @@ var (
 	profile bool
 	// root
 	pprofPort uint16
-	// create, download
+	// root
+	progressJSON string
+	// create, download, range-store, build-from-hibp, concat
 	outFile string
-	// create
+	// create, build-from-hibp
 	probability uint64
-	// create
+	// create, build-from-hibp
 	indexGranularity uint64
+	// create, build-from-hibp
+	compactIndex bool
+	// create, build-from-hibp
+	exactSidecar bool
+	// create
+	allowIncomplete bool
 	// query
 	interactive bool
 	// query
 	hashed bool
-	// download
+	// download, fprate, build-from-hibp
 	threads int
-	// create, download
+	// create, download, range-store, build-from-hibp, concat
 	overwrite bool
+	// download
+	outDir string
+	// download
+	fromPrefix string
+	// download
+	toPrefix string
+	// download
+	resume bool
+	// download
+	retryFailed bool
+	// download, build-from-hibp
+	ordered bool
+	// download, build-from-hibp
+	previousFile string
+	// download, build-from-hibp
+	baseURL string
+	// download, build-from-hibp
+	mirrors []string
+	// download, build-from-hibp
+	proxyURL string
+	// download, build-from-hibp
+	caBundle string
+	// download, build-from-hibp
+	rateLimit int
+	// build-from-hibp
+	textCopy string
 	// serve
 	selfTLS bool
 	// serve
@@ -33,5 +69,49 @@ var (
 	// serve
 	tlsKey string
 	// serve
+	tlsStateDir string
+	// serve
+	tlsSANs []string
+	// serve
+	selfTLSValidity time.Duration
+	// serve
+	tlsMinVersion string
+	// serve
+	tlsCiphers []string
+	// serve
+	clientCA string
+	// serve, api-key
+	apiKeysFile string
+	// serve
+	apiKeyHashes []string
+	// serve
+	rateLimits []string
+	// serve
+	maxConcurrent int
+	// serve
+	queueTimeout time.Duration
+	// serve
 	port uint16
+	// serve
+	listen []string
+	// serve
+	insecurePlaintext bool
+	// serve
+	trustedProxies []string
+	// serve
+	grpcPort uint16
+	// serve
+	reloadInterval time.Duration
+	// serve
+	rangeStore string
+	// fprate
+	samples uint64
+	// fprate
+	seed int64
+	// fprate
+	sourceFile string
+	// fprate
+	negativesFile string
+	// fprate
+	tolerance float64
 )
@@ -5,27 +5,88 @@ package gcs
 
 import (
 	"bufio"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"math/bits"
 )
 
-// bitReader adds bit-level reading to os.File specifically.
+// bitReaderBufferSize is the amount of bytes read from the inner reader at a time. A query
+// decodes about 1.7KiB with the default index granularity.
+const bitReaderBufferSize = 4096
+
+// bitReader adds bit-level reading to any io.ReadSeeker. Data is read from the inner reader in
+// chunks, and bits are consumed from a 64-bit word.
 type bitReader struct {
-	inner  io.ReadSeeker
-	buffer []byte
+	inner io.ReadSeeker
+	// buffer holds the bytes read from inner, starting at the byte offset bufferStart.
+	buffer      []byte
+	bufferStart int64
+	bufferPos   int
+	bufferLen   int
+	// word holds the unread bits, aligned to the most significant bit.
+	word   uint64
 	unused uint8
 }
 
 func newBitReader(r io.ReadSeeker) *bitReader {
-	return &bitReader{inner: r, buffer: make([]byte, 1), unused: 0}
+	return &bitReader{inner: r, buffer: make([]byte, bitReaderBufferSize)}
 }
 
 // Reset the internal state of the bitReader. The next read will load fresh
-// data from the current bitPos of the reader and start from the beginning
+// data from the current position of the inner reader and start from the beginning
 // of the first byte returned.
 func (r *bitReader) Reset() {
-	r.buffer[0] = 0
-	r.unused = 0
+	r.word, r.unused = 0, 0
+	r.bufferStart += int64(r.bufferLen)
+	r.bufferPos, r.bufferLen = 0, 0
+}
+
+// fill loads bytes into the word until it has more than 56 bits, or the inner reader is empty.
+// It only returns an error if no bits are available.
+func (r *bitReader) fill() error {
+	for r.unused <= 56 {
+		if r.bufferPos == r.bufferLen {
+			r.bufferStart += int64(r.bufferLen)
+			n, err := io.ReadFull(r.inner, r.buffer)
+			r.bufferPos, r.bufferLen = 0, n
+			if n == 0 {
+				if r.unused > 0 {
+					return nil
+				}
+				if err == io.ErrUnexpectedEOF {
+					err = io.EOF
+				}
+				return err
+			}
+		}
+
+		// Load a whole word at once when possible
+		if r.unused == 0 && r.bufferLen-r.bufferPos >= 8 {
+			r.word = binary.BigEndian.Uint64(r.buffer[r.bufferPos:])
+			r.bufferPos += 8
+			r.unused = 64
+			return nil
+		}
+
+		r.word |= uint64(r.buffer[r.bufferPos]) << (56 - r.unused)
+		r.bufferPos++
+		r.unused += 8
+	}
+
+	return nil
+}
+
+// consume removes n bits (n <= r.unused) from the word and returns them.
+func (r *bitReader) consume(n uint8) uint64 {
+	if n == 0 {
+		return 0
+	}
+
+	ret := r.word >> (64 - n)
+	r.word <<= n
+	r.unused -= n
+	return ret
 }
 
 // ReadBits reads up to 64 bits from the reader.
@@ -35,68 +96,96 @@ func (r *bitReader) ReadBits(n uint8) (uint64, error) {
 		return 0, fmt.Errorf("cannot read more than 64 bits at a time")
 	}
 
-	ret := uint64(0)
-	rBits := n
+	if n <= r.unused {
+		return r.consume(n), nil
+	}
+
+	// The word can't hold all the bits, read the remaining ones after a refill.
+	rBits := n - r.unused
+	ret := r.consume(r.unused)
+	if err := r.fill(); err != nil {
+		return 0, err
+	}
+	if rBits > r.unused {
+		return 0, io.ErrUnexpectedEOF
+	}
 
-	for rBits > r.unused {
-		ret |= uint64(r.buffer[0]) << (rBits - r.unused)
-		rBits -= r.unused
+	return ret<<rBits | r.consume(rBits), nil
+}
 
-		re, err := r.inner.Read(r.buffer)
-		if err != nil {
-			return uint64(re), err
+// ReadUnary reads a unary coded value: the number of 1 bits before a 0 bit. The 0 bit is also
+// consumed.
+func (r *bitReader) ReadUnary() (uint64, error) {
+	ret := uint64(0)
+	for {
+		if r.unused == 0 {
+			if err := r.fill(); err != nil {
+				return 0, err
+			}
 		}
 
-		r.unused = 8
-	}
+		// Unused bits of the word are 0, so the count stops at most at r.unused
+		ones := uint8(bits.LeadingZeros64(^r.word))
+		if ones < r.unused {
+			r.consume(ones + 1)
+			return ret + uint64(ones), nil
+		}
 
-	if rBits > 0 {
-		ret |= uint64(r.buffer[0]) >> (r.unused - rBits)
-		r.buffer[0] &= (1 << (r.unused - rBits)) - 1
-		r.unused -= rBits
+		ret += uint64(r.unused)
+		r.consume(r.unused)
 	}
+}
 
-	return ret, nil
+// position is the current *bit* position in the inner reader.
+func (r *bitReader) position() int64 {
+	return (r.bufferStart+int64(r.bufferPos))*8 - int64(r.unused)
 }
 
-// Seek to the given *bit* position in the file.  Currently, only
-// io.SeekStart and io.SeekEnd with negative offsets are supported.
-func (r *bitReader) Seek(offset int64, whence int) (ret int64, err error) {
+// Seek to the given *bit* position in the file, returning the new bit position. Seeking with
+// io.SeekEnd requires a non-positive offset.
+func (r *bitReader) Seek(offset int64, whence int) (int64, error) {
+	var target int64
 	switch whence {
 	case io.SeekStart:
-		r.Reset()
-		ret, err = r.inner.Seek(offset/8, whence)
-		if err != nil {
-			return ret, err
+		target = offset
+	case io.SeekCurrent:
+		target = r.position() + offset
+	case io.SeekEnd:
+		if offset > 0 {
+			return 0, fmt.Errorf("seeking past end of file not yet supported")
 		}
-		if _, err = r.ReadBits(uint8(offset % 8)); err != nil {
+		size, err := r.inner.Seek(0, io.SeekEnd)
+		if err != nil {
 			return 0, err
 		}
-		return offset, nil
-	case io.SeekEnd:
-		r.Reset()
-		if offset < 0 {
-			bypos := offset / 8
-			bipos := 8 - (offset % 8)
-			if bipos > 0 {
-				bypos -= 1
-			}
-			ipos, err := r.inner.Seek(bypos, whence)
-			if err != nil {
-				return 0, err
-			}
+		// The buffer no longer matches the position of the inner reader
+		r.bufferStart, r.bufferPos, r.bufferLen = size, 0, 0
+		target = size*8 + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
 
-			if _, err = r.ReadBits(uint8(bipos)); err != nil {
-				return 0, err
-			}
+	if target < 0 {
+		return 0, fmt.Errorf("cannot seek to negative position %d", target)
+	}
 
-			return ipos + (offset % 8), nil
-		} else {
-			return 0, fmt.Errorf("seeking past end of file not yet supported")
+	bytePos := target / 8
+	r.word, r.unused = 0, 0
+	if bytePos >= r.bufferStart && bytePos < r.bufferStart+int64(r.bufferLen) {
+		// Target is already buffered
+		r.bufferPos = int(bytePos - r.bufferStart)
+	} else {
+		if _, err := r.inner.Seek(bytePos, io.SeekStart); err != nil {
+			return 0, err
 		}
-	default:
-		return 0, fmt.Errorf("current not yet supported")
+		r.bufferStart, r.bufferPos, r.bufferLen = bytePos, 0, 0
 	}
+
+	if _, err := r.ReadBits(uint8(target % 8)); err != nil {
+		return 0, err
+	}
+
+	return target, nil
 }
 
 // An io.Writer and io.ByteWriter at the same time.
@@ -149,12 +149,14 @@ func TestBitReader_Seek(t *testing.T) {
 	}{
 		{8, io.SeekStart, []byte{255}, 8, false},
 		{12, io.SeekStart, []byte{0b1111}, 12, true},
-		{8, io.SeekCurrent, []byte{}, 0, true},
-		// Test only io.SeekEnd errors, as bytes.Reader does not implement it
+		{4, io.SeekCurrent, []byte{255}, 4, false},
+		{12, io.SeekCurrent, []byte{0b1111}, 12, true},
+		{-1, io.SeekCurrent, []byte{255}, 0, true},
 		{8, io.SeekEnd, []byte{255}, 0, true},
-		{-2, io.SeekEnd, []byte{255}, 0, true},
-		{-8, io.SeekEnd, []byte{255, 255, 255}, 0b1, false},
-		{-16, io.SeekEnd, []byte{255, 128, 255, 128}, 0b1, false},
+		{-2, io.SeekEnd, []byte{255}, 6, false},
+		{-16, io.SeekEnd, []byte{255}, 0, true},
+		{-8, io.SeekEnd, []byte{255, 255, 255}, 16, false},
+		{-16, io.SeekEnd, []byte{255, 128, 255, 128}, 16, false},
 	}
 
 	for _, tc := range cases {
@@ -174,3 +176,112 @@ func TestBitReader_Seek(t *testing.T) {
 		}
 	}
 }
+
+func TestBitReader_ReadUnary(t *testing.T) {
+	cases := []struct {
+		inputs []byte
+		want   []uint64
+		fail   bool
+	}{
+		{[]byte{0b01011000}, []uint64{0, 1, 2}, false},
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}, []uint64{72}, false},
+		{[]byte{0xff}, []uint64{}, true},
+		{[]byte{}, []uint64{}, true},
+	}
+
+	for _, tc := range cases {
+		reader := newBitReader(bytes.NewReader(tc.inputs))
+		for _, want := range tc.want {
+			got, err := reader.ReadUnary()
+			if err != nil {
+				t.Fatalf("ReadUnary should not fail: %s", err)
+			}
+			if got != want {
+				t.Errorf("ReadUnary(%b): %d, want: %d", tc.inputs, got, want)
+			}
+		}
+
+		if tc.fail {
+			if _, err := reader.ReadUnary(); err == nil {
+				t.Errorf("ReadUnary should fail")
+			}
+		}
+	}
+}
+
+// Reads values of every size written by bitWriter, to test reads that cross bytes and words.
+func TestBitReader_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	writer := newBitWriter(&buf)
+	for n := uint8(1); n <= 64; n++ {
+		if err := writer.WriteBits(n, ^uint64(0)>>(64-n)-1); err != nil {
+			t.Fatalf("WriteBits should not fail: %s", err)
+		}
+		// Unary coded n/2
+		if err := writer.WriteBits(n/2+1, (1<<(n/2+1))-2); err != nil {
+			t.Fatalf("WriteBits should not fail: %s", err)
+		}
+	}
+	if _, err := writer.Flush(); err != nil {
+		t.Fatalf("Flush should not fail: %s", err)
+	}
+
+	reader := newBitReader(bytes.NewReader(buf.Bytes()))
+	position := int64(0)
+	for n := uint8(1); n <= 64; n++ {
+		got, err := reader.ReadBits(n)
+		if err != nil {
+			t.Fatalf("ReadBits(%d) should not fail: %s", n, err)
+		}
+		if want := ^uint64(0)>>(64-n) - 1; got != want {
+			t.Errorf("ReadBits(%d): %x, want: %x", n, got, want)
+		}
+
+		unary, err := reader.ReadUnary()
+		if err != nil {
+			t.Fatalf("ReadUnary should not fail: %s", err)
+		}
+		if unary != uint64(n/2) {
+			t.Errorf("ReadUnary: %d, want: %d", unary, n/2)
+		}
+
+		position += int64(n) + int64(n/2) + 1
+		if current, err := reader.Seek(0, io.SeekCurrent); err != nil || current != position {
+			t.Errorf("Seek(0, io.SeekCurrent): %d, want: %d (%v)", current, position, err)
+		}
+	}
+}
+
+func BenchmarkBitReader_Golomb(b *testing.B) {
+	// Golomb coded values with a 1-in-2^24 probability, like the default databases
+	const log2p = 24
+	var buf bytes.Buffer
+	encoder := newEncoder(&buf, 1<<log2p)
+	for i := uint64(0); i < 1024; i++ {
+		if _, err := encoder.Encode(i * 104729 % (3 << log2p)); err != nil {
+			b.Fatalf("Encode should not fail: %s", err)
+		}
+	}
+	if _, err := encoder.Finalize(); err != nil {
+		b.Fatalf("Finalize should not fail: %s", err)
+	}
+
+	data := bytes.NewReader(buf.Bytes())
+	b.SetBytes(int64(buf.Len()))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader := newBitReader(data)
+		if _, err := reader.Seek(0, io.SeekStart); err != nil {
+			b.Fatalf("Seek should not fail: %s", err)
+		}
+
+		for j := 0; j < 1024; j++ {
+			if _, err := reader.ReadUnary(); err != nil {
+				b.Fatalf("ReadUnary should not fail: %s", err)
+			}
+			if _, err := reader.ReadBits(log2p); err != nil {
+				b.Fatalf("ReadBits should not fail: %s", err)
+			}
+		}
+	}
+}
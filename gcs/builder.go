@@ -5,9 +5,10 @@ package gcs
 
 import (
 	"bufio"
-	"github.com/alvinbaena/pwd-checker/internal/util"
+	"fmt"
+	"github.com/alvinbaena/pwd-checker/progress"
 	"github.com/jfcg/sorty/v2"
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 	"io"
 	"os"
 	"sync"
@@ -28,40 +29,72 @@ type Builder struct {
 	num              uint64
 	probability      uint64
 	indexGranularity uint64
+	compactIndex     bool
 	values           []uint64
 	stat             *status
+	log              zerolog.Logger
+	progress         progress.Reporter
 }
 
-// NewBuilder builder for a new GCS file database.
+// NewBuilder builder for a new GCS file database, reading the hashes from a Pwned Passwords file
+// (SHA1).
 //
-// probability is the False positive rate for queries, 1-in-p.
-// indexGranularity is the entries per index point (16 bytes each).
-func NewBuilder(in *os.File, out io.Writer, probability uint64, indexGranularity uint64) *Builder {
+// Options: WithProbability, WithIndexGranularity, WithCompactIndex, WithLogger, WithProgress.
+func NewBuilder(in *os.File, out io.Writer, opts ...Option) (*Builder, error) {
 	// Estimate the amount of lines in the passwords file. It's pretty accurate, <= 1% error rate.
 	// 847223402 is the exact number of lines for v8 file
-	estimatedLines := estimateFileLines(in)
+	estimatedLines, err := estimateFileLines(in)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := newBuilder(out, estimatedLines, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	b.in = in
+	return b, nil
+}
+
+// NewStreamBuilder builder for a new GCS file database, with the hashes given to Add instead of
+// read from a file. Finish writes the database once all the hashes are added. estimatedItems is
+// only used to reserve memory, it doesn't need to be exact.
+//
+// Options: WithProbability, WithIndexGranularity, WithCompactIndex, WithLogger, WithProgress.
+func NewStreamBuilder(out io.Writer, estimatedItems uint64, opts ...Option) (*Builder, error) {
+	return newBuilder(out, estimatedItems, opts)
+}
+
+func newBuilder(out io.Writer, estimatedItems uint64, opts []Option) (*Builder, error) {
+	o := newOptions(opts)
+	if o.probability == 0 {
+		return nil, fmt.Errorf("probability must be greater than 0")
+	}
 
 	return &Builder{
-		in:               in,
 		out:              out,
-		num:              estimatedLines,
-		probability:      probability,
-		indexGranularity: indexGranularity,
-		values:           make([]uint64, 0, estimatedLines),
-	}
+		num:              estimatedItems,
+		probability:      o.probability,
+		indexGranularity: o.indexGranularity,
+		compactIndex:     o.compactIndex,
+		values:           make([]uint64, 0, estimatedItems),
+		log:              o.logger,
+		progress:         o.progress,
+	}, nil
+}
+
+// EstimatedItems is the estimated number of hashes in the input file, before Process is called.
+// Each one takes 8 bytes of memory while building the database.
+func (b *Builder) EstimatedItems() uint64 {
+	return b.num
 }
 
 // Process creates the gcs file using the inputs in the builder
 // Concurrent file read inspired by https://marcellanz.com/post/file-read-challenge/
-func (b *Builder) Process(skipWait bool) error {
-	// Stop the process if not enough ram to actually hold all the entries read.
-	util.CheckRam(b.num, skipWait)
-
-	s := util.Stats()
-	defer s()
-
-	b.stat = newStatus()
-	log.Info().Msg("starting process. This might take a while, be patient :)")
+func (b *Builder) Process() error {
+	b.stat = newStatus("build", b.progress)
+	b.log.Info().Msg("starting process. This might take a while, be patient :)")
 
 	scanner := bufio.NewScanner(b.in)
 
@@ -81,6 +114,8 @@ func (b *Builder) Process(skipWait bool) error {
 	// Mutex needed to avoid resource contention between the coroutines
 	mutex := &sync.Mutex{}
 	wg := sync.WaitGroup{}
+	// First parsing error, the hashes of the chunk that failed are not added
+	var parseErr error
 
 	b.stat.StageWork("Read", b.num)
 	// Read first line
@@ -97,8 +132,18 @@ func (b *Builder) Process(skipWait bool) error {
 				// Clear data
 				records := recordsPool.Get().([]uint64)[:0]
 
+				var err error
 				for _, line := range linesToProcess {
-					hash := U64FromHex([]byte(line)[0:16])
+					if len(line) < 16 {
+						err = fmt.Errorf("invalid line in Pwned Passwords file: %q", line)
+						break
+					}
+
+					hash, hexErr := U64FromHex([]byte(line)[0:16])
+					if hexErr != nil {
+						err = fmt.Errorf("invalid line in Pwned Passwords file: %q: %w", line, hexErr)
+						break
+					}
 					records = append(records, hash)
 				}
 
@@ -106,15 +151,22 @@ func (b *Builder) Process(skipWait bool) error {
 				// Avoid resource contention
 				mutex.Lock()
 
+				if err != nil {
+					if parseErr == nil {
+						parseErr = err
+					}
+					records = records[:0]
+				}
+
 				for _, hash := range records {
 					b.stat.Incr()
-					b.add(hash)
+					b.Add(hash)
 				}
 
 				mutex.Unlock()
 				recordsPool.Put(records)
 
-				wg.Add(-len(records))
+				wg.Add(-len(linesToProcess))
 			}()
 
 			// Clear slice
@@ -124,7 +176,30 @@ func (b *Builder) Process(skipWait bool) error {
 	// Wait for all coroutines to finish
 	wg.Wait()
 
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if parseErr != nil {
+		return parseErr
+	}
+
 	// Create the GCS file
+	return b.Finish()
+}
+
+// Add adds the first 64 bits of a hash to the database, see U64FromHex. It's not safe for
+// concurrent use.
+func (b *Builder) Add(entry uint64) {
+	b.values = append(b.values, entry)
+}
+
+// Finish writes the database with the added hashes. Process calls it after reading the input
+// file, it's only needed for a builder created with NewStreamBuilder.
+func (b *Builder) Finish() error {
+	if b.stat == nil {
+		b.stat = newStatus("build", b.progress)
+	}
+
 	if err := b.finalize(); err != nil {
 		return err
 	}
@@ -133,16 +208,14 @@ func (b *Builder) Process(skipWait bool) error {
 	return nil
 }
 
-// Add adds a new item to the database
-func (b *Builder) add(entry uint64) {
-	b.values = append(b.values, entry)
-}
-
 // Finalize the construction of the database
 func (b *Builder) finalize() error {
 	// Adjust with the actual number of items, not the estimate
 	b.num = uint64(len(b.values))
-	log.Debug().Msgf("database will have %d items", b.num)
+	if b.num == 0 {
+		return fmt.Errorf("there are no hashes to add to the database")
+	}
+	b.log.Debug().Msgf("database will have %d items", b.num)
 
 	np := b.num * b.probability
 
@@ -194,17 +267,28 @@ func (b *Builder) finalize() error {
 	}
 
 	endOfData := (totalBits + wr) / 8
-	log.Debug().Msgf("end of data: %d", endOfData)
+	b.log.Debug().Msgf("end of data: %d", endOfData)
 	b.stat.Stage("Write Index")
-	log.Debug().Msgf("index will have %d items", len(index))
-
-	// Write the index: pairs of u64's (value, bit index)
-	for _, pair := range index {
-		if _, err = b.out.Write(toFixedBytes(pair.value)); err != nil {
+	b.log.Debug().Msgf("index will have %d items", len(index))
+
+	magic := gcsMagic
+	if b.compactIndex {
+		// Write the index: Elias-Fano coded values, then bit indexes
+		compact := newCompactIndex(index)
+		b.log.Debug().Msgf("compact index uses %d bytes instead of %d", compact.size(), flatIndex(index).size())
+		if _, err = compact.WriteTo(b.out); err != nil {
 			return err
 		}
-		if _, err = b.out.Write(toFixedBytes(pair.bitPos)); err != nil {
-			return err
+		magic = gcsCompactMagic
+	} else {
+		// Write the index: pairs of u64's (value, bit index)
+		for _, pair := range index {
+			if _, err = b.out.Write(toFixedBytes(pair.value)); err != nil {
+				return err
+			}
+			if _, err = b.out.Write(toFixedBytes(pair.bitPos)); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -223,7 +307,7 @@ func (b *Builder) finalize() error {
 	if _, err = b.out.Write(toFixedBytes(uint64(len(index)))); err != nil {
 		return err
 	}
-	if _, err = b.out.Write([]byte(gcsMagic)); err != nil {
+	if _, err = b.out.Write([]byte(magic)); err != nil {
 		return err
 	}
 
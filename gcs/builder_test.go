@@ -6,13 +6,16 @@ package gcs
 import (
 	"bytes"
 	"encoding/binary"
+	"github.com/alvinbaena/pwd-checker/progress"
 	"io"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 )
 
 func TestBuilder(t *testing.T) {
-	file, err := os.Open("../../test/data/pwned-sample-sha1.txt")
+	file, err := os.Open("../test/data/pwned-sample-sha1.txt")
 	if err != nil {
 		t.Fatalf("Should not fail opening file: %s", err)
 	}
@@ -24,8 +27,12 @@ func TestBuilder(t *testing.T) {
 	}(file)
 
 	var writer bytes.Buffer
-	builder := NewBuilder(file, &writer, 100, 16)
-	err = builder.Process(true)
+	builder, err := NewBuilder(file, &writer, WithProbability(100), WithIndexGranularity(16))
+	if err != nil {
+		t.Fatalf("Should not fail creating builder: %s", err)
+	}
+
+	err = builder.Process()
 	if err != nil {
 		t.Errorf("Should not fail processing file: %s", err)
 	}
@@ -88,3 +95,93 @@ func TestBuilder(t *testing.T) {
 		t.Errorf("Should not fail GCS footer")
 	}
 }
+
+func TestStreamBuilder(t *testing.T) {
+	data, err := os.ReadFile("../test/data/pwned-sample-sha1.txt")
+	if err != nil {
+		t.Fatalf("Should not fail reading file: %s", err)
+	}
+
+	var writer bytes.Buffer
+	builder, err := NewStreamBuilder(&writer, 100, WithProbability(100), WithIndexGranularity(16))
+	if err != nil {
+		t.Fatalf("Should not fail creating builder: %s", err)
+	}
+
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		hash, err := U64FromHex(line[:16])
+		if err != nil {
+			t.Fatalf("Should not fail: %s", err)
+		}
+		builder.Add(hash)
+	}
+
+	if err = builder.Finish(); err != nil {
+		t.Fatalf("Should not fail finishing database: %s", err)
+	}
+
+	// The hashes are sorted before encoding, the database must be the same one built from the file
+	built, err := os.ReadFile(buildTestDatabase(t, WithProbability(100), WithIndexGranularity(16)))
+	if err != nil {
+		t.Fatalf("Should not fail reading database: %s", err)
+	}
+	if !bytes.Equal(writer.Bytes(), built) {
+		t.Errorf("Stream built database differs from the one built from the file")
+	}
+}
+
+func TestStreamBuilder_Empty(t *testing.T) {
+	var writer bytes.Buffer
+	builder, err := NewStreamBuilder(&writer, 0)
+	if err != nil {
+		t.Fatalf("Should not fail creating builder: %s", err)
+	}
+
+	if err = builder.Finish(); err == nil {
+		t.Errorf("Should fail without hashes")
+	}
+}
+
+func TestBuilder_Progress(t *testing.T) {
+	file, err := os.Open("../test/data/pwned-sample-sha1.txt")
+	if err != nil {
+		t.Fatalf("Should not fail opening file: %s", err)
+	}
+	defer file.Close()
+
+	var mu sync.Mutex
+	var events []progress.Event
+	builder, err := NewBuilder(file, io.Discard, WithProbability(100), WithProgress(progress.ReporterFunc(func(e progress.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	})))
+	if err != nil {
+		t.Fatalf("Should not fail creating builder: %s", err)
+	}
+
+	if err = builder.Process(); err != nil {
+		t.Fatalf("Should not fail processing file: %s", err)
+	}
+
+	var stages []string
+	for _, e := range events {
+		if e.Process != "build" {
+			t.Errorf("Event of process %q, want: build", e.Process)
+		}
+		if e.Kind == progress.StageStarted {
+			stages = append(stages, e.Stage)
+		}
+		if e.Kind == progress.StageProgress && e.Stage == "Read" && e.Total != builder.EstimatedItems() {
+			t.Errorf("Read progress total: %d, want: %d", e.Total, builder.EstimatedItems())
+		}
+	}
+
+	want := []string{"Read", "Normalise", "Sort", "Deduplicate", "Encode", "Write Index"}
+	if strings.Join(stages, ",") != strings.Join(want, ",") {
+		t.Errorf("Stages: %v, want: %v", stages, want)
+	}
+	if last := events[len(events)-1]; last.Kind != progress.Finished {
+		t.Errorf("Last event: %s, want: %s", last.Kind, progress.Finished)
+	}
+}
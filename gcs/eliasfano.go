@@ -0,0 +1,201 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package gcs
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Every selectSample-th set bit of the upper bits has its position stored, so selecting a value
+// only has to scan a few words.
+const selectSample = 256
+
+// eliasFano is a compact representation of a non-decreasing sequence of uint64's. Each value
+// uses 2 + log2(u/n) bits, where u is the largest value and n the amount of values.
+//
+// https://www.antoniomallia.it/sorted-integers-compression-with-elias-fano-encoding.html
+type eliasFano struct {
+	num     uint64
+	lowBits uint8
+	// lower are the lowBits least significant bits of each value, packed
+	lower []uint64
+	// upper are the remaining bits of each value, unary coded as the gap from the previous one
+	upper []uint64
+	// samples are the positions in upper of every selectSample-th value
+	samples []uint64
+}
+
+func newEliasFano(values []uint64) *eliasFano {
+	e := &eliasFano{num: uint64(len(values))}
+	if e.num == 0 {
+		return e
+	}
+
+	universe := values[len(values)-1] + 1
+	if universe > e.num {
+		e.lowBits = uint8(bits.Len64(universe/e.num) - 1)
+	}
+
+	e.lower = make([]uint64, (e.num*uint64(e.lowBits)+63)/64)
+	e.upper = make([]uint64, (e.num+(universe>>e.lowBits)+1+63)/64)
+
+	mask := uint64(1)<<e.lowBits - 1
+	for i, v := range values {
+		e.setLower(uint64(i), v&mask)
+		pos := (v >> e.lowBits) + uint64(i)
+		e.upper[pos/64] |= 1 << (pos % 64)
+	}
+
+	e.buildSamples()
+	return e
+}
+
+func (e *eliasFano) setLower(i uint64, v uint64) {
+	if e.lowBits == 0 {
+		return
+	}
+
+	pos := i * uint64(e.lowBits)
+	word, offset := pos/64, pos%64
+	e.lower[word] |= v << offset
+	if offset+uint64(e.lowBits) > 64 {
+		e.lower[word+1] |= v >> (64 - offset)
+	}
+}
+
+func (e *eliasFano) getLower(i uint64) uint64 {
+	if e.lowBits == 0 {
+		return 0
+	}
+
+	pos := i * uint64(e.lowBits)
+	word, offset := pos/64, pos%64
+	v := e.lower[word] >> offset
+	if offset+uint64(e.lowBits) > 64 {
+		v |= e.lower[word+1] << (64 - offset)
+	}
+
+	return v & (uint64(1)<<e.lowBits - 1)
+}
+
+func (e *eliasFano) buildSamples() {
+	e.samples = make([]uint64, 0, e.num/selectSample+1)
+	count := uint64(0)
+	for w, word := range e.upper {
+		for word != 0 {
+			if count%selectSample == 0 {
+				e.samples = append(e.samples, uint64(w)*64+uint64(bits.TrailingZeros64(word)))
+			}
+			count++
+			// Clear the lowest set bit
+			word &= word - 1
+		}
+	}
+}
+
+// selectUpper returns the position of the i-th set bit of the upper bits.
+func (e *eliasFano) selectUpper(i uint64) uint64 {
+	pos := e.samples[i/selectSample]
+	remaining := i % selectSample
+
+	w := pos / 64
+	// Ignore the bits before the sampled one
+	word := e.upper[w] & (^uint64(0) << (pos % 64))
+	for {
+		ones := uint64(bits.OnesCount64(word))
+		if remaining < ones {
+			break
+		}
+		remaining -= ones
+		w++
+		word = e.upper[w]
+	}
+
+	for ; remaining > 0; remaining-- {
+		word &= word - 1
+	}
+
+	return w*64 + uint64(bits.TrailingZeros64(word))
+}
+
+// get returns the i-th value of the sequence.
+func (e *eliasFano) get(i uint64) uint64 {
+	high := e.selectUpper(i) - i
+	return high<<e.lowBits | e.getLower(i)
+}
+
+// size is the memory used by the sequence in bytes.
+func (e *eliasFano) size() uint64 {
+	return uint64(len(e.lower)+len(e.upper)+len(e.samples)) * 8
+}
+
+// WriteTo writes the sequence: amount of values, low bits, amount of lower words, amount of
+// upper words, and then the lower and upper words. Select samples are not written.
+func (e *eliasFano) WriteTo(w io.Writer) (int64, error) {
+	written := int64(0)
+	for _, v := range []uint64{e.num, uint64(e.lowBits), uint64(len(e.lower)), uint64(len(e.upper))} {
+		n, err := w.Write(toFixedBytes(v))
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	for _, words := range [][]uint64{e.lower, e.upper} {
+		for _, word := range words {
+			n, err := w.Write(toFixedBytes(word))
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// readEliasFano reads a sequence written by WriteTo. maxWords limits the words read, so a corrupt
+// header can't allocate more memory than the file has.
+func readEliasFano(r io.Reader, maxWords uint64) (*eliasFano, error) {
+	header := make([]uint64, 4)
+	for i := range header {
+		v, err := readUint64(r)
+		if err != nil {
+			return nil, err
+		}
+		header[i] = v
+	}
+
+	e := &eliasFano{num: header[0], lowBits: uint8(header[1])}
+	lowerLen, upperLen := header[2], header[3]
+	if header[1] > 63 || lowerLen+upperLen > maxWords ||
+		lowerLen != (e.num*uint64(e.lowBits)+63)/64 || upperLen*64 < e.num {
+		return nil, fmt.Errorf("%w: invalid Elias-Fano index header", ErrCorrupt)
+	}
+
+	e.lower = make([]uint64, lowerLen)
+	e.upper = make([]uint64, upperLen)
+	for _, words := range [][]uint64{e.lower, e.upper} {
+		for i := range words {
+			v, err := readUint64(r)
+			if err != nil {
+				return nil, err
+			}
+			words[i] = v
+		}
+	}
+
+	ones := uint64(0)
+	for _, word := range e.upper {
+		ones += uint64(bits.OnesCount64(word))
+	}
+	if ones != e.num {
+		return nil, fmt.Errorf("%w: Elias-Fano index has %d values, expected %d", ErrCorrupt, ones, e.num)
+	}
+
+	e.buildSamples()
+	return e, nil
+}
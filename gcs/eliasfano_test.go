@@ -0,0 +1,101 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package gcs
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestEliasFano(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	cases := []struct {
+		name   string
+		values []uint64
+	}{
+		{"single", []uint64{42}},
+		{"zeros", []uint64{0, 0, 0, 0}},
+		{"dense", []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{"duplicates", []uint64{3, 3, 9, 9, 9, 27, 81, 81}},
+		{"large", []uint64{0, 1 << 40, 1 << 50, 1<<63 - 1}},
+		{"random", randomSorted(rnd, 10000, 1<<34)},
+	}
+
+	for _, tc := range cases {
+		e := newEliasFano(tc.values)
+		for i, want := range tc.values {
+			if got := e.get(uint64(i)); got != want {
+				t.Errorf("%s: get(%d): %d, want: %d", tc.name, i, got, want)
+			}
+		}
+
+		var buf bytes.Buffer
+		if _, err := e.WriteTo(&buf); err != nil {
+			t.Fatalf("%s: WriteTo should not fail: %s", tc.name, err)
+		}
+
+		read, err := readEliasFano(bytes.NewReader(buf.Bytes()), uint64(buf.Len()/8))
+		if err != nil {
+			t.Fatalf("%s: readEliasFano should not fail: %s", tc.name, err)
+		}
+		for i, want := range tc.values {
+			if got := read.get(uint64(i)); got != want {
+				t.Errorf("%s: read get(%d): %d, want: %d", tc.name, i, got, want)
+			}
+		}
+	}
+}
+
+func TestEliasFano_Corrupt(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := newEliasFano([]uint64{1, 2, 3}).WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo should not fail: %s", err)
+	}
+
+	data := buf.Bytes()
+	if _, err := readEliasFano(bytes.NewReader(data[:len(data)-1]), uint64(len(data)/8)); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Should fail with ErrTruncated, got: %v", err)
+	}
+
+	// Amount of values does not match the upper bits
+	data[7] = 4
+	if _, err := readEliasFano(bytes.NewReader(data), uint64(len(data)/8)); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Should fail with ErrCorrupt, got: %v", err)
+	}
+}
+
+func TestCompactIndex_Floor(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	values := randomSorted(rnd, 1000, 1<<40)
+	pairs := make([]indexPair, len(values))
+	for i, v := range values {
+		pairs[i] = indexPair{value: v, bitPos: uint64(i) * 26}
+	}
+
+	flat := flatIndex(pairs)
+	compact := newCompactIndex(pairs)
+	for i := 0; i < 10000; i++ {
+		value := rnd.Uint64() % (1 << 41)
+		if got, want := compact.floor(value), flat.floor(value); got != want {
+			t.Errorf("floor(%d): %v, want: %v", value, got, want)
+		}
+	}
+
+	if compact.size() >= flat.size() {
+		t.Errorf("Compact index should be smaller: %d, flat: %d", compact.size(), flat.size())
+	}
+}
+
+func randomSorted(rnd *rand.Rand, n int, max uint64) []uint64 {
+	values := make([]uint64, n)
+	for i := range values {
+		values[i] = rnd.Uint64() % max
+	}
+	values[0] = 0
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values
+}
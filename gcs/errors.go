@@ -0,0 +1,20 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package gcs
+
+import "errors"
+
+var (
+	// ErrNotGCS is returned when a file does not have the GCS footer.
+	ErrNotGCS = errors.New("not a GCS file")
+	// ErrCorrupt is returned when the contents of a GCS file are not consistent with its footer.
+	ErrCorrupt = errors.New("corrupt GCS file")
+	// ErrTruncated is returned when a GCS file ends before all the expected data is read.
+	ErrTruncated = errors.New("truncated GCS file")
+	// ErrSidecarMismatch is returned when an exact sidecar was not created for the GCS file it's
+	// used with, so it could miss hashes of the GCS.
+	ErrSidecarMismatch = errors.New("exact sidecar does not belong to the GCS file")
+	// ErrInvalidHash is returned when a query key can't be created from a SHA1 hash.
+	ErrInvalidHash = errors.New("input is not a valid SHA1 Hexadecimal hash")
+)
@@ -0,0 +1,99 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package gcs
+
+import (
+	"io"
+	"sort"
+)
+
+// index maps some values of the database to their bit position in the encoded data, so queries
+// can start decoding close to the value they are looking for.
+type index interface {
+	// floor returns the entry with the highest value that is lower or equal to value.
+	floor(value uint64) indexPair
+	// size is the memory used by the index in bytes.
+	size() uint64
+}
+
+// flatIndex stores each entry as a pair of u64's (16 bytes each).
+type flatIndex []indexPair
+
+func (f flatIndex) floor(value uint64) indexPair {
+	// First entry with a higher value, the one before it is the floor.
+	i := sort.Search(len(f), func(i int) bool {
+		return f[i].value > value
+	})
+
+	return f[max(i-1, 0)]
+}
+
+func (f flatIndex) size() uint64 {
+	return uint64(len(f)) * 16
+}
+
+// compactIndex stores the values and bit positions of the entries as Elias-Fano coded sequences,
+// about 52 bits per entry instead of 128 for the usual false positive rates and granularity.
+type compactIndex struct {
+	values  *eliasFano
+	bitPos  *eliasFano
+	entries uint64
+}
+
+func newCompactIndex(pairs []indexPair) *compactIndex {
+	values := make([]uint64, len(pairs))
+	bitPos := make([]uint64, len(pairs))
+	for i, pair := range pairs {
+		values[i] = pair.value
+		bitPos[i] = pair.bitPos
+	}
+
+	return &compactIndex{
+		values:  newEliasFano(values),
+		bitPos:  newEliasFano(bitPos),
+		entries: uint64(len(pairs)),
+	}
+}
+
+func (c *compactIndex) floor(value uint64) indexPair {
+	i := sort.Search(int(c.entries), func(i int) bool {
+		return c.values.get(uint64(i)) > value
+	})
+
+	i = max(i-1, 0)
+	return indexPair{value: c.values.get(uint64(i)), bitPos: c.bitPos.get(uint64(i))}
+}
+
+func (c *compactIndex) size() uint64 {
+	return c.values.size() + c.bitPos.size()
+}
+
+// WriteTo writes the values sequence followed by the bit positions sequence.
+func (c *compactIndex) WriteTo(w io.Writer) (int64, error) {
+	written, err := c.values.WriteTo(w)
+	if err != nil {
+		return written, err
+	}
+
+	n, err := c.bitPos.WriteTo(w)
+	return written + n, err
+}
+
+func readCompactIndex(r io.Reader, entries uint64, maxWords uint64) (*compactIndex, error) {
+	values, err := readEliasFano(r, maxWords)
+	if err != nil {
+		return nil, err
+	}
+
+	bitPos, err := readEliasFano(r, maxWords)
+	if err != nil {
+		return nil, err
+	}
+
+	if values.num != entries || bitPos.num != entries || entries == 0 {
+		return nil, ErrCorrupt
+	}
+
+	return &compactIndex{values: values, bitPos: bitPos, entries: entries}, nil
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package gcs
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// Hash is a full SHA1 hash, used to query databases with an exact sidecar.
+type Hash [sha1.Size]byte
+
+// HashFromPassword returns the SHA1 hash of a plain text password.
+func HashFromPassword(password string) Hash {
+	return sha1.Sum([]byte(password))
+}
+
+// HashFromHex parses a hexadecimal SHA1 hash, in upper or lower case.
+func HashFromHex(hash string) (Hash, error) {
+	var sum Hash
+	if len(hash) != sha1.Size*2 {
+		return sum, ErrInvalidHash
+	}
+
+	if _, err := hex.Decode(sum[:], []byte(hash)); err != nil {
+		return sum, ErrInvalidHash
+	}
+
+	return sum, nil
+}
+
+// Key returns the query key of the hash.
+func (h Hash) Key() uint64 {
+	return binary.BigEndian.Uint64(h[:])
+}
+
+// KeyFromPassword returns the query key of a plain text password.
+func KeyFromPassword(password string) uint64 {
+	return HashFromPassword(password).Key()
+}
+
+// KeyFromHex returns the query key of a hexadecimal SHA1 hash, in upper or lower case.
+func KeyFromHex(hash string) (uint64, error) {
+	sum, err := HashFromHex(hash)
+	if err != nil {
+		return 0, err
+	}
+
+	return sum.Key(), nil
+}
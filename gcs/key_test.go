@@ -0,0 +1,50 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package gcs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestKeyFromHex(t *testing.T) {
+	cases := []struct {
+		hash string
+		want uint64
+		fail bool
+	}{
+		{"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8", 0x5BAA61E4C9B93F3F, false},
+		{"5baa61e4c9b93f3f0682250b6cf8331b7ee68fd8", 0x5BAA61E4C9B93F3F, false},
+		{"5BAA61E4C9B93F3F", 0, true},
+		{"ZBAA61E4C9B93F3F0682250B6CF8331B7EE68FD8", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tc := range cases {
+		got, err := KeyFromHex(tc.hash)
+		if !tc.fail {
+			if err != nil {
+				t.Errorf("KeyFromHex(%s) should not fail: %s", tc.hash, err)
+			}
+			if got != tc.want {
+				t.Errorf("KeyFromHex(%s): %x, want: %x", tc.hash, got, tc.want)
+			}
+		} else {
+			if !errors.Is(err, ErrInvalidHash) {
+				t.Errorf("KeyFromHex(%s) should fail with ErrInvalidHash, got: %v", tc.hash, err)
+			}
+		}
+	}
+}
+
+func TestKeyFromPassword(t *testing.T) {
+	want, err := KeyFromHex("5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8")
+	if err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+
+	if got := KeyFromPassword("password"); got != want {
+		t.Errorf("KeyFromPassword(password): %x, want: %x", got, want)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package gcs
+
+import (
+	"github.com/alvinbaena/pwd-checker/progress"
+	"github.com/rs/zerolog"
+	"time"
+)
+
+const (
+	// DefaultProbability is the default false positive rate of a new database, 1-in-p.
+	DefaultProbability = 16777216
+	// DefaultIndexGranularity is the default number of entries per index point.
+	DefaultIndexGranularity = 1024
+	// DefaultCacheSize is the default number of query results cached by a Reader.
+	DefaultCacheSize = 50 * 10000
+	// DefaultCacheTTL is the default time a query result stays cached by a Reader.
+	DefaultCacheTTL = time.Hour
+)
+
+// Option configures a Builder or a Reader. Options that don't apply to the value being
+// configured are ignored.
+type Option func(*options)
+
+type options struct {
+	logger           zerolog.Logger
+	progress         progress.Reporter
+	probability      uint64
+	indexGranularity uint64
+	compactIndex     bool
+	cacheSize        int64
+	cacheTTL         time.Duration
+	cacheObserver    func(hit bool)
+	exactSidecar     string
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		logger:           zerolog.Nop(),
+		probability:      DefaultProbability,
+		indexGranularity: DefaultIndexGranularity,
+		cacheSize:        DefaultCacheSize,
+		cacheTTL:         DefaultCacheTTL,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.progress == nil {
+		o.progress = progress.NewLogReporter(o.logger)
+	}
+
+	return o
+}
+
+// WithLogger sets the logger used to report progress and information. Nothing is logged by default.
+func WithLogger(logger zerolog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithProgress sets the reporter of the progress of a Builder or SidecarBuilder. By default the
+// progress is written to the logger set with WithLogger.
+func WithProgress(reporter progress.Reporter) Option {
+	return func(o *options) {
+		o.progress = reporter
+	}
+}
+
+// WithProbability sets the false positive rate for queries, 1-in-p. Only used by the Builder.
+func WithProbability(probability uint64) Option {
+	return func(o *options) {
+		o.probability = probability
+	}
+}
+
+// WithIndexGranularity sets the entries per index point (16 bytes each). Only used by the Builder.
+func WithIndexGranularity(indexGranularity uint64) Option {
+	return func(o *options) {
+		o.indexGranularity = indexGranularity
+	}
+}
+
+// WithCompactIndex writes an Elias-Fano coded index, that uses less than half the memory of the
+// flat index when loaded by a Reader. This allows a finer index granularity, and faster queries,
+// with the same memory. Only used by the Builder.
+func WithCompactIndex(compact bool) Option {
+	return func(o *options) {
+		o.compactIndex = compact
+	}
+}
+
+// WithCache sets the max number of query results cached, and how long they are cached. A size
+// of 0 disables the cache. Only used by the Reader.
+func WithCache(size int64, ttl time.Duration) Option {
+	return func(o *options) {
+		o.cacheSize = size
+		o.cacheTTL = ttl
+	}
+}
+
+// WithCacheObserver sets a function called on every query of a Reader with a cache, with true if
+// the result was cached. It's called concurrently by concurrent queries. Only used by the Reader.
+func WithCacheObserver(observe func(hit bool)) Option {
+	return func(o *options) {
+		o.cacheObserver = observe
+	}
+}
+
+// WithExactSidecar sets the exact sidecar file (created by a SidecarBuilder) used to confirm the
+// matches of Reader.Lookup. Only used by the Reader.
+func WithExactSidecar(fileName string) Option {
+	return func(o *options) {
+		o.exactSidecar = fileName
+	}
+}
@@ -4,11 +4,11 @@
 package gcs
 
 import (
-	"encoding/binary"
+	"bufio"
+	"crypto/sha256"
 	"fmt"
-	"github.com/alvinbaena/pwd-checker/internal/util"
 	"github.com/dgraph-io/ristretto"
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
 	"io"
@@ -17,102 +17,247 @@ import (
 	"time"
 )
 
-const hashCacheItemTTL = time.Hour
+// footerSize is the size of the GCS footer: N, P, index position in bytes, index size in
+// entries and the magic string.
+const footerSize = 40
 
 type Reader struct {
 	fileName    string
+	file        *os.File
+	size        int64
 	num         uint64
 	probability uint64
 	endOfData   uint64
 	indexLen    uint64
-	index       []indexPair
+	index       index
 	log2p       uint8
+	compact     bool
 	cache       *ristretto.Cache
+	cacheTTL    time.Duration
+	observe     func(hit bool)
+	log         zerolog.Logger
+	exactFile   string
+	exact       *sidecar
 }
 
-func NewReader(fileName string) *Reader {
-	cache, err := ristretto.NewCache(&ristretto.Config{
-		NumCounters: 10 * 10000,
-		MaxCost:     50 * 10000,
-		BufferItems: 64,
-	})
+// Result of a query with a full SHA1 hash.
+type Result struct {
+	// Found is true if the hash is (probably) in the database.
+	Found bool
+	// Exact is true if Found is definitive. Hashes not found are always exact, found ones only if
+	// the database has an exact sidecar.
+	Exact bool
+}
 
-	if err != nil {
-		log.Fatal().Err(err).Msg("error setting reader cache")
-		return nil
-	}
+// NewReader creates a Reader for a GCS file. Initialize must be called before querying.
+//
+// Options: WithCache, WithCacheObserver, WithExactSidecar, WithLogger.
+func NewReader(fileName string, opts ...Option) (*Reader, error) {
+	o := newOptions(opts)
 
 	r := &Reader{
-		fileName:    fileName,
-		num:         0,
-		probability: 0,
-		endOfData:   0,
-		indexLen:    0,
-		index:       make([]indexPair, 0, 0),
-		log2p:       0,
-		cache:       cache,
+		fileName:  fileName,
+		index:     flatIndex{},
+		cacheTTL:  o.cacheTTL,
+		observe:   o.cacheObserver,
+		log:       o.logger,
+		exactFile: o.exactSidecar,
 	}
 
-	return r
+	if o.cacheSize > 0 {
+		cache, err := ristretto.NewCache(&ristretto.Config{
+			NumCounters: max(o.cacheSize/5, 1),
+			MaxCost:     o.cacheSize,
+			BufferItems: 64,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error setting reader cache: %w", err)
+		}
+
+		r.cache = cache
+	}
+
+	return r, nil
 }
 
 // Initialize only loads the database index into memory. This does not load the whole file in RAM.
+//
+// The file is kept open until Close is called, so the Reader keeps querying the same data even
+// if the file is replaced on disk.
 func (r *Reader) Initialize() error {
-	// Only open the file for initialization.
 	file, err := os.OpenFile(r.fileName, os.O_RDONLY, 444)
 	if err != nil {
 		return err
 	}
 
-	defer func(file *os.File) {
-		if err := file.Close(); err != nil {
-			log.Fatal().Err(err).Msg("error closing GCS file file")
+	if err = r.readIndex(file); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	r.file = file
+	r.size = info.Size()
+
+	if r.exactFile != "" {
+		if err = r.openSidecar(); err != nil {
+			_ = file.Close()
+			r.file = nil
+			return fmt.Errorf("error opening exact sidecar %s: %w", r.exactFile, err)
 		}
-	}(file)
+		r.log.Info().Msgf("using exact sidecar %s, queries have no false positives", r.exactFile)
+	}
 
-	// Reads the footer that the file should have. 40 bytes.
-	if _, err = file.Seek(-40, io.SeekEnd); err != nil {
+	p := message.NewPrinter(language.English)
+	r.log.Info().Msgf("ready for queries on %s items with a 1 in %s false-positive rate.", p.Sprintf("%d", r.num), p.Sprintf("%d", r.probability))
+	return nil
+}
+
+// openSidecar opens the exact sidecar, checking that it was created for the GCS file of the Reader.
+// A sidecar of another file would answer that hashes of this one are not found.
+func (r *Reader) openSidecar() error {
+	exact, err := openSidecar(r.exactFile)
+	if err != nil {
+		return err
+	}
+
+	binding, err := r.binding()
+	if err != nil {
+		_ = exact.Close()
+		return err
+	}
+
+	if exact.gcs != binding {
+		_ = exact.Close()
+		return fmt.Errorf("%w: it was created for a GCS file with %d items, 1 in %d false positives and %d bytes",
+			ErrSidecarMismatch, exact.gcs.num, exact.gcs.probability, exact.gcs.size)
+	}
+
+	r.exact = exact
+	return nil
+}
+
+// binding identifies the GCS file of the Reader, for its exact sidecar.
+func (r *Reader) binding() (gcsBinding, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r.file, int64(r.endOfData), r.size-int64(r.endOfData))); err != nil {
+		return gcsBinding{}, decodeError(err)
+	}
+
+	g := gcsBinding{num: r.num, probability: r.probability, size: uint64(r.size)}
+	h.Sum(g.digest[:0])
+	return g, nil
+}
+
+// Close releases the file handle and the cache of the Reader. The Reader must not be used
+// after calling Close.
+func (r *Reader) Close() (err error) {
+	if r.cache != nil {
+		r.cache.Close()
+	}
+	if r.exact != nil {
+		err = r.exact.Close()
+	}
+	if r.file != nil {
+		if fileErr := r.file.Close(); fileErr != nil {
+			err = fileErr
+		}
+	}
+
+	return err
+}
+
+// Num is the number of items in the database.
+func (r *Reader) Num() uint64 {
+	return r.num
+}
+
+// Probability is the false positive rate for queries, 1-in-p.
+func (r *Reader) Probability() uint64 {
+	return r.probability
+}
+
+// Version is the version of the file format: 0 for files with a flat index, 1 for files with a
+// compact index.
+func (r *Reader) Version() int {
+	if r.compact {
+		return 1
+	}
+
+	return 0
+}
+
+// IndexLen is the number of index points of the database.
+func (r *Reader) IndexLen() uint64 {
+	return r.indexLen
+}
+
+func (r *Reader) readIndex(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
 		return err
 	}
 
-	buf := make([]byte, 8)
-	if _, err = file.Read(buf); err != nil {
+	if info.Size() < footerSize {
+		return ErrNotGCS
+	}
+
+	// Reads the footer that the file should have. 40 bytes.
+	if _, err = file.Seek(-footerSize, io.SeekEnd); err != nil {
 		return err
 	}
-	r.num = binary.BigEndian.Uint64(buf)
-	log.Debug().Msgf("number of items: %d", r.num)
 
-	buf = make([]byte, 8)
-	if _, err = file.Read(buf); err != nil {
+	if r.num, err = readUint64(file); err != nil {
 		return err
 	}
-	r.probability = binary.BigEndian.Uint64(buf)
-	log.Debug().Msgf("Probability: %d", r.probability)
+	r.log.Debug().Msgf("number of items: %d", r.num)
 
-	r.log2p = uint8(math.Ceil(math.Log2(float64(r.probability))))
-	log.Debug().Msgf("Log2: %d", r.log2p)
+	if r.probability, err = readUint64(file); err != nil {
+		return err
+	}
+	r.log.Debug().Msgf("Probability: %d", r.probability)
 
-	buf = make([]byte, 8)
-	if _, err = file.Read(buf); err != nil {
+	if r.endOfData, err = readUint64(file); err != nil {
 		return err
 	}
-	r.endOfData = binary.BigEndian.Uint64(buf)
-	log.Debug().Msgf("End of Data: %d", r.endOfData)
+	r.log.Debug().Msgf("End of Data: %d", r.endOfData)
 
-	buf = make([]byte, 8)
-	if _, err = file.Read(buf); err != nil {
+	if r.indexLen, err = readUint64(file); err != nil {
 		return err
 	}
-	r.indexLen = binary.BigEndian.Uint64(buf)
-	log.Debug().Msgf("Index Length: %d", r.indexLen)
+	r.log.Debug().Msgf("Index Length: %d", r.indexLen)
 
-	buf = make([]byte, 8)
-	if _, err = file.Read(buf); err != nil {
+	magic := make([]byte, len(gcsMagic))
+	if _, err = io.ReadFull(file, magic); err != nil {
 		return err
 	}
 
-	if string(buf) != gcsMagic {
-		return fmt.Errorf("not a GCS File")
+	compact := string(magic) == gcsCompactMagic
+	if string(magic) != gcsMagic && !compact {
+		return ErrNotGCS
+	}
+	r.compact = compact
+
+	if r.num == 0 || r.probability == 0 {
+		return fmt.Errorf("%w: footer has %d items with probability %d", ErrCorrupt, r.num, r.probability)
+	}
+
+	r.log2p = uint8(math.Ceil(math.Log2(float64(r.probability))))
+	r.log.Debug().Msgf("Log2: %d", r.log2p)
+
+	// The index sits between the end of the data and the footer.
+	indexSize := uint64(info.Size()) - footerSize
+	if r.endOfData > indexSize {
+		return fmt.Errorf("%w: end of data %d is past the index", ErrTruncated, r.endOfData)
+	}
+	if !compact && (indexSize-r.endOfData)/16 != r.indexLen {
+		return fmt.Errorf("%w: expected %d index entries, file has space for %d",
+			ErrCorrupt, r.indexLen, (indexSize-r.endOfData)/16)
 	}
 
 	// Move the file pointer where the index starts
@@ -120,106 +265,113 @@ func (r *Reader) Initialize() error {
 		return err
 	}
 
-	// slurp in the index.
-	r.index = make([]indexPair, 0, 1+r.indexLen)
-	r.index = append(r.index, indexPair{0, 0})
-
-	log.Info().Msg("initializing database")
-	for i := uint64(0); i < r.indexLen; i++ {
-		buf = make([]byte, 8)
-		if _, err = file.Read(buf); err != nil {
+	r.log.Info().Msg("initializing database")
+	in := bufio.NewReader(io.LimitReader(file, int64(indexSize-r.endOfData)))
+	if compact {
+		if r.index, err = readCompactIndex(in, r.indexLen, (indexSize-r.endOfData)/8); err != nil {
 			return err
 		}
-		val := binary.BigEndian.Uint64(buf)
 
-		buf = make([]byte, 8)
-		if _, err = file.Read(buf); err != nil {
-			return err
+		if _, err = in.ReadByte(); err != io.EOF {
+			return fmt.Errorf("%w: unexpected data after the index", ErrCorrupt)
 		}
-		bitPos := binary.BigEndian.Uint64(buf)
+	} else {
+		// slurp in the index.
+		flat := make(flatIndex, 0, 1+r.indexLen)
+		flat = append(flat, indexPair{0, 0})
+
+		for i := uint64(0); i < r.indexLen; i++ {
+			val, err := readUint64(in)
+			if err != nil {
+				return err
+			}
 
-		r.index = append(r.index, indexPair{value: val, bitPos: bitPos})
+			bitPos, err := readUint64(in)
+			if err != nil {
+				return err
+			}
+
+			flat = append(flat, indexPair{value: val, bitPos: bitPos})
+		}
+
+		r.index = flat
 	}
 
-	p := message.NewPrinter(language.English)
-	log.Info().Msgf("ready for queries on %s items with a 1 in %s false-positive rate.", p.Sprintf("%d", r.num), p.Sprintf("%d", r.probability))
+	r.log.Debug().Msgf("Index uses %d bytes of memory", r.index.size())
 	return nil
 }
 
+// Exists checks if the query key is probably in the database. Keys can be created with
+// KeyFromPassword and KeyFromHex.
 func (r *Reader) Exists(target uint64) (bool, error) {
-	s := util.Stats()
-	defer s()
-
-	// Check if hash is in cache. Avoids opening the file if the hashed password is present
-	c, ok := r.cache.Get(target)
-	if ok {
-		return c.(bool), nil
+	// Check if hash is in cache. Avoids reading the file if the hashed password is present
+	if c, ok := r.cacheGet(target); ok {
+		return c, nil
 	}
 
-	// By opening a file pointer everytime we check if a password is pwned, we improve performance
-	// by *a lot*. The tradeoff is the cost in CPU cycles.
-	//
-	// For example, by only having a single file pointer and 500 concurrent requests, the CPU usage
-	// (8c/16t) was about 45% but the response time for the requests start going into the 100s of
-	// seconds due to the synchronization overhead from multithreaded file access.
-	//
-	// With a file pointer per request the response times never go above 7s, of course with 100%
-	// CPU usage in all cores.
-	//
-	// This is a solution that works "well enough". I probably should change it to something more CPU
-	// efficient. Less power used = less global warming I guess...
-	file, err := os.OpenFile(r.fileName, os.O_RDONLY, 444)
+	found, err := r.exists(target)
 	if err != nil {
 		return false, err
 	}
 
-	defer func(file *os.File) {
-		if err := file.Close(); err != nil {
-			log.Fatal().Err(err).Msg("error closing GCS file file")
+	r.cacheSet(target, found)
+	return found, nil
+}
+
+// Check decodes the data of the first and last index points, without the cache, to check that the
+// database can still be queried. The targets are not index entries, which are found without
+// decoding: 1 is decoded from the start of the data, and the highest value to its end.
+func (r *Reader) Check() error {
+	for _, target := range []uint64{1, r.num*r.probability - 1} {
+		if _, err := r.exists(target); err != nil {
+			return err
 		}
-	}(file)
+	}
+
+	return nil
+}
+
+func (r *Reader) exists(target uint64) (bool, error) {
+	// Sharing a single file pointer (Seek + Read) between concurrent requests made the response
+	// times go into the 100s of seconds due to the synchronization overhead from multithreaded
+	// file access. Opening a file pointer per request fixed that, but it also meant that a
+	// request could open a different file than the one the index was loaded from.
+	//
+	// A section reader uses positional reads (pread) on the file handle opened by Initialize, so
+	// each request has its own offset without any synchronization, and the data always matches
+	// the index in memory.
+	file := io.NewSectionReader(r.file, 0, r.size)
 
 	h := target % (r.num * r.probability)
-	// Maybe the computed hash is present exactly as is on the index.
-	exact, closest := binarySearch(r.index, h)
-	if exact > 0 {
-		r.cache.SetWithTTL(target, true, 1, hashCacheItemTTL)
+	// Start decoding from the closest lower value in the index. Maybe the computed hash is
+	// present exactly as is on the index. The first entry, {0, 0}, is only where the data starts
+	// and not a value of the database, so at least one value is decoded after it.
+	lastEntry := r.index.floor(h)
+	first := lastEntry.bitPos == 0
+	if lastEntry.value == h && !first {
 		return true, nil
 	}
 
-	// We have to get the closest low value, not the binary latest if the item is not found.
-	// This is equivalent to rust's saturating_sub(1) function on binary_search_by_key
-	lastEntry := r.index[closest-1]
-
 	reader := newBitReader(file)
-	if _, err = reader.Seek(int64(lastEntry.bitPos), io.SeekStart); err != nil {
-		return false, err
+	if _, err := reader.Seek(int64(lastEntry.bitPos), io.SeekStart); err != nil {
+		return false, decodeError(err)
 	}
 
 	// Try to find the probable match from the closest element found in the index.
 	last := lastEntry.value
-	for last < h {
-		diff := uint64(0)
-
-		for {
-			re, err := reader.ReadBits(1)
-			if err != nil {
-				return false, err
-			}
-
-			if re == 1 {
-				diff += r.probability
-			} else {
-				break
-			}
+	for ; first || last < h; first = false {
+		// The quotient is unary coded
+		q, err := reader.ReadUnary()
+		if err != nil {
+			return false, decodeError(err)
 		}
 
 		re, err := reader.ReadBits(r.log2p)
 		if err != nil {
-			return false, err
+			return false, decodeError(err)
 		}
 
-		diff += re
+		diff := q*r.probability + re
 		last += diff
 
 		// End of file
@@ -228,7 +380,61 @@ func (r *Reader) Exists(target uint64) (bool, error) {
 		}
 	}
 
-	found := last == h
-	r.cache.SetWithTTL(target, found, 1, hashCacheItemTTL)
-	return found, nil
+	return last == h, nil
+}
+
+// HasExactSidecar is true if the Reader confirms matches with an exact sidecar.
+func (r *Reader) HasExactSidecar() bool {
+	return r.exact != nil
+}
+
+// Lookup checks if the hash is in the database. Probable matches of the GCS are confirmed with the
+// exact sidecar, if the Reader has one.
+func (r *Reader) Lookup(hash Hash) (Result, error) {
+	found, err := r.Exists(hash.Key())
+	if err != nil || !found {
+		return Result{Found: false, Exact: true}, err
+	}
+
+	if r.exact == nil {
+		return Result{Found: true, Exact: false}, nil
+	}
+
+	found, err = r.exact.contains(hash)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{Found: found, Exact: true}, nil
+}
+
+func (r *Reader) cacheGet(target uint64) (bool, bool) {
+	if r.cache == nil {
+		return false, false
+	}
+
+	c, ok := r.cache.Get(target)
+	if r.observe != nil {
+		r.observe(ok)
+	}
+	if !ok {
+		return false, false
+	}
+
+	return c.(bool), true
+}
+
+func (r *Reader) cacheSet(target uint64, found bool) {
+	if r.cache != nil {
+		r.cache.SetWithTTL(target, found, 1, r.cacheTTL)
+	}
+}
+
+// decodeError converts reads past the end of the file into ErrTruncated.
+func decodeError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+
+	return err
 }
@@ -6,19 +6,35 @@ package gcs
 import (
 	"crypto/sha1"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 )
 
 func TestReader_InvalidFile(t *testing.T) {
-	reader := NewReader("../../test/data/pwned-sample-sha1.txt")
-	if err := reader.Initialize(); err == nil {
-		t.Errorf("Should fail")
+	reader, err := NewReader("../test/data/pwned-sample-sha1.txt")
+	if err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+
+	if err = reader.Initialize(); !errors.Is(err, ErrNotGCS) {
+		t.Errorf("Should fail with ErrNotGCS, got: %v", err)
 	}
 }
 
 func TestReader(t *testing.T) {
-	reader := NewReader("../../test/data/pwned-sample.gcs")
-	if err := reader.Initialize(); err != nil {
+	reader, err := NewReader("../test/data/pwned-sample.gcs")
+	if err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+
+	if err = reader.Initialize(); err != nil {
 		t.Errorf("Should not fail: %s", err)
 	}
 
@@ -50,3 +66,315 @@ func TestReader(t *testing.T) {
 		t.Errorf("Password should not be on file")
 	}
 }
+
+func TestReader_CacheObserver(t *testing.T) {
+	var hits, misses atomic.Int64
+	reader, err := NewReader("../test/data/pwned-sample.gcs", WithCacheObserver(func(hit bool) {
+		if hit {
+			hits.Add(1)
+		} else {
+			misses.Add(1)
+		}
+	}))
+	if err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+
+	if err = reader.Initialize(); err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+	defer reader.Close()
+
+	key := KeyFromPassword("password")
+	if _, err = reader.Exists(key); err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+	// The cache sets values in the background
+	reader.cache.Wait()
+	if _, err = reader.Exists(key); err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+
+	// Check doesn't use the cache
+	if err = reader.Check(); err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+
+	if hits.Load() != 1 || misses.Load() != 1 {
+		t.Errorf("Should have 1 hit and 1 miss, got %d and %d", hits.Load(), misses.Load())
+	}
+}
+
+func TestReader_FileReplaced(t *testing.T) {
+	data, err := os.ReadFile("../test/data/pwned-sample.gcs")
+	if err != nil {
+		t.Fatalf("Should not fail reading file: %s", err)
+	}
+
+	fileName := filepath.Join(t.TempDir(), "pwned.gcs")
+	if err = os.WriteFile(fileName, data, 0644); err != nil {
+		t.Fatalf("Should not fail writing file: %s", err)
+	}
+
+	reader, err := NewReader(fileName)
+	if err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+
+	if err = reader.Initialize(); err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+
+	t.Cleanup(func() {
+		if err := reader.Close(); err != nil {
+			t.Fatalf("Should not fail closing reader: %s", err)
+		}
+	})
+
+	// Replace the file on disk, the reader must keep using the one it was initialized with
+	replacement := filepath.Join(t.TempDir(), "replacement.gcs")
+	if err = os.WriteFile(replacement, make([]byte, len(data)), 0644); err != nil {
+		t.Fatalf("Should not fail writing file: %s", err)
+	}
+	if err = os.Rename(replacement, fileName); err != nil {
+		t.Fatalf("Should not fail replacing file: %s", err)
+	}
+
+	h := sha1.New()
+	h.Write([]byte("password"))
+	hash := binary.BigEndian.Uint64(h.Sum(nil))
+
+	exists, err := reader.Exists(hash)
+	if err != nil {
+		t.Errorf("Should not fail: %s", err)
+	}
+
+	if !exists {
+		t.Errorf("Password should be on file")
+	}
+}
+
+func TestReader_CheckTruncated(t *testing.T) {
+	fileName := buildTestDatabase(t)
+	reader := newTestReader(t, fileName)
+	if err := reader.Check(); err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+
+	// Check decodes the data, it doesn't stop at the index
+	if err := os.Truncate(fileName, 0); err != nil {
+		t.Fatalf("Should not fail truncating file: %s", err)
+	}
+	if err := reader.Check(); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Check should fail with %v, got: %v", ErrTruncated, err)
+	}
+}
+
+func TestReader_Truncated(t *testing.T) {
+	data, err := os.ReadFile("../test/data/pwned-sample.gcs")
+	if err != nil {
+		t.Fatalf("Should not fail reading file: %s", err)
+	}
+
+	cases := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", []byte{}, ErrNotGCS},
+		{"no footer", data[:len(data)-1], ErrNotGCS},
+		{"no data", data[len(data)-footerSize:], ErrTruncated},
+		{"missing index entry", append(append([]byte{}, data[:100]...), data[len(data)-footerSize:]...), ErrTruncated},
+	}
+
+	for _, tc := range cases {
+		fileName := filepath.Join(t.TempDir(), "pwned.gcs")
+		if err = os.WriteFile(fileName, tc.data, 0644); err != nil {
+			t.Fatalf("Should not fail writing file: %s", err)
+		}
+
+		reader, err := NewReader(fileName)
+		if err != nil {
+			t.Fatalf("Should not fail: %s", err)
+		}
+
+		if err = reader.Initialize(); !errors.Is(err, tc.want) {
+			t.Errorf("%s: Initialize should fail with %v, got: %v", tc.name, tc.want, err)
+		}
+	}
+}
+
+func TestReader_CompactIndex(t *testing.T) {
+	flat := newTestReader(t, buildTestDatabase(t, WithProbability(100), WithIndexGranularity(4)))
+	compact := newTestReader(t, buildTestDatabase(t, WithProbability(100), WithIndexGranularity(4), WithCompactIndex(true)))
+
+	if flat.Version() != 0 || compact.Version() != 1 {
+		t.Errorf("Versions should be 0 and 1, got %d and %d", flat.Version(), compact.Version())
+	}
+	if flat.IndexLen() != compact.IndexLen() {
+		t.Errorf("Index lengths should be the same, got %d and %d", flat.IndexLen(), compact.IndexLen())
+	}
+
+	hashes, err := os.ReadFile("../test/data/pwned-sample-sha1.txt")
+	if err != nil {
+		t.Fatalf("Should not fail reading file: %s", err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(hashes)), "\n") {
+		hash, err := KeyFromHex(line[0:40])
+		if err != nil {
+			t.Fatalf("Should not fail: %s", err)
+		}
+
+		// Also check values that are not in the database
+		for _, key := range []uint64{hash, hash + 1, hash - 1} {
+			want, err := flat.Exists(key)
+			if err != nil {
+				t.Fatalf("Should not fail: %s", err)
+			}
+
+			got, err := compact.Exists(key)
+			if err != nil {
+				t.Fatalf("Should not fail: %s", err)
+			}
+
+			if got != want {
+				t.Errorf("Exists(%x) with compact index: %t, want: %t", key, got, want)
+			}
+		}
+	}
+}
+
+func TestReader_FirstIndexEntry(t *testing.T) {
+	for _, compact := range []bool{false, true} {
+		fileName := filepath.Join(t.TempDir(), "pwned.gcs")
+		out, err := os.Create(fileName)
+		if err != nil {
+			t.Fatalf("Should not fail creating file: %s", err)
+		}
+
+		// 7 values, N*P = 112, with index entries for 38 and 41
+		builder, err := NewStreamBuilder(out, 7, WithProbability(16), WithIndexGranularity(2), WithCompactIndex(compact))
+		if err != nil {
+			t.Fatalf("Should not fail creating builder: %s", err)
+		}
+		for _, value := range []uint64{5, 17, 40, 41, 90, 120, 150} {
+			builder.Add(value)
+		}
+		if err = builder.Finish(); err != nil {
+			t.Fatalf("Should not fail building database: %s", err)
+		}
+		if err = out.Close(); err != nil {
+			t.Fatalf("Should not fail closing file: %s", err)
+		}
+
+		reader := newTestReader(t, fileName)
+		// The first index entry is {0, 0}, 0 is not in the database
+		for _, target := range []uint64{0, 112, 224} {
+			if found, err := reader.Exists(target); err != nil || found {
+				t.Errorf("Compact %v: Exists(%d): %t, %v, want: false", compact, target, found, err)
+			}
+		}
+		for _, target := range []uint64{38, 40, 41, 90} {
+			if found, err := reader.Exists(target); err != nil || !found {
+				t.Errorf("Compact %v: Exists(%d): %t, %v, want: true", compact, target, found, err)
+			}
+		}
+	}
+}
+
+// buildTestDatabase creates a GCS file from the sample Pwned Passwords file
+func buildTestDatabase(t *testing.T, opts ...Option) string {
+	in, err := os.Open("../test/data/pwned-sample-sha1.txt")
+	if err != nil {
+		t.Fatalf("Should not fail opening file: %s", err)
+	}
+	defer in.Close()
+
+	fileName := filepath.Join(t.TempDir(), "pwned.gcs")
+	out, err := os.Create(fileName)
+	if err != nil {
+		t.Fatalf("Should not fail creating file: %s", err)
+	}
+	defer out.Close()
+
+	builder, err := NewBuilder(in, out, opts...)
+	if err != nil {
+		t.Fatalf("Should not fail creating builder: %s", err)
+	}
+
+	if err = builder.Process(); err != nil {
+		t.Fatalf("Should not fail processing file: %s", err)
+	}
+
+	return fileName
+}
+
+func newTestReader(t *testing.T, fileName string, opts ...Option) *Reader {
+	reader, err := NewReader(fileName, opts...)
+	if err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+
+	if err = reader.Initialize(); err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+
+	t.Cleanup(func() {
+		if err := reader.Close(); err != nil {
+			t.Fatalf("Should not fail closing reader: %s", err)
+		}
+	})
+
+	return reader
+}
+
+func BenchmarkReader_Exists(b *testing.B) {
+	rnd := rand.New(rand.NewSource(42))
+	in, err := os.Create(filepath.Join(b.TempDir(), "pwned.txt"))
+	if err != nil {
+		b.Fatalf("Should not fail creating file: %s", err)
+	}
+	for i := 0; i < 200000; i++ {
+		if _, err = fmt.Fprintf(in, "%016X%016X%08X:1\r\n", rnd.Uint64(), rnd.Uint64(), rnd.Uint32()); err != nil {
+			b.Fatalf("Should not fail writing file: %s", err)
+		}
+	}
+	if _, err = in.Seek(0, io.SeekStart); err != nil {
+		b.Fatalf("Should not fail seeking file: %s", err)
+	}
+	defer in.Close()
+
+	fileName := filepath.Join(b.TempDir(), "pwned.gcs")
+	out, err := os.Create(fileName)
+	if err != nil {
+		b.Fatalf("Should not fail creating file: %s", err)
+	}
+
+	builder, err := NewBuilder(in, out)
+	if err != nil {
+		b.Fatalf("Should not fail creating builder: %s", err)
+	}
+	if err = builder.Process(); err != nil {
+		b.Fatalf("Should not fail processing file: %s", err)
+	}
+	if err = out.Close(); err != nil {
+		b.Fatalf("Should not fail closing file: %s", err)
+	}
+
+	reader, err := NewReader(fileName, WithCache(0, 0))
+	if err != nil {
+		b.Fatalf("Should not fail: %s", err)
+	}
+	if err = reader.Initialize(); err != nil {
+		b.Fatalf("Should not fail: %s", err)
+	}
+	defer reader.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = reader.Exists(rnd.Uint64()); err != nil {
+			b.Fatalf("Should not fail: %s", err)
+		}
+	}
+}
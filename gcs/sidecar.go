@@ -0,0 +1,348 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package gcs
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"github.com/alvinbaena/pwd-checker/progress"
+	"github.com/rs/zerolog"
+	"io"
+	"os"
+	"slices"
+)
+
+const (
+	// The entries of the sidecar are bucketed by the first 16 bits of the hash, which are not
+	// stored in the entry.
+	sidecarPrefixBytes = 2
+	sidecarBuckets     = 1 << (8 * sidecarPrefixBytes)
+	sidecarEntrySize   = len(Hash{}) - sidecarPrefixBytes
+	// prefix bytes, number of entries, GCS binding [magic]
+	sidecarFooterSize = 16 + gcsBindingSize + 8
+	sidecarMagic      = "[GCS:x1]"
+	// N, P, file size and digest
+	gcsBindingSize = 24 + sha256.Size
+	// sidecarPassMemory is the max memory used to hold hashes on each pass over the input file
+	sidecarPassMemory = 2 * 1024 * 1024 * 1024
+)
+
+// SidecarFileName is the conventional name of the exact sidecar of a GCS file.
+func SidecarFileName(gcsFileName string) string {
+	return gcsFileName + ".exact"
+}
+
+// SidecarBuilder creates the exact sidecar of a GCS database: a sorted table of the full SHA1
+// hashes, bucketed by prefix. Readers use it to confirm the probable matches of the GCS, so
+// queries have no false positives.
+type SidecarBuilder struct {
+	in       *os.File
+	out      io.Writer
+	gcs      gcsBinding
+	num      uint64
+	stat     *status
+	log      zerolog.Logger
+	progress progress.Reporter
+	// Amount of hashes held in memory on each pass over the input file
+	passSize uint64
+}
+
+// NewSidecarBuilder builder for the exact sidecar of the GCS database gcsFileName, reading the
+// hashes from the same Pwned Passwords file (SHA1) used by the Builder. The input file is read once
+// for each 2GiB of hashes. The sidecar is bound to the GCS file, and Readers refuse to use it with
+// any other.
+//
+// Options: WithLogger, WithProgress.
+func NewSidecarBuilder(in *os.File, out io.Writer, gcsFileName string, opts ...Option) (*SidecarBuilder, error) {
+	o := newOptions(opts)
+
+	binding, err := readGCSBinding(gcsFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	estimatedLines, err := estimateFileLines(in)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SidecarBuilder{
+		in:       in,
+		out:      out,
+		gcs:      binding,
+		num:      estimatedLines,
+		log:      o.logger,
+		progress: o.progress,
+		passSize: sidecarPassMemory / uint64(len(Hash{})),
+	}, nil
+}
+
+// Process creates the sidecar file. The file has the entries of each bucket, sorted, followed by
+// the index of the first entry of each bucket and a footer.
+func (b *SidecarBuilder) Process() error {
+	b.stat = newStatus("exact-sidecar", b.progress)
+	b.log.Info().Msg("starting exact sidecar process. This might take a while, be patient :)")
+
+	passes := max((b.num+b.passSize-1)/b.passSize, 1)
+	bucketsPerPass := (sidecarBuckets + passes - 1) / passes
+	// Index of the first entry of each bucket, and the total at the end
+	offsets := make([]uint64, sidecarBuckets+1)
+	out := bufio.NewWriter(b.out)
+
+	total := uint64(0)
+	for pass := uint64(0); pass < passes; pass++ {
+		first := pass * bucketsPerPass
+		last := min(first+bucketsPerPass, sidecarBuckets)
+
+		b.stat.StageWork(fmt.Sprintf("Read pass %d of %d", pass+1, passes), b.num)
+		hashes, err := b.readBuckets(first, last)
+		if err != nil {
+			return err
+		}
+
+		b.stat.Stage("Sort")
+		slices.SortFunc(hashes, func(a, b Hash) int {
+			return bytes.Compare(a[:], b[:])
+		})
+		hashes = slices.Compact(hashes)
+
+		b.stat.StageWork("Write", uint64(len(hashes)))
+		bucket := first
+		for _, hash := range hashes {
+			for ; bucket <= hashBucket(hash); bucket++ {
+				offsets[bucket] = total
+			}
+
+			if _, err = out.Write(hash[sidecarPrefixBytes:]); err != nil {
+				return err
+			}
+			total++
+			b.stat.Incr()
+		}
+
+		for ; bucket < last; bucket++ {
+			offsets[bucket] = total
+		}
+	}
+	offsets[sidecarBuckets] = total
+	b.log.Debug().Msgf("exact sidecar has %d hashes", total)
+
+	b.stat.Stage("Write Index")
+	for _, offset := range offsets {
+		if _, err := out.Write(toFixedBytes(offset)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := out.Write(toFixedBytes(sidecarPrefixBytes)); err != nil {
+		return err
+	}
+	if _, err := out.Write(toFixedBytes(total)); err != nil {
+		return err
+	}
+	if _, err := out.Write(b.gcs.bytes()); err != nil {
+		return err
+	}
+	if _, err := out.Write([]byte(sidecarMagic)); err != nil {
+		return err
+	}
+
+	if err := out.Flush(); err != nil {
+		return err
+	}
+
+	b.stat.Done()
+	return nil
+}
+
+// readBuckets reads the hashes of the input file that belong to the buckets [first, last).
+func (b *SidecarBuilder) readBuckets(first uint64, last uint64) ([]Hash, error) {
+	if _, err := b.in.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	hashes := make([]Hash, 0, min(b.num, b.passSize))
+	scanner := bufio.NewScanner(b.in)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) < len(Hash{})*2 {
+			return nil, fmt.Errorf("invalid line in Pwned Passwords file: %q", line)
+		}
+
+		hash, err := HashFromHex(line[:len(Hash{})*2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid line in Pwned Passwords file: %q: %w", line, err)
+		}
+
+		if bucket := hashBucket(hash); bucket >= first && bucket < last {
+			hashes = append(hashes, hash)
+		}
+		b.stat.Incr()
+	}
+
+	return hashes, scanner.Err()
+}
+
+func hashBucket(hash Hash) uint64 {
+	bucket := uint64(0)
+	for _, c := range hash[:sidecarPrefixBytes] {
+		bucket = bucket<<8 | uint64(c)
+	}
+
+	return bucket
+}
+
+// gcsBinding identifies the GCS file of a sidecar: its N, P, size, and the SHA256 of its index and
+// footer, which change with any of its hashes.
+type gcsBinding struct {
+	num         uint64
+	probability uint64
+	size        uint64
+	digest      [sha256.Size]byte
+}
+
+// readGCSBinding reads the binding of a GCS file.
+func readGCSBinding(fileName string) (gcsBinding, error) {
+	reader, err := NewReader(fileName, WithCache(0, 0))
+	if err != nil {
+		return gcsBinding{}, err
+	}
+
+	if err = reader.Initialize(); err != nil {
+		return gcsBinding{}, err
+	}
+	defer reader.Close()
+
+	return reader.binding()
+}
+
+func (g gcsBinding) bytes() []byte {
+	buf := make([]byte, 0, gcsBindingSize)
+	buf = append(buf, toFixedBytes(g.num)...)
+	buf = append(buf, toFixedBytes(g.probability)...)
+	buf = append(buf, toFixedBytes(g.size)...)
+	return append(buf, g.digest[:]...)
+}
+
+func readBinding(r io.Reader) (g gcsBinding, err error) {
+	if g.num, err = readUint64(r); err != nil {
+		return g, err
+	}
+	if g.probability, err = readUint64(r); err != nil {
+		return g, err
+	}
+	if g.size, err = readUint64(r); err != nil {
+		return g, err
+	}
+	if _, err = io.ReadFull(r, g.digest[:]); err != nil {
+		return g, ErrTruncated
+	}
+
+	return g, nil
+}
+
+// sidecar reads the exact sidecar of a GCS database.
+type sidecar struct {
+	file    *os.File
+	offsets []uint64
+	entries uint64
+	gcs     gcsBinding
+}
+
+func openSidecar(fileName string) (*sidecar, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sidecar{file: file}
+	if err = s.readIndex(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *sidecar) readIndex() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	magic := make([]byte, len(sidecarMagic))
+	if _, err = s.file.ReadAt(magic, info.Size()-int64(len(magic))); err != nil {
+		return ErrNotGCS
+	}
+	if string(magic) != sidecarMagic {
+		return ErrNotGCS
+	}
+
+	indexSize := int64(sidecarBuckets+1) * 8
+	if info.Size() < sidecarFooterSize+indexSize {
+		return ErrNotGCS
+	}
+
+	footer := io.NewSectionReader(s.file, info.Size()-sidecarFooterSize, sidecarFooterSize)
+	prefixBytes, err := readUint64(footer)
+	if err != nil {
+		return err
+	}
+	if s.entries, err = readUint64(footer); err != nil {
+		return err
+	}
+	if s.gcs, err = readBinding(footer); err != nil {
+		return err
+	}
+
+	dataSize := info.Size() - sidecarFooterSize - indexSize
+	if prefixBytes != sidecarPrefixBytes || uint64(dataSize) != s.entries*uint64(sidecarEntrySize) {
+		return fmt.Errorf("%w: exact sidecar size does not match its %d hashes", ErrCorrupt, s.entries)
+	}
+
+	index := bufio.NewReader(io.NewSectionReader(s.file, dataSize, indexSize))
+	s.offsets = make([]uint64, sidecarBuckets+1)
+	for i := range s.offsets {
+		if s.offsets[i], err = readUint64(index); err != nil {
+			return err
+		}
+		if s.offsets[i] > s.entries || (i > 0 && s.offsets[i] < s.offsets[i-1]) {
+			return fmt.Errorf("%w: invalid exact sidecar index", ErrCorrupt)
+		}
+	}
+
+	return nil
+}
+
+// contains binary searches the bucket of the hash.
+func (s *sidecar) contains(hash Hash) (bool, error) {
+	bucket := hashBucket(hash)
+	low, high := s.offsets[bucket], s.offsets[bucket+1]
+	target := hash[sidecarPrefixBytes:]
+
+	entry := make([]byte, sidecarEntrySize)
+	for low < high {
+		mid := low + (high-low)/2
+		if _, err := s.file.ReadAt(entry, int64(mid)*int64(sidecarEntrySize)); err != nil {
+			return false, decodeError(err)
+		}
+
+		switch bytes.Compare(entry, target) {
+		case 0:
+			return true, nil
+		case -1:
+			low = mid + 1
+		default:
+			high = mid
+		}
+	}
+
+	return false, nil
+}
+
+func (s *sidecar) Close() error {
+	return s.file.Close()
+}
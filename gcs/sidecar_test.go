@@ -0,0 +1,167 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package gcs
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSidecarBuilder(t *testing.T) {
+	fileName := buildTestDatabase(t, WithProbability(100), WithIndexGranularity(4))
+	exactFile := buildTestSidecar(t, fileName)
+
+	probabilistic := newTestReader(t, fileName)
+	exact := newTestReader(t, fileName, WithExactSidecar(exactFile))
+	if !exact.HasExactSidecar() || probabilistic.HasExactSidecar() {
+		t.Fatalf("Only the exact reader should have a sidecar")
+	}
+
+	sample, err := os.ReadFile("../test/data/pwned-sample-sha1.txt")
+	if err != nil {
+		t.Fatalf("Should not fail reading file: %s", err)
+	}
+
+	inSample := make(map[Hash]bool)
+	for _, line := range strings.Split(strings.TrimSpace(string(sample)), "\n") {
+		hash, err := HashFromHex(line[0:40])
+		if err != nil {
+			t.Fatalf("Should not fail: %s", err)
+		}
+		inSample[hash] = true
+
+		result, err := exact.Lookup(hash)
+		if err != nil {
+			t.Fatalf("Should not fail: %s", err)
+		}
+		if !result.Exact {
+			t.Errorf("Lookup(%x) should be exact", hash)
+		}
+
+		// The GCS may have false negatives, the sidecar only confirms its matches
+		want, err := probabilistic.Exists(hash.Key())
+		if err != nil {
+			t.Fatalf("Should not fail: %s", err)
+		}
+		if result.Found != want {
+			t.Errorf("Lookup(%x): %t, want: %t", hash, result.Found, want)
+		}
+	}
+
+	// Find false positives of the GCS, they must not be found with the sidecar
+	rnd := rand.New(rand.NewSource(42))
+	falsePositives := 0
+	for falsePositives < 10 {
+		var hash Hash
+		rnd.Read(hash[:])
+		if inSample[hash] {
+			continue
+		}
+
+		result, err := probabilistic.Lookup(hash)
+		if err != nil {
+			t.Fatalf("Should not fail: %s", err)
+		}
+		if !result.Found {
+			if !result.Exact {
+				t.Errorf("Lookup(%x) not found should be exact", hash)
+			}
+			continue
+		}
+		if result.Exact {
+			t.Errorf("Lookup(%x) without sidecar should not be exact", hash)
+		}
+		falsePositives++
+
+		result, err = exact.Lookup(hash)
+		if err != nil {
+			t.Fatalf("Should not fail: %s", err)
+		}
+		if result.Found || !result.Exact {
+			t.Errorf("Lookup(%x) with sidecar: %+v, want an exact not found", hash, result)
+		}
+	}
+}
+
+func TestSidecar_Invalid(t *testing.T) {
+	exactFile := buildTestSidecar(t, buildTestDatabase(t, WithProbability(100)))
+	data, err := os.ReadFile(exactFile)
+	if err != nil {
+		t.Fatalf("Should not fail reading file: %s", err)
+	}
+
+	cases := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", []byte{}, ErrNotGCS},
+		{"gcs file", data[:len(data)-1], ErrNotGCS},
+		{"missing entry", data[sidecarEntrySize:], ErrCorrupt},
+		{"other magic", append(append([]byte{}, data[:len(data)-len(sidecarMagic)]...), "[GCS:x0]"...), ErrNotGCS},
+	}
+
+	for _, tc := range cases {
+		fileName := filepath.Join(t.TempDir(), "pwned.gcs.exact")
+		if err = os.WriteFile(fileName, tc.data, 0644); err != nil {
+			t.Fatalf("Should not fail writing file: %s", err)
+		}
+
+		if _, err = openSidecar(fileName); !errors.Is(err, tc.want) {
+			t.Errorf("%s: openSidecar should fail with %v, got: %v", tc.name, tc.want, err)
+		}
+	}
+}
+
+func TestSidecar_OtherGCS(t *testing.T) {
+	fileName := buildTestDatabase(t, WithProbability(100))
+	exactFile := buildTestSidecar(t, fileName)
+
+	// Same hashes and N, different GCS
+	for _, other := range []string{buildTestDatabase(t, WithProbability(1000)), buildTestDatabase(t, WithProbability(100), WithIndexGranularity(4))} {
+		reader, err := NewReader(other, WithExactSidecar(exactFile))
+		if err != nil {
+			t.Fatalf("Should not fail: %s", err)
+		}
+
+		if err = reader.Initialize(); !errors.Is(err, ErrSidecarMismatch) {
+			t.Errorf("Initialize should fail with ErrSidecarMismatch, got: %v", err)
+		}
+	}
+
+	newTestReader(t, fileName, WithExactSidecar(exactFile))
+}
+
+// buildTestSidecar creates the sidecar of the GCS file of the sample Pwned Passwords file, with
+// several passes
+func buildTestSidecar(t *testing.T, gcsFileName string) string {
+	in, err := os.Open("../test/data/pwned-sample-sha1.txt")
+	if err != nil {
+		t.Fatalf("Should not fail opening file: %s", err)
+	}
+	defer in.Close()
+
+	fileName := filepath.Join(t.TempDir(), "pwned.gcs.exact")
+	out, err := os.Create(fileName)
+	if err != nil {
+		t.Fatalf("Should not fail creating file: %s", err)
+	}
+	defer out.Close()
+
+	builder, err := NewSidecarBuilder(in, out, gcsFileName)
+	if err != nil {
+		t.Fatalf("Should not fail creating builder: %s", err)
+	}
+	builder.passSize = 30
+
+	if err = builder.Process(); err != nil {
+		t.Fatalf("Should not fail processing file: %s", err)
+	}
+
+	return fileName
+}
@@ -4,59 +4,52 @@
 package gcs
 
 import (
-	"github.com/rs/zerolog/log"
+	"github.com/alvinbaena/pwd-checker/progress"
 	"sync/atomic"
 	"time"
 )
 
 type status struct {
+	process    string
 	stageName  *string
 	workCount  uint64
 	doneCount  uint64
 	step       uint64
 	start      time.Time
 	stageStart time.Time
+	reporter   progress.Reporter
 }
 
-func newStatus() *status {
-	return &status{start: time.Now()}
+func newStatus(process string, reporter progress.Reporter) *status {
+	return &status{process: process, start: time.Now(), reporter: reporter}
 }
 
 func (s *status) Stage(stage string) {
-	s.FinishStage()
-
-	s.stageName = &stage
-	log.Info().Msgf("%s starting...", *s.stageName)
-
-	s.stageStart = time.Now()
-	s.doneCount = 0
+	s.StageWork(stage, 0)
 }
 
 func (s *status) SetWork(count uint64) {
 	s.workCount = count
-	s.step = count / 20
+	s.step = max(count/20, 1)
 }
 
 func (s *status) StageWork(name string, work uint64) {
-	s.Stage(name)
+	s.FinishStage()
+
+	s.stageName = &name
+	s.stageStart = time.Now()
+	s.doneCount = 0
 	s.SetWork(work)
+	s.reporter.Report(progress.NewEvent(progress.StageStarted, s.process, name, 0, work, 0))
 }
 
 func (s *status) PrintStatus() {
-	elapsed := time.Since(s.start)
-	log.Info().Msgf(
-		"%s: %d of %d, %.2f%%, %.0f/s",
-		*s.stageName,
-		s.doneCount,
-		s.workCount,
-		float64(s.doneCount)/float64(s.workCount)*100,
-		float64(s.doneCount)/elapsed.Seconds()+float64(elapsed.Nanoseconds()/1_000_000_000),
-	)
+	s.reporter.Report(progress.NewEvent(progress.StageProgress, s.process, *s.stageName,
+		atomic.LoadUint64(&s.doneCount), s.workCount, time.Since(s.stageStart)))
 }
 
 func (s *status) AddWork(count uint64) {
-	atomic.AddUint64(&s.doneCount, count)
-	if s.doneCount%s.step == 0 {
+	if done := atomic.AddUint64(&s.doneCount, count); done%s.step == 0 {
 		s.PrintStatus()
 	}
 }
@@ -66,9 +59,9 @@ func (s *status) Incr() {
 }
 
 func (s *status) FinishStage() {
-	if s.stageName != nil {
-		elapsed := time.Since(s.stageStart)
-		log.Info().Msgf("%s complete in %v", *s.stageName, elapsed)
+	if s.stageName != nil && *s.stageName != "" {
+		s.reporter.Report(progress.NewEvent(progress.StageFinished, s.process, *s.stageName,
+			atomic.LoadUint64(&s.doneCount), s.workCount, time.Since(s.stageStart)))
 	}
 
 	none := ""
@@ -77,6 +70,5 @@ func (s *status) FinishStage() {
 
 func (s *status) Done() {
 	s.FinishStage()
-	elapsed := time.Since(s.start)
-	log.Info().Msgf("complete in %v", elapsed)
+	s.reporter.Report(progress.NewEvent(progress.Finished, s.process, "", 0, 0, time.Since(s.start)))
 }
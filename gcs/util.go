@@ -5,48 +5,52 @@ package gcs
 
 import (
 	"encoding/binary"
-	"github.com/rs/zerolog/log"
+	"fmt"
 	"io"
 	"math"
 	"os"
 	"strconv"
 )
 
-const gcsMagic = "[GCS:v0]"
+const (
+	gcsMagic = "[GCS:v0]"
+	// gcsCompactMagic is used by files with a compact (Elias-Fano coded) index
+	gcsCompactMagic = "[GCS:v1]"
+)
 
-func U64FromHex(src []byte) uint64 {
-	result := uint64(0)
-	for _, c := range src {
-		result = result * 16
-		v, err := strconv.ParseUint(string(c), 16, 64)
-		if err != nil {
-			log.Fatal().Err(err).Msg("source not hex")
-		}
-		result += v
+// U64FromHex parses up to 16 hexadecimal characters as an uint64.
+func U64FromHex(src []byte) (uint64, error) {
+	result, err := strconv.ParseUint(string(src), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("source not hex: %w", err)
 	}
 
-	return result
+	return result, nil
 }
 
-func estimateFileLines(f *os.File) uint64 {
+func estimateFileLines(f *os.File) (uint64, error) {
 	// 16MiB
 	const EstimateLimit = 1024 * 1024 * 16
 
 	info, err := f.Stat()
 	if err != nil {
-		log.Fatal().Err(err).Msg("error estimating lines of file")
+		return 0, fmt.Errorf("error estimating lines of file: %w", err)
 	}
 
 	size := info.Size()
+	if size == 0 {
+		return 0, nil
+	}
+
 	sampleSize := math.Min(float64(size), EstimateLimit)
 	buffer := make([]byte, int64(sampleSize))
-	if _, err = f.Read(buffer); err != nil {
-		log.Fatal().Err(err).Msg("error estimating lines of file")
+	if _, err = f.ReadAt(buffer, 0); err != nil && err != io.EOF {
+		return 0, fmt.Errorf("error estimating lines of file: %w", err)
 	}
 	// Reset the file pointer to the start of the file so the actual read will not be missing a
 	// 16 MiB chunk
 	if _, err = f.Seek(0, io.SeekStart); err != nil {
-		log.Fatal().Err(err).Msg("error estimating lines of file")
+		return 0, fmt.Errorf("error estimating lines of file: %w", err)
 	}
 
 	// Count the amount of \n present in buffer
@@ -58,7 +62,20 @@ func estimateFileLines(f *os.File) uint64 {
 		}
 	}
 
-	return uint64(sample) * (uint64(size) / uint64(sampleSize))
+	return uint64(sample) * (uint64(size) / uint64(sampleSize)), nil
+}
+
+// readUint64 reads a big endian uint64, a short read means the file is truncated.
+func readUint64(r io.Reader) (uint64, error) {
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, ErrTruncated
+		}
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint64(buf), nil
 }
 
 func toFixedBytes(content uint64) []byte {
@@ -83,25 +100,3 @@ func dedup(slice []uint64) []uint64 {
 
 	return slice[:e]
 }
-
-func binarySearch(index []indexPair, value uint64) (int, int) {
-	r := -1 // not found
-	start := 0
-	end := len(index) - 1
-	last := start
-	for start <= end {
-		last = start + (end-start)/2
-		val := index[last]
-
-		if val.value == value {
-			r = last // found
-			break
-		} else if value > val.value {
-			start = last + 1
-		} else {
-			end = last - 1
-		}
-	}
-
-	return r, last
-}
@@ -0,0 +1,117 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package hibp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	// bitmap of completed ranges, output size [magic]
+	checkpointSize  = Ranges/8 + 16
+	checkpointMagic = "[HIBP:c]"
+)
+
+// CheckpointFileName is the name of the checkpoint kept next to a download output file.
+func CheckpointFileName(outFileName string) string {
+	return outFileName + ".checkpoint"
+}
+
+// checkpoint records the ranges already written to the output file, and the size of the file at
+// that moment. A resumed download truncates the file to that size, so ranges written after the
+// checkpoint was saved are not duplicated.
+type checkpoint struct {
+	fileName string
+	done     []uint64
+	size     uint64
+}
+
+func newCheckpoint(fileName string) *checkpoint {
+	return &checkpoint{fileName: fileName, done: make([]uint64, Ranges/64)}
+}
+
+// loadCheckpoint reads a saved checkpoint. If there is none, an empty one is returned.
+func loadCheckpoint(fileName string) (*checkpoint, error) {
+	c := newCheckpoint(fileName)
+
+	data, err := os.ReadFile(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) != checkpointSize || string(data[len(data)-8:]) != checkpointMagic {
+		return nil, fmt.Errorf("%s is not a download checkpoint file", fileName)
+	}
+
+	for i := range c.done {
+		c.done[i] = binary.BigEndian.Uint64(data[i*8:])
+	}
+	c.size = binary.BigEndian.Uint64(data[Ranges/8:])
+
+	return c, nil
+}
+
+func (c *checkpoint) isDone(i int) bool {
+	return c.done[i/64]&(1<<(i%64)) != 0
+}
+
+func (c *checkpoint) markDone(i int) {
+	c.done[i/64] |= 1 << (i % 64)
+}
+
+// count is the amount of completed ranges.
+func (c *checkpoint) count() int {
+	count := 0
+	for i := 0; i < Ranges; i++ {
+		if c.isDone(i) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// missing are the ranges from first to last that are not completed.
+func (c *checkpoint) missing(first int, last int) []int {
+	var missing []int
+	for i := first; i <= last; i++ {
+		if !c.isDone(i) {
+			missing = append(missing, i)
+		}
+	}
+
+	return missing
+}
+
+// save writes the checkpoint to a temporary file and renames it, so an interrupted save doesn't
+// leave a corrupt checkpoint behind.
+func (c *checkpoint) save() error {
+	data := make([]byte, checkpointSize)
+	for i, word := range c.done {
+		binary.BigEndian.PutUint64(data[i*8:], word)
+	}
+	binary.BigEndian.PutUint64(data[Ranges/8:], c.size)
+	copy(data[len(data)-8:], checkpointMagic)
+
+	tmp := c.fileName + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, c.fileName)
+}
+
+func (c *checkpoint) remove() error {
+	if err := os.Remove(c.fileName); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
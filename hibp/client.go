@@ -0,0 +1,170 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package hibp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/ratelimit"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBaseURL is the base URL of the Pwned Passwords range API.
+const DefaultBaseURL = "https://api.pwnedpasswords.com"
+
+func initHttpClient(o *options) (*retryablehttp.Client, error) {
+	client := retryablehttp.NewClient()
+	// Too much garbage in the logs, it slowed the download too much.
+	client.Logger = nil
+
+	// Retry Max 10 times on protocol errors. Any other are just reported and not retried.
+	client.RetryMax = 10
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS13,
+	}
+	if o.caBundle != "" {
+		pool, err := certPool(o.caBundle)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if o.proxy != "" {
+		proxyURL, err := url.Parse(o.proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", o.proxy)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	var transport http.RoundTripper = &http.Transport{
+		Proxy:              proxy,
+		DisableCompression: false,
+		TLSClientConfig:    tlsConfig,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       10 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		// HTTP/2 is "better" but only establishes one connection. Disabling it is much faster.
+		// It also introduced some read errors when getting the responses, so HTTP/1.1 it is.
+		ForceAttemptHTTP2:   false,
+		MaxIdleConnsPerHost: runtime.GOMAXPROCS(0) + 1,
+	}
+
+	if o.rateLimit > 0 {
+		transport = &rateLimitedTransport{next: transport, limiter: ratelimit.New(o.rateLimit)}
+	}
+
+	client.HTTPClient = &http.Client{Transport: transport}
+	return client, nil
+}
+
+// certPool is the system CA pool with the certificates of a PEM bundle added, for networks that
+// inspect TLS traffic with their own CA.
+func certPool(caBundle string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caBundle)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		log.Warn().Err(err).Msg("could not load the system CA pool, only the CA bundle is trusted")
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM encoded certificates found in CA bundle %s", caBundle)
+	}
+
+	return pool, nil
+}
+
+// rateLimitedTransport limits the requests per second sent by the downloader, retries included.
+type rateLimitedTransport struct {
+	next    http.RoundTripper
+	limiter ratelimit.Limiter
+}
+
+func (r *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.limiter.Take()
+	return r.next.RoundTrip(req)
+}
+
+// baseURLs validates the base URL and mirrors of the range API, removing trailing slashes.
+func baseURLs(baseURL string, mirrors []string) ([]string, error) {
+	urls := make([]string, 0, len(mirrors)+1)
+	for _, u := range append([]string{baseURL}, mirrors...) {
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid range API URL %q", u)
+		}
+
+		urls = append(urls, strings.TrimRight(u, "/"))
+	}
+
+	return urls, nil
+}
+
+// mirrors sends range requests to the first base URL that works, switching to the next one when a
+// request fails after all its retries.
+type mirrors struct {
+	urls    []string
+	current atomic.Int32
+}
+
+// do sends the request built by newRequest to each base URL until one of them responds with a 2xx
+// or 304 status.
+func (m *mirrors) do(ctx context.Context, client *retryablehttp.Client,
+	newRequest func(baseURL string) (*retryablehttp.Request, error)) (*http.Response, error) {
+	start := int(m.current.Load())
+
+	var lastErr error
+	for n := 0; n < len(m.urls); n++ {
+		k := (start + n) % len(m.urls)
+		req, err := newRequest(m.urls[k])
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := client.Do(req)
+		if err == nil && (res.StatusCode == http.StatusNotModified || (res.StatusCode >= 200 && res.StatusCode < 300)) {
+			return res, nil
+		}
+
+		if err == nil {
+			_ = res.Body.Close()
+			err = fmt.Errorf("request [%s] failed with status [%d] %s", req.URL, res.StatusCode, res.Status)
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
+		lastErr = err
+		next := (k + 1) % len(m.urls)
+		if len(m.urls) > 1 && m.current.CompareAndSwap(int32(k), int32(next)) {
+			log.Warn().Err(err).Msgf("range API %s failed, switching to %s", m.urls[k], m.urls[next])
+		}
+	}
+
+	return nil, lastErr
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package hibp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// shard is the output file of a download of some of the ranges, and its manifest.
+type shard struct {
+	fileName string
+	manifest *Manifest
+	// Where each range is in the file, in prefix order
+	blocks []rangeBlock
+	// Lowest and highest prefixes of the shard
+	first, last int
+}
+
+// Concat puts together the output files of downloads split in shards of prefixes (see
+// ProcessPrefixes) in outFileName, and writes its manifest. The prefixes of the shards must not
+// overlap, and each range must be in one of them. A shard with a manifest must match it. The ranges
+// are written in prefix order, no matter their order in the shards.
+func Concat(outFileName string, shardFileNames ...string) (*Manifest, error) {
+	return concat(outFileName, Ranges, shardFileNames)
+}
+
+// concat puts together the shards of the first ranges ranges.
+func concat(outFileName string, ranges int, shardFileNames []string) (*Manifest, error) {
+	shards := make([]shard, 0, len(shardFileNames))
+	owners := make([]int, ranges)
+	for i := range owners {
+		owners[i] = -1
+	}
+
+	for n, fileName := range shardFileNames {
+		log.Info().Msgf("checking shard %s", fileName)
+		s, err := readShard(fileName)
+		if err != nil {
+			return nil, err
+		}
+
+		for prefix := range s.manifest.Ranges {
+			i, err := ParsePrefix(prefix)
+			if err != nil {
+				return nil, fmt.Errorf("invalid range %q in shard %s: %w", prefix, fileName, err)
+			}
+			if i >= ranges {
+				return nil, fmt.Errorf("range %s of shard %s is not expected", prefix, fileName)
+			}
+			if owners[i] >= 0 {
+				return nil, fmt.Errorf("range %s is in shards %s and %s", prefix, shardFileNames[owners[i]], fileName)
+			}
+			owners[i] = n
+		}
+		shards = append(shards, s)
+	}
+
+	missing := 0
+	first := -1
+	for i, owner := range owners {
+		if owner < 0 {
+			missing++
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+	if missing > 0 {
+		return nil, fmt.Errorf("%d ranges are missing from the shards, starting with %s", missing, getHashRange(first))
+	}
+
+	sort.Slice(shards, func(a, b int) bool {
+		return shards[a].first < shards[b].first
+	})
+	// Otherwise the output would not be in prefix order
+	for n := 1; n < len(shards); n++ {
+		if prev := shards[n-1]; shards[n].first <= prev.last {
+			return nil, fmt.Errorf("the prefixes of shard %s (%s to %s) overlap the ones of shard %s (%s to %s)",
+				shards[n].fileName, getHashRange(shards[n].first), getHashRange(shards[n].last),
+				prev.fileName, getHashRange(prev.first), getHashRange(prev.last))
+		}
+	}
+
+	m, err := writeShards(outFileName, shards)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, m.Save(ManifestFileName(outFileName))
+}
+
+// readShard creates the manifest of a shard, checking it against the one written by its download.
+func readShard(fileName string) (shard, error) {
+	m, err := NewManifest(fileName)
+	if err != nil {
+		return shard{}, err
+	}
+
+	saved, err := LoadManifest(ManifestFileName(fileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return shard{}, err
+	}
+	if err == nil && (saved.SHA256 != m.SHA256 || saved.Size != m.Size || saved.Lines != m.Lines) {
+		return shard{}, fmt.Errorf("shard %s does not match its manifest %s", fileName, ManifestFileName(fileName))
+	}
+
+	return newShard(fileName, m)
+}
+
+// newShard sorts the ranges of a file by prefix.
+func newShard(fileName string, m *Manifest) (shard, error) {
+	blocks, err := m.sortedBlocks()
+	if err != nil {
+		return shard{}, err
+	}
+
+	s := shard{fileName: fileName, manifest: m, blocks: blocks, first: Ranges, last: -1}
+	if len(blocks) > 0 {
+		s.first, s.last = blocks[0].prefix, blocks[len(blocks)-1].prefix
+	}
+
+	return s, nil
+}
+
+// writeShards copies the ranges of the shards in order to a temporary file, renamed to outFileName
+// once complete. The manifest of the output is the one of the shards put together.
+func writeShards(outFileName string, shards []shard) (*Manifest, error) {
+	tmp := outFileName + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = out.Close()
+		_ = os.Remove(tmp)
+	}()
+
+	m := &Manifest{
+		File:   filepath.Base(outFileName),
+		Ranges: make(map[string]uint64),
+	}
+
+	h := sha256.New()
+	w := &countingWriter{w: io.MultiWriter(out, h)}
+	for _, s := range shards {
+		log.Info().Msgf("writing shard %s", s.fileName)
+		if err = copyBlocks(w, s.fileName, s.blocks, m); err != nil {
+			return nil, err
+		}
+
+		for prefix, lines := range s.manifest.Ranges {
+			m.Ranges[prefix] = lines
+		}
+		m.Lines += s.manifest.Lines
+	}
+
+	if err = out.Sync(); err != nil {
+		return nil, err
+	}
+	if err = out.Close(); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(tmp, outFileName); err != nil {
+		return nil, err
+	}
+
+	m.Size = w.n
+	m.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return m, nil
+}
+
+// copyBlocks copies the ranges of a file, ending each one with a line break if it has none. Where
+// they are written is added to the blocks of m.
+func copyBlocks(w *countingWriter, fileName string, blocks []rangeBlock, m *Manifest) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for _, b := range blocks {
+		offset := w.n
+		// The last byte written, to know if the range ends with a line break
+		last := &lastByteWriter{}
+		if _, err = io.Copy(io.MultiWriter(w, last), io.NewSectionReader(file, b.offset, b.length)); err != nil {
+			return err
+		}
+
+		if last.written && last.b != '\n' {
+			if _, err = w.Write([]byte("\r\n")); err != nil {
+				return err
+			}
+		}
+		m.blocks = append(m.blocks, rangeBlock{prefix: b.prefix, offset: offset, length: w.n - offset})
+	}
+
+	return nil
+}
+
+// countingWriter counts the bytes written, the offset of the next write.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type lastByteWriter struct {
+	b       byte
+	written bool
+}
+
+func (l *lastByteWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		l.b = p[len(p)-1]
+		l.written = true
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package hibp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConcat(t *testing.T) {
+	ranges := 8
+	dir := t.TempDir()
+
+	var mu sync.Mutex
+	requested := map[string]bool{}
+	server := newRangeServer(t, func(prefix string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		requested[prefix] = true
+		return http.StatusOK
+	})
+
+	// Two shards, the first one also with a manifest that doesn't match it
+	shards := []string{filepath.Join(dir, "shard-2.txt"), filepath.Join(dir, "shard-1.txt"), filepath.Join(dir, "shard-bad.txt")}
+	prefixes := [][2]int{{5, 7}, {0, 4}, {0, 4}}
+	for n, fileName := range shards {
+		file, err := os.Create(fileName)
+		if err != nil {
+			t.Fatalf("Should not fail creating a file: %s", err)
+		}
+
+		d := newTestDownloader(t, file, 2, server)
+		if err = d.ProcessPrefixes(context.Background(), prefixes[n][0], prefixes[n][1], true); err != nil {
+			t.Fatalf("Should not fail download: %s", err)
+		}
+		if err = file.Close(); err != nil {
+			t.Fatalf("Should not fail closing file: %s", err)
+		}
+	}
+	if len(requested) != ranges {
+		t.Errorf("%d ranges requested, want: %d", len(requested), ranges)
+	}
+
+	bad, err := os.OpenFile(shards[2], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Should not fail opening file: %s", err)
+	}
+	if _, err = bad.WriteString("00004FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF:1\r\n"); err != nil {
+		t.Fatalf("Should not fail writing file: %s", err)
+	}
+	if err = bad.Close(); err != nil {
+		t.Fatalf("Should not fail closing file: %s", err)
+	}
+
+	// Shards without manifests: one not in prefix order, one with the lines of a range apart, and
+	// two whose prefixes overlap without sharing a range
+	unsorted := writeTestShard(t, filepath.Join(dir, "shard-unsorted.txt"), 6, 5, 7)
+	split := writeTestShard(t, filepath.Join(dir, "shard-split.txt"), 5, 6, 5, 7)
+	low := writeTestShard(t, filepath.Join(dir, "shard-low.txt"), 0, 1, 2, 6)
+	high := writeTestShard(t, filepath.Join(dir, "shard-high.txt"), 3, 4, 5, 7)
+
+	cases := []struct {
+		name   string
+		shards []string
+		fail   bool
+	}{
+		{name: "missing", shards: shards[:1], fail: true},
+		{name: "repeated", shards: []string{shards[0], shards[1], shards[1]}, fail: true},
+		{name: "manifest mismatch", shards: []string{shards[0], shards[2]}, fail: true},
+		{name: "split", shards: []string{shards[1], split}, fail: true},
+		{name: "overlapping", shards: []string{low, high}, fail: true},
+		{name: "complete", shards: shards[:2], fail: false},
+		{name: "unsorted", shards: []string{unsorted, shards[1]}, fail: false},
+	}
+
+	for _, c := range cases {
+		out := filepath.Join(dir, "pwned-"+strings.ReplaceAll(c.name, " ", "-")+".txt")
+		_, err := concat(out, ranges, c.shards)
+		if c.fail {
+			if err == nil {
+				t.Errorf("%s: should fail", c.name)
+			}
+			if _, err = os.Stat(out); err == nil {
+				t.Errorf("%s: should not write the output file", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: should not fail: %s", c.name, err)
+		}
+
+		checkManifest(t, out, ranges)
+		// The ranges are written in prefix order, even if the shards are not
+		checkOrderedRanges(t, out, ranges)
+	}
+}
+
+// writeTestShard writes a shard with the lines of the ranges, in the given order.
+func writeTestShard(t *testing.T, fileName string, ranges ...int) string {
+	var b strings.Builder
+	for _, i := range ranges {
+		for n := 0; n < 3; n++ {
+			b.WriteString(fmt.Sprintf("%s%035X:%d\r\n", getHashRange(i), n, n+1))
+		}
+	}
+
+	if err := os.WriteFile(fileName, []byte(b.String()), 0644); err != nil {
+		t.Fatalf("Should not fail writing file: %s", err)
+	}
+
+	return fileName
+}
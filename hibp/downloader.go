@@ -6,81 +6,188 @@ package hibp
 import (
 	"bufio"
 	"bytes"
-	"crypto/tls"
+	"context"
+	"crypto/sha1"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/alvinbaena/pwd-checker/internal/util"
+	"github.com/alvinbaena/pwd-checker/progress"
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/rs/zerolog/log"
 	"github.com/thinhdanggroup/executor"
-	"golang.org/x/net/context"
 	"io"
-	"net"
 	"net/http"
 	"os"
 	"runtime"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
+// RangeWriter receives the ranges downloaded by a Downloader, one at a time. The lines are the
+// full SHA1 hashes of the range with their counts, HASH:COUNT, ending in CRLF. They are only valid
+// during the call.
+type RangeWriter interface {
+	WriteRange(prefix int, lines []byte) error
+}
+
+// RangeChecker is a RangeWriter that knows the ranges it already has. A download without an output
+// file can only be resumed with a RangeChecker, like a RangeDirectory.
+type RangeChecker interface {
+	RangeWriter
+	WrittenRanges() ([]int, error)
+}
+
 type Downloader struct {
 	parallelism int
 	stat        *status
 	wm          sync.Mutex
 	fileName    string
+	out         *os.File
 	writer      *bufio.Writer
+	rangeWriter RangeWriter
+	progress    progress.Reporter
 	http        *retryablehttp.Client
+	mirrors     *mirrors
+	resume      bool
+	retryFailed bool
+	ordered     bool
+	reorder     *reorderBuffer
+	checkpoint  *checkpoint
+	meta        *metadata
+	// Output file of the previous download, and its metadata
+	previousName string
+	previous     *os.File
+	previousMeta *metadata
+	// When the checkpoint was last saved
+	saved time.Time
+	fm    sync.Mutex
+	// Ranges that could not be downloaded, with their last error
+	failed map[int]error
+	// First error writing a range, which stops the download
+	writeErr error
+	stop     context.CancelFunc
+	// Wait before the first retry pass of the failed ranges, doubled on each pass
+	retryWait time.Duration
 }
 
-func NewDownloader(out *os.File, parallelism int) *Downloader {
-	return &Downloader{
-		parallelism: parallelism,
-		writer:      bufio.NewWriter(out),
-		http:        initHttpClient(),
-		fileName:    out.Name(),
-	}
-}
-
-func initHttpClient() *retryablehttp.Client {
-	client := retryablehttp.NewClient()
-	// Too much garbage in the logs, it slowed the download too much.
-	client.Logger = nil
-
-	// Retry Max 10 times on protocol errors. Any other are just reported and not retried.
-	client.RetryMax = 10
-
-	client.HTTPClient = &http.Client{
-		Transport: &http.Transport{
-			DisableCompression: false,
-			TLSClientConfig: &tls.Config{
-				MinVersion: tls.VersionTLS13,
-			},
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
-			MaxIdleConns:          100,
-			IdleConnTimeout:       10 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-			// HTTP/2 is "better" but only establishes one connection. Disabling it is much faster.
-			// It also introduced some read errors when getting the responses, so HTTP/1.1 it is.
-			ForceAttemptHTTP2:   false,
-			MaxIdleConnsPerHost: runtime.GOMAXPROCS(0) + 1,
-		},
+// Failed ranges are retried this many times after all the other ranges are downloaded.
+const retryPasses = 3
+
+// NewDownloader downloader of the Pwned Passwords hash ranges to the out file. out can be nil when
+// the ranges are given to a RangeWriter instead. Then the download can only be resumed if it's a
+// RangeChecker, and the failed ranges can't be retried on their own.
+//
+// Options: WithResume, WithRetryFailed, WithOrderedOutput, WithPrevious, WithBaseURL, WithMirrors,
+// WithProxy, WithCABundle, WithRateLimit, WithRangeWriter, WithProgress.
+func NewDownloader(out *os.File, parallelism int, opts ...Option) (*Downloader, error) {
+	o := newOptions(opts)
+	if out == nil && o.rangeWriter == nil {
+		return nil, fmt.Errorf("the downloader needs an output file or a range writer")
+	}
+	if _, checker := o.rangeWriter.(RangeChecker); out == nil && ((o.resume && !checker) || o.retryFailed) {
+		return nil, fmt.Errorf("a download can only be resumed with an output file")
 	}
 
-	return client
+	urls, err := baseURLs(o.baseURL, o.mirrors)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := initHttpClient(o)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Downloader{
+		parallelism:  parallelism,
+		out:          out,
+		rangeWriter:  o.rangeWriter,
+		progress:     o.progress,
+		http:         client,
+		mirrors:      &mirrors{urls: urls},
+		resume:       o.resume || o.retryFailed,
+		retryFailed:  o.retryFailed,
+		ordered:      o.ordered,
+		previousName: o.previous,
+		failed:       make(map[int]error),
+		retryWait:    30 * time.Second,
+	}
+	if out != nil {
+		d.writer = bufio.NewWriter(out)
+		d.fileName = out.Name()
+	}
+
+	return d, nil
+}
+
+// ProcessRanges downloads the first ranges hash ranges, see ProcessPrefixes.
+func (d *Downloader) ProcessRanges(ctx context.Context, ranges int, skipWait bool) error {
+	return d.ProcessPrefixes(ctx, 0, ranges-1, skipWait)
 }
 
-func (d *Downloader) ProcessRanges(ranges int, skipWait bool) error {
-	util.CheckDiskSpace(d.fileName, 40)
+// ProcessPrefixes downloads the hash ranges with prefixes from first to last, both included, so a
+// download can be split in shards put together later with Concat. The completed ranges are
+// recorded in a checkpoint next to the output file, so an interrupted download can be resumed. When
+// ctx is cancelled the running ranges finish, the checkpoint is saved and the download stops. The
+// same happens when a range can't be written, returning the error.
+//
+// Ranges that fail are retried after the others, with a growing wait between passes. With ordered
+// output they are retried right away instead, holding the ranges after them. If some still fail
+// they are listed in the failed ranges file and an error is returned. Responses that are not valid
+// ranges (ErrInvalidRange) fail like any other error.
+//
+// Once all the ranges are downloaded, a manifest with the lines of each range and the SHA256 of the
+// output file is written next to it. Without an output file there is no checkpoint, nor failed or
+// changed ranges files, nor manifest.
+func (d *Downloader) ProcessPrefixes(ctx context.Context, first int, last int, skipWait bool) error {
+	if first < 0 || last >= Ranges || first > last {
+		return fmt.Errorf("invalid prefixes %05X to %05X, they must be from 00000 to FFFFF", first, last)
+	}
+	ranges := last - first + 1
+
+	// Cancelled when a range can't be written
+	ctx, d.stop = context.WithCancel(ctx)
+	defer d.stop()
+
+	if d.out != nil {
+		// 40GiB for all the ranges
+		util.CheckDiskSpace(d.fileName, (40*ranges+Ranges-1)/Ranges)
+	}
 
 	s := util.Stats()
 	defer s()
 
+	if err := d.loadCheckpoint(); err != nil {
+		return err
+	}
+	if d.out != nil {
+		// The manifest is written again once the download completes
+		if err := os.Remove(ManifestFileName(d.fileName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if d.previousName != "" {
+		if err := d.openPrevious(); err != nil {
+			return err
+		}
+		defer func(previous *os.File) {
+			if err := previous.Close(); err != nil {
+				log.Error().Err(err).Msg("error closing previous Pwned Passwords file")
+			}
+		}(d.previous)
+	}
+
+	pending, err := d.pendingRanges(first, last)
+	if err != nil {
+		return err
+	}
+
 	var threads int
 	if d.parallelism > 0 {
 		threads = d.parallelism
@@ -101,31 +208,327 @@ func (d *Downloader) ProcessRanges(ranges int, skipWait bool) error {
 	}
 	defer downloadTasks.Close()
 
-	log.Info().Msgf("download Pwned Passwords SHA1 Hashes in file %s with %d threads, ^C to stop the process", d.fileName, threads)
+	if d.out != nil {
+		log.Info().Msgf("download Pwned Passwords SHA1 Hashes in file %s with %d threads, ^C to stop the process", d.fileName, threads)
+	} else {
+		log.Info().Msgf("download Pwned Passwords SHA1 Hashes with %d threads, ^C to stop the process", threads)
+	}
 	if !skipWait {
-		time.Sleep(10 * time.Second)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Second):
+		}
 	}
 	log.Info().Msg("starting process. This might take a while, be patient :)")
-	d.stat = newStatus(ranges)
+	d.stat = newStatus(ranges, d.previous != nil, d.progress)
 	d.stat.BeginProgress()
-
-	// Start downloading ranges concurrently from 00000 to FFFFF
-	for i := 0; i < ranges; i++ {
-		prefix := getHashRange(i)
-		if err = downloadTasks.Publish(d.ProcessRange, prefix); err != nil {
-			log.Panic().Err(err).Msgf("there is a programming error here.")
+	for i := first; i <= last; i++ {
+		if d.checkpoint.isDone(i) {
+			d.stat.RangeSkipped()
 		}
 	}
 
-	downloadTasks.Wait()
+	// Start downloading ranges concurrently from first to last
+	d.downloadRanges(ctx, downloadTasks, pending)
+	for pass, wait := 1, d.retryWait; !d.ordered && pass <= retryPasses && len(d.failed) > 0 && ctx.Err() == nil; pass, wait = pass+1, wait*2 {
+		log.Warn().Msgf("%d ranges failed, retrying them in %v (pass %d of %d)", len(d.failed), wait, pass, retryPasses)
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+			d.downloadRanges(ctx, downloadTasks, d.takeFailed())
+		}
+	}
 	d.stat.Done()
 
+	if err = d.saveCheckpoint(first, last); err != nil {
+		return err
+	}
+	if err = d.reportFailed(); err != nil {
+		return err
+	}
+	if d.writeErr != nil && d.out == nil {
+		return fmt.Errorf("download stopped: %w", d.writeErr)
+	}
+	if d.writeErr != nil {
+		return fmt.Errorf("download stopped, the completed ranges are saved in %s: %w", d.checkpoint.fileName, d.writeErr)
+	}
+	if ctx.Err() != nil && d.out == nil {
+		return fmt.Errorf("download interrupted: %w", ctx.Err())
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("download interrupted, the completed ranges are saved in %s: %w", d.checkpoint.fileName, ctx.Err())
+	}
+
+	if d.out == nil {
+		if len(d.failed) > 0 {
+			return fmt.Errorf("%d ranges could not be downloaded", len(d.failed))
+		}
+		return nil
+	}
+
 	if f, err := os.Stat(d.fileName); err == nil {
 		log.Debug().Msgf("file %s is %.2fGiB", d.fileName, float64(f.Size())/(1024*1024*1024))
 	}
+
+	if d.previous != nil {
+		changed := d.meta.changedRanges()
+		if len(changed) > 0 {
+			log.Info().Msgf("%d ranges changed since the previous download, they are listed in %s", len(changed), ChangedFileName(d.fileName))
+		} else {
+			log.Info().Msg("no ranges changed since the previous download")
+		}
+		if err = writeRangeList(ChangedFileName(d.fileName), changed); err != nil {
+			return err
+		}
+	}
+
+	if len(d.failed) > 0 {
+		return fmt.Errorf("%d ranges could not be downloaded, they are listed in %s", len(d.failed), FailedFileName(d.fileName))
+	}
+
+	// Retrying the failed ranges doesn't download the ones an interrupted download never reached
+	if missing := d.checkpoint.missing(first, last); len(missing) > 0 {
+		return fmt.Errorf("%d ranges were never downloaded, resume the download to get them: %s", len(missing), formatRanges(missing))
+	}
+
+	return d.writeManifest()
+}
+
+// writeManifest writes the manifest of the complete output file. With ordered output, a file with
+// ranges appended by a resumed download or a retry is first rewritten in prefix order.
+func (d *Downloader) writeManifest() error {
+	log.Info().Msgf("writing manifest %s", ManifestFileName(d.fileName))
+	m, err := NewManifest(d.fileName)
+	if err != nil {
+		return err
+	}
+
+	if d.ordered && !m.sorted() {
+		if m, err = d.sortOutput(m); err != nil {
+			return fmt.Errorf("could not write %s in prefix order: %w", d.fileName, err)
+		}
+	}
+
+	log.Info().Msgf("file %s has %d hashes, SHA256 %s", d.fileName, m.Lines, m.SHA256)
+	return m.Save(ManifestFileName(d.fileName))
+}
+
+// sortOutput rewrites the output file with its ranges in prefix order, moving them in the metadata.
+func (d *Downloader) sortOutput(m *Manifest) (*Manifest, error) {
+	log.Info().Msgf("writing the ranges of %s in prefix order", d.fileName)
+	s, err := newShard(d.fileName, m)
+	if err != nil {
+		return nil, err
+	}
+
+	sorted, err := writeShards(d.fileName, []shard{s})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range sorted.blocks {
+		if meta := &d.meta.ranges[b.prefix]; meta.present {
+			meta.offset, meta.length = uint64(b.offset), uint64(b.length)
+		}
+	}
+
+	return sorted, d.meta.save()
+}
+
+// pendingRanges are the ranges to download: the ones not in the checkpoint, or only the ones in the
+// failed ranges file when retrying failed ranges.
+func (d *Downloader) pendingRanges(first int, last int) ([]int, error) {
+	var pending []int
+	if d.retryFailed {
+		failed, err := readRangeList(FailedFileName(d.fileName))
+		if err != nil {
+			return nil, fmt.Errorf("could not read the failed ranges of the previous download: %w", err)
+		}
+
+		for _, i := range failed {
+			if i >= first && i <= last && !d.checkpoint.isDone(i) {
+				pending = append(pending, i)
+			}
+		}
+		sort.Ints(pending)
+		log.Info().Msgf("retrying %d failed ranges", len(pending))
+		return pending, nil
+	}
+
+	for i := first; i <= last; i++ {
+		if !d.checkpoint.isDone(i) {
+			pending = append(pending, i)
+		}
+	}
+
+	return pending, nil
+}
+
+// downloadRanges downloads the ranges with the thread pool, waiting for all of them to finish.
+func (d *Downloader) downloadRanges(ctx context.Context, downloadTasks *executor.Executor, ranges []int) {
+	if d.ordered {
+		d.reorder = newReorderBuffer(ctx, ranges, reorderWindow, d.writeRange)
+	}
+
+	for _, i := range ranges {
+		if ctx.Err() != nil {
+			break
+		}
+
+		if err := downloadTasks.Publish(d.ProcessRange, ctx, getHashRange(i)); err != nil {
+			log.Panic().Err(err).Msgf("there is a programming error here.")
+		}
+	}
+
+	downloadTasks.Wait()
+}
+
+// takeFailed returns the failed ranges, clearing them for the next retry pass.
+func (d *Downloader) takeFailed() []int {
+	d.fm.Lock()
+	defer d.fm.Unlock()
+
+	failed := make([]int, 0, len(d.failed))
+	for i := range d.failed {
+		failed = append(failed, i)
+	}
+	d.failed = make(map[int]error)
+
+	return failed
+}
+
+// reportFailed logs the ranges that could not be downloaded and writes them to the failed ranges
+// file, so they can be downloaded later with WithRetryFailed.
+func (d *Downloader) reportFailed() error {
+	failed := make([]int, 0, len(d.failed))
+	invalid := 0
+	for i, err := range d.failed {
+		failed = append(failed, i)
+		if errors.Is(err, ErrInvalidRange) {
+			invalid++
+		}
+		log.Error().Err(err).Msgf("range %s could not be downloaded", getHashRange(i))
+	}
+
+	if len(failed) > 0 {
+		log.Error().Msgf("%d ranges could not be downloaded (%d with invalid data), the output is missing their hashes", len(failed), invalid)
+	}
+	if d.out == nil {
+		return nil
+	}
+
+	return writeRangeList(FailedFileName(d.fileName), failed)
+}
+
+// loadCheckpoint loads the checkpoint of a resumed download, and truncates the output file to the
+// size it had when the checkpoint was saved.
+func (d *Downloader) loadCheckpoint() error {
+	if d.out == nil {
+		// Only kept in memory, to know which ranges were downloaded
+		d.checkpoint = newCheckpoint("")
+		d.meta = newMetadata("")
+		if !d.resume {
+			return nil
+		}
+
+		written, err := d.rangeWriter.(RangeChecker).WrittenRanges()
+		if err != nil {
+			return err
+		}
+		for _, i := range written {
+			d.checkpoint.markDone(i)
+		}
+		log.Info().Msgf("resuming download, %d ranges already downloaded", d.checkpoint.count())
+		return nil
+	}
+
+	fileName := CheckpointFileName(d.fileName)
+	if !d.resume {
+		d.checkpoint = newCheckpoint(fileName)
+		d.meta = newMetadata(MetadataFileName(d.fileName))
+		d.saved = time.Now()
+		return d.checkpoint.remove()
+	}
+
+	c, err := loadCheckpoint(fileName)
+	if err != nil {
+		return err
+	}
+
+	// The metadata is only saved with the final checkpoint, the ranges without it are downloaded
+	// again on the next refresh
+	meta, err := loadMetadata(MetadataFileName(d.fileName))
+	if err != nil {
+		return err
+	}
+	meta.truncate(c.size)
+
+	if c.size == 0 && d.retryFailed {
+		return fmt.Errorf("no checkpoint found for file %s, the failed ranges can't be added to it", d.fileName)
+	} else if c.size == 0 {
+		log.Warn().Msgf("no checkpoint found for file %s, starting the download from the beginning", d.fileName)
+	} else {
+		log.Info().Msgf("resuming download, %d ranges already downloaded", c.count())
+	}
+
+	if err = d.out.Truncate(int64(c.size)); err != nil {
+		return err
+	}
+	if _, err = d.out.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	d.checkpoint = c
+	d.meta = meta
+	d.saved = time.Now()
+	return nil
+}
+
+// openPrevious opens the output file of the previous download, to reuse the ranges that didn't
+// change since then.
+func (d *Downloader) openPrevious() error {
+	meta, err := loadMetadata(MetadataFileName(d.previousName))
+	if err != nil {
+		return err
+	}
+
+	if !slices.ContainsFunc(meta.ranges, func(r rangeMeta) bool { return r.present }) {
+		return fmt.Errorf("no metadata found for the previous download %s, it must be in %s",
+			d.previousName, MetadataFileName(d.previousName))
+	}
+
+	if d.previous, err = os.Open(d.previousName); err != nil {
+		return err
+	}
+
+	d.previousMeta = meta
 	return nil
 }
 
+// saveCheckpoint saves the checkpoint and metadata, or removes the checkpoint when all the ranges
+// are downloaded.
+func (d *Downloader) saveCheckpoint(first int, last int) error {
+	d.wm.Lock()
+	defer d.wm.Unlock()
+
+	if d.out == nil {
+		return nil
+	}
+
+	if err := d.meta.save(); err != nil {
+		return err
+	}
+
+	for i := first; i <= last; i++ {
+		if !d.checkpoint.isDone(i) {
+			return d.checkpoint.save()
+		}
+	}
+
+	return d.checkpoint.remove()
+}
+
 func getHashRange(i int) string {
 	buf := make([]byte, 4)
 	binary.BigEndian.PutUint32(buf, uint32(i))
@@ -133,12 +536,12 @@ func getHashRange(i int) string {
 	return strings.ToUpper(hex.EncodeToString(buf)[3:])
 }
 
-func rangeHttpRequest(prefix string) (*retryablehttp.Request, error) {
-	ctx := context.WithValue(context.Background(), "range", prefix)
+func rangeHttpRequest(ctx context.Context, baseURL string, prefix string) (*retryablehttp.Request, error) {
+	ctx = context.WithValue(ctx, "range", prefix)
 	req, err := retryablehttp.NewRequestWithContext(
 		ctx,
 		http.MethodGet,
-		fmt.Sprintf("https://api.pwnedpasswords.com/range/%s", prefix),
+		fmt.Sprintf("%s/range/%s", baseURL, prefix),
 		nil,
 	)
 	if err != nil {
@@ -149,66 +552,221 @@ func rangeHttpRequest(prefix string) (*retryablehttp.Request, error) {
 	return req, nil
 }
 
-func (d *Downloader) ProcessRange(prefix string) {
-	if data, err := d.downloadRange(prefix); err == nil {
-		if err = d.writeRangeToFile(prefix, data); err == nil {
-			d.stat.RangeDownloaded()
+func (d *Downloader) ProcessRange(ctx context.Context, prefix string) {
+	if ctx.Err() != nil {
+		// Interrupted, the range is left for the resumed download
+		return
+	}
+
+	i, err := ParsePrefix(prefix)
+	if err != nil {
+		log.Panic().Err(err).Msgf("there is a programming error here.")
+	}
+
+	if d.ordered && !d.reorder.reserve(ctx, i) {
+		return
+	}
+
+	r, err := d.downloadRange(ctx, i)
+	// The ranges after this one are waiting for it, it can't be retried later
+	for pass, wait := 1, d.retryWait; d.ordered && err != nil && pass <= retryPasses && ctx.Err() == nil; pass, wait = pass+1, wait*2 {
+		log.Warn().Err(err).Msgf("error downloading range %s, retrying in %v (try %d of %d)", prefix, wait, pass, retryPasses)
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+			r, err = d.downloadRange(ctx, i)
+		}
+	}
+
+	if err == nil {
+		if d.ordered {
+			err = d.reorder.put(i, r)
 		} else {
-			log.Fatal().Err(err).Msgf("error during file write for range %s. Stopping process", prefix)
+			err = d.writeRange(i, r)
 		}
-	} else {
-		log.Error().Err(err).Msgf("error downloading range %s", prefix)
+
+		if err != nil {
+			d.writeFailed(prefix, err)
+		}
+	} else if ctx.Err() == nil {
+		log.Warn().Err(err).Msgf("error downloading range %s", prefix)
+
+		d.fm.Lock()
+		d.failed[i] = err
+		d.fm.Unlock()
+
+		if d.ordered {
+			if err := d.reorder.skip(i); err != nil {
+				d.writeFailed(prefix, err)
+			}
+		}
+	}
+}
+
+// writeFailed records the first error writing a range and stops the download, the ranges after it
+// would be missing from the output anyway.
+func (d *Downloader) writeFailed(prefix string, err error) {
+	log.Error().Err(err).Msgf("error during file write for range %s. Stopping process", prefix)
+
+	d.fm.Lock()
+	if d.writeErr == nil {
+		d.writeErr = fmt.Errorf("error writing range %s: %w", prefix, err)
+	}
+	d.fm.Unlock()
+
+	if d.stop != nil {
+		d.stop()
+	}
+}
+
+// downloadedRange is a range ready to be written to the output file.
+type downloadedRange struct {
+	// Lines of the range, with the full hashes
+	data []byte
+	meta rangeMeta
+}
+
+// downloadRange downloads a range. If the previous download has it, the server is asked to only
+// send it if it changed, otherwise it's read from the previous output file.
+func (d *Downloader) downloadRange(ctx context.Context, i int) (*downloadedRange, error) {
+	if d.previousMeta != nil && d.previousMeta.ranges[i].present {
+		return d.requestRange(ctx, i, &d.previousMeta.ranges[i])
 	}
+
+	return d.requestRange(ctx, i, nil)
 }
 
-func (d *Downloader) downloadRange(prefix string) ([]byte, error) {
+func (d *Downloader) requestRange(ctx context.Context, i int, previous *rangeMeta) (*downloadedRange, error) {
+	prefix := getHashRange(i)
 	timer := time.Now()
-	req, err := rangeHttpRequest(prefix)
+	res, err := d.mirrors.do(ctx, d.http, func(baseURL string) (*retryablehttp.Request, error) {
+		req, err := rangeHttpRequest(ctx, baseURL, prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		if previous != nil && previous.etag != "" {
+			req.Header.Set("If-None-Match", previous.etag)
+		} else if previous != nil && previous.lastModified != "" {
+			req.Header.Set("If-Modified-Since", previous.lastModified)
+		}
+		return req, nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := d.http.Do(req)
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Warn().Err(err).Msgf("error closing body for range %s", prefix)
+		}
+	}(res.Body)
+
+	if res.StatusCode == http.StatusNotModified && previous != nil {
+		d.stat.RequestComplete(res, time.Since(timer).Milliseconds())
+
+		data, err := d.readPrevious(previous)
+		if err != nil {
+			log.Warn().Err(err).Msgf("could not reuse range %s from the previous download, downloading it again", prefix)
+			return d.requestRange(ctx, i, nil)
+		}
+
+		meta := *previous
+		meta.changed = false
+		return &downloadedRange{data: data, meta: meta}, nil
+	}
+
+	if res.StatusCode == http.StatusNotModified {
+		return nil, fmt.Errorf("request [%s] responded not modified for a range that was not requested conditionally", res.Request.URL)
+	}
+
+	resBody, err := io.ReadAll(res.Body)
 	if err != nil {
 		return nil, err
 	}
+	d.stat.RequestComplete(res, time.Since(timer).Milliseconds())
 
-	if res.StatusCode < 400 {
-		resBody, err := io.ReadAll(res.Body)
-		if err != nil {
-			return nil, err
-		}
+	// An error page or a truncated body must not end up in the output
+	if err = validateRange(resBody); err != nil {
+		return nil, fmt.Errorf("request [%s]: %w", res.Request.URL, err)
+	}
 
-		defer func(Body io.ReadCloser) {
-			err = Body.Close()
-			if err != nil {
-				log.Warn().Err(err).Msgf("error closing body for range %s", prefix)
-			}
-		}(res.Body)
+	r := &downloadedRange{
+		data: formatRange(prefix, resBody),
+		meta: rangeMeta{
+			etag:         res.Header.Get("ETag"),
+			lastModified: res.Header.Get("Last-Modified"),
+		},
+	}
+	r.meta.hash = sha1.Sum(r.data)
+	r.meta.changed = previous == nil || previous.hash != r.meta.hash
 
-		d.stat.RequestComplete(res, time.Since(timer).Milliseconds())
-		return resBody, nil
+	return r, nil
+}
+
+// readPrevious reads the lines of a range from the previous output file.
+func (d *Downloader) readPrevious(previous *rangeMeta) ([]byte, error) {
+	data := make([]byte, previous.length)
+	if _, err := d.previous.ReadAt(data, int64(previous.offset)); err != nil {
+		return nil, err
+	}
+
+	if sha1.Sum(data) != previous.hash {
+		return nil, fmt.Errorf("previous download output file does not match its metadata")
+	}
+
+	return data, nil
+}
+
+// formatRange adds the prefix to each hash suffix of a range response.
+func formatRange(prefix string, body []byte) []byte {
+	var b bytes.Buffer
+	b.Grow(len(body) + len(body)/7)
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		b.WriteString(prefix)
+		b.Write(scanner.Bytes())
+		b.WriteString("\r\n")
 	}
 
-	return nil, fmt.Errorf("request [%s] failed with status [%d] %s", req.RequestURI, res.StatusCode, res.Status)
+	return b.Bytes()
 }
 
-func (d *Downloader) writeRangeToFile(prefix string, r []byte) error {
+func (d *Downloader) writeRange(i int, r *downloadedRange) error {
 	// Synchronize file writes, we don't want intersected or incomplete lines written to the file.
 	d.wm.Lock()
 	defer d.wm.Unlock()
 
-	scanner := bufio.NewScanner(bytes.NewReader(r))
-	for scanner.Scan() {
-		line := fmt.Sprintf("%s%s\r\n", prefix, scanner.Text())
-		if _, err := d.writer.WriteString(line); err != nil {
+	if d.writer != nil {
+		if _, err := d.writer.Write(r.data); err != nil {
+			return err
+		}
+		if err := d.writer.Flush(); err != nil {
+			return err
+		}
+	}
+	if d.rangeWriter != nil {
+		if err := d.rangeWriter.WriteRange(i, r.data); err != nil {
 			return err
 		}
-		d.stat.HashDownloaded()
 	}
 
-	if err := d.writer.Flush(); err != nil {
-		return err
+	meta := r.meta
+	meta.present = true
+	meta.offset = d.checkpoint.size
+	meta.length = uint64(len(r.data))
+	d.meta.ranges[i] = meta
+	d.checkpoint.size += meta.length
+
+	d.stat.RangeDownloaded(uint64(bytes.Count(r.data, []byte("\n"))), meta.changed)
+
+	// The range is only marked as done once it's written to the file
+	d.checkpoint.markDone(i)
+	if d.out != nil && time.Since(d.saved) > 10*time.Second {
+		d.saved = time.Now()
+		return d.checkpoint.save()
 	}
 
 	return nil
@@ -4,18 +4,37 @@
 package hibp
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/alvinbaena/pwd-checker/progress"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestDownloader(t *testing.T) {
-	file, err := os.Create("../../test/data/download-test-1.txt")
+	file, err := os.Create(filepath.Join(t.TempDir(), "download-test-1.txt"))
 	if err != nil {
 		t.Errorf("Should not fail creating a file: %s", err)
 	}
 
-	downloader := NewDownloader(file, 1)
-	if err = downloader.ProcessRanges(1, true); err != nil {
+	downloader := newTestDownloader(t, file, 1, newRangeServer(t, nil))
+	if err = downloader.ProcessRanges(context.Background(), 1, true); err != nil {
 		t.Errorf("Should not fail download: %s", err)
 	}
 
@@ -32,21 +51,17 @@ func TestDownloader(t *testing.T) {
 		if err := file.Close(); err != nil {
 			t.Fatalf("Should not fail closing file: %s", err)
 		}
-
-		if err := os.Remove(file.Name()); err != nil {
-			t.Fatalf("Should not fail deleting file: %s", err)
-		}
 	})
 }
 
 func TestDownloader_Parallel(t *testing.T) {
-	file, err := os.Create("../../test/data/download-test-2.txt")
+	file, err := os.Create(filepath.Join(t.TempDir(), "download-test-2.txt"))
 	if err != nil {
 		t.Errorf("Should not fail creating a file: %s", err)
 	}
 
-	downloader := NewDownloader(file, 0)
-	if err = downloader.ProcessRanges(1, true); err != nil {
+	downloader := newTestDownloader(t, file, 0, newRangeServer(t, nil))
+	if err = downloader.ProcessRanges(context.Background(), 1, true); err != nil {
 		t.Errorf("Should not fail download: %s", err)
 	}
 
@@ -63,9 +78,804 @@ func TestDownloader_Parallel(t *testing.T) {
 		if err := file.Close(); err != nil {
 			t.Fatalf("Should not fail closing file: %s", err)
 		}
+	})
+}
+
+// newRangeServer is a stand-in for the Pwned Passwords range API that answers with 3 made up hashes
+// per range. The status function returns the status code of the response for each prefix.
+func newRangeServer(t *testing.T, status func(prefix string) int) *httptest.Server {
+	server := httptest.NewServer(rangeHandler(status))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func rangeHandler(status func(prefix string) int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		prefix := path.Base(r.URL.Path)
+		if _, err := ParsePrefix(prefix); err != nil || path.Dir(r.URL.Path) != "/range" {
+			http.NotFound(w, r)
+			return
+		}
+
+		if status != nil {
+			if code := status(prefix); code != http.StatusOK {
+				w.WriteHeader(code)
+				return
+			}
+		}
+
+		for i := 0; i < 3; i++ {
+			_, _ = fmt.Fprintf(w, "%035X:%d\r\n", i, i+1)
+		}
+	}
+}
+
+func newTestDownloader(t *testing.T, file *os.File, parallelism int, server *httptest.Server, opts ...Option) *Downloader {
+	d, err := NewDownloader(file, parallelism, append([]Option{WithBaseURL(server.URL)}, opts...)...)
+	if err != nil {
+		t.Fatalf("Should not fail creating the downloader: %s", err)
+	}
+
+	// The failed ranges are retried by the downloader
+	d.http.RetryMax = 0
+	d.retryWait = time.Millisecond
+	return d
+}
+
+func sortedLines(t *testing.T, fileName string) []string {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("Should not fail reading file: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\r\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func TestDownloader_Resume(t *testing.T) {
+	ranges := 64
+	fileName := filepath.Join(t.TempDir(), "pwned-sha1.txt")
+	file, err := os.Create(fileName)
+	if err != nil {
+		t.Fatalf("Should not fail creating a file: %s", err)
+	}
+
+	// Interrupt the download half way
+	ctx, cancel := context.WithCancel(context.Background())
+	d := newTestDownloader(t, file, 1, newRangeServer(t, func(prefix string) int {
+		if prefix == getHashRange(ranges/2) {
+			cancel()
+		}
+		return http.StatusOK
+	}))
+	if err = d.ProcessRanges(ctx, ranges, true); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Download should be interrupted, got: %v", err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatalf("Should not fail closing file: %s", err)
+	}
+
+	if _, err = os.Stat(CheckpointFileName(fileName)); err != nil {
+		t.Fatalf("Checkpoint should be saved: %s", err)
+	}
+
+	// A range written after the checkpoint was saved, it must not be duplicated
+	file, err = os.OpenFile(fileName, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Should not fail opening file: %s", err)
+	}
+	if _, err = file.WriteString("FFFFF00000000000000000000000000000000000:1\r\n"); err != nil {
+		t.Fatalf("Should not fail writing file: %s", err)
+	}
+
+	d = newTestDownloader(t, file, 1, newRangeServer(t, nil), WithResume(true))
+	if err = d.ProcessRanges(context.Background(), ranges, true); err != nil {
+		t.Fatalf("Should not fail resuming the download: %s", err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatalf("Should not fail closing file: %s", err)
+	}
+
+	if _, err = os.Stat(CheckpointFileName(fileName)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Checkpoint should be removed after the download completes: %v", err)
+	}
+
+	checkDownloadedRanges(t, fileName, ranges)
+}
+
+func checkDownloadedRanges(t *testing.T, fileName string, ranges int) {
+	lines := sortedLines(t, fileName)
+	if len(lines) != ranges*3 {
+		t.Fatalf("File has %d lines, want: %d", len(lines), ranges*3)
+	}
+	for i, line := range lines {
+		want := fmt.Sprintf("%s%035X:%d", getHashRange(i/3), i%3, i%3+1)
+		if line != want {
+			t.Fatalf("Line %d: %s, want: %s", i, line, want)
+		}
+	}
+}
+
+// checkOrderedRanges checks that the file has the lines of the ranges in prefix order, except the
+// skipped ones.
+func checkOrderedRanges(t *testing.T, fileName string, ranges int, skipped ...int) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("Should not fail reading file: %s", err)
+	}
+
+	var want strings.Builder
+	for i := 0; i < ranges; i++ {
+		if slices.Contains(skipped, i) {
+			continue
+		}
+		for j := 0; j < 3; j++ {
+			want.WriteString(fmt.Sprintf("%s%035X:%d\r\n", getHashRange(i), j, j+1))
+		}
+	}
+
+	if string(data) != want.String() {
+		t.Errorf("File %s is not in prefix order", fileName)
+	}
+}
+
+func TestDownloader_Failed(t *testing.T) {
+	ranges := 32
+	fileName := filepath.Join(t.TempDir(), "pwned-sha1.txt")
+	file, err := os.Create(fileName)
+	if err != nil {
+		t.Fatalf("Should not fail creating a file: %s", err)
+	}
+
+	// 00005 and 00010 always fail, 00007 only fails the first time
+	var attempts sync.Map
+	d := newTestDownloader(t, file, 1, newRangeServer(t, func(prefix string) int {
+		n, _ := attempts.LoadOrStore(prefix, new(atomic.Int32))
+		if n := n.(*atomic.Int32).Add(1); prefix == "00005" || prefix == "00010" || (prefix == "00007" && n == 1) {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	}))
+	if err = d.ProcessRanges(context.Background(), ranges, true); err == nil {
+		t.Fatalf("Download should fail with missing ranges")
+	}
+
+	n, _ := attempts.Load("00005")
+	if n := n.(*atomic.Int32).Load(); n != retryPasses+1 {
+		t.Errorf("Range 00005 was requested %d times, want: %d", n, retryPasses+1)
+	}
+
+	failed, err := os.ReadFile(FailedFileName(fileName))
+	if err != nil {
+		t.Fatalf("Should not fail reading the failed ranges file: %s", err)
+	}
+	if string(failed) != "00005\n00010\n" {
+		t.Errorf("Failed ranges: %q, want: %q", failed, "00005\n00010\n")
+	}
+
+	// Only the failed ranges are downloaded again
+	var requested atomic.Int32
+	d = newTestDownloader(t, file, 1, newRangeServer(t, func(prefix string) int {
+		requested.Add(1)
+		return http.StatusOK
+	}), WithRetryFailed(true))
+	if err = d.ProcessRanges(context.Background(), ranges, true); err != nil {
+		t.Fatalf("Should not fail retrying the failed ranges: %s", err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatalf("Should not fail closing file: %s", err)
+	}
+
+	if requested.Load() != 2 {
+		t.Errorf("Retry requested %d ranges, want: 2", requested.Load())
+	}
+	for _, name := range []string{FailedFileName(fileName), CheckpointFileName(fileName)} {
+		if _, err = os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s should be removed after the download completes: %v", name, err)
+		}
+	}
+
+	checkDownloadedRanges(t, fileName, ranges)
+}
+
+func TestDownloader_RetryFailedIncomplete(t *testing.T) {
+	ranges := 32
+	fileName := filepath.Join(t.TempDir(), "pwned-sha1.txt")
+	file, err := os.Create(fileName)
+	if err != nil {
+		t.Fatalf("Should not fail creating a file: %s", err)
+	}
+
+	// 00005 fails, and the download is interrupted half way
+	ctx, cancel := context.WithCancel(context.Background())
+	d := newTestDownloader(t, file, 1, newRangeServer(t, func(prefix string) int {
+		if prefix == getHashRange(ranges/2) {
+			cancel()
+		}
+		if prefix == "00005" {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	}))
+	if err = d.ProcessRanges(ctx, ranges, true); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Download should be interrupted, got: %v", err)
+	}
+
+	// Retrying the failed range leaves the ranges after the interruption missing
+	d = newTestDownloader(t, file, 1, newRangeServer(t, nil), WithRetryFailed(true))
+	err = d.ProcessRanges(context.Background(), ranges, true)
+	if err == nil || !strings.Contains(err.Error(), getHashRange(ranges/2+1)) {
+		t.Fatalf("Retry should fail naming the missing ranges, got: %v", err)
+	}
+	if _, err = os.Stat(ManifestFileName(fileName)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Manifest should not be written for an incomplete download: %v", err)
+	}
+	if _, err = os.Stat(CheckpointFileName(fileName)); err != nil {
+		t.Errorf("Checkpoint should be kept for an incomplete download: %s", err)
+	}
+
+	d = newTestDownloader(t, file, 1, newRangeServer(t, nil), WithResume(true))
+	if err = d.ProcessRanges(context.Background(), ranges, true); err != nil {
+		t.Fatalf("Should not fail resuming the download: %s", err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatalf("Should not fail closing file: %s", err)
+	}
+	if _, err = os.Stat(ManifestFileName(fileName)); err != nil {
+		t.Errorf("Manifest should be written once the download completes: %s", err)
+	}
+
+	checkDownloadedRanges(t, fileName, ranges)
+}
+
+func TestDownloader_InvalidRange(t *testing.T) {
+	ranges := 8
+	fileName := filepath.Join(t.TempDir(), "pwned-sha1.txt")
+	file, err := os.Create(fileName)
+	if err != nil {
+		t.Fatalf("Should not fail creating a file: %s", err)
+	}
+
+	// 00002 is an error page the first time, 00004 is always truncated
+	var attempts sync.Map
+	valid := rangeHandler(nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := path.Base(r.URL.Path)
+		n, _ := attempts.LoadOrStore(prefix, new(atomic.Int32))
+		switch n := n.(*atomic.Int32).Add(1); {
+		case prefix == "00002" && n == 1:
+			_, _ = fmt.Fprint(w, "<html><body>Blocked by proxy</body></html>")
+		case prefix == "00004":
+			_, _ = fmt.Fprintf(w, "%035X:1\r\n%020X", 0, 1)
+		default:
+			valid(w, r)
+		}
+	}))
+	defer server.Close()
+
+	d := newTestDownloader(t, file, 1, server)
+	if err = d.ProcessRanges(context.Background(), ranges, true); err == nil {
+		t.Fatalf("Download should fail with an invalid range")
+	}
+	if !errors.Is(d.failed[4], ErrInvalidRange) {
+		t.Errorf("Range 00004 should fail with ErrInvalidRange, got: %v", d.failed[4])
+	}
+
+	failed, err := os.ReadFile(FailedFileName(fileName))
+	if err != nil {
+		t.Fatalf("Should not fail reading the failed ranges file: %s", err)
+	}
+	if string(failed) != "00004\n" {
+		t.Errorf("Failed ranges: %q, want: %q", failed, "00004\n")
+	}
+	if _, err = os.Stat(ManifestFileName(fileName)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Manifest should not be written for an incomplete download: %v", err)
+	}
+
+	d = newTestDownloader(t, file, 1, newRangeServer(t, nil), WithRetryFailed(true))
+	if err = d.ProcessRanges(context.Background(), ranges, true); err != nil {
+		t.Fatalf("Should not fail retrying the failed ranges: %s", err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatalf("Should not fail closing file: %s", err)
+	}
+
+	checkDownloadedRanges(t, fileName, ranges)
+	checkManifest(t, fileName, ranges)
+}
+
+func checkManifest(t *testing.T, fileName string, ranges int) {
+	data, err := os.ReadFile(ManifestFileName(fileName))
+	if err != nil {
+		t.Fatalf("Should not fail reading the manifest: %s", err)
+	}
+
+	var m Manifest
+	if err = json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("Should not fail parsing the manifest: %s", err)
+	}
+
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("Should not fail reading file: %s", err)
+	}
+	if sum := sha256.Sum256(content); m.SHA256 != hex.EncodeToString(sum[:]) || m.Size != int64(len(content)) {
+		t.Errorf("Manifest has SHA256 %s and size %d, want: %x and %d", m.SHA256, m.Size, sum, len(content))
+	}
+
+	if m.File != filepath.Base(fileName) || m.Lines != uint64(ranges*3) || len(m.Ranges) != ranges {
+		t.Errorf("Manifest of %s has %d lines in %d ranges, want: %d in %d", m.File, m.Lines, len(m.Ranges), ranges*3, ranges)
+	}
+	for i := 0; i < ranges; i++ {
+		if m.Ranges[getHashRange(i)] != 3 {
+			t.Errorf("Range %s has %d lines in the manifest, want: 3", getHashRange(i), m.Ranges[getHashRange(i)])
+		}
+	}
+}
+
+func TestDownloader_Ordered(t *testing.T) {
+	ranges := 256
+	fileName := filepath.Join(t.TempDir(), "pwned-sha1.txt")
+	file, err := os.Create(fileName)
+	if err != nil {
+		t.Fatalf("Should not fail creating a file: %s", err)
+	}
+
+	// Ranges finish out of order, and 00042 never downloads
+	d := newTestDownloader(t, file, 16, newRangeServer(t, func(prefix string) int {
+		time.Sleep(time.Duration(rand.Intn(2000)) * time.Microsecond)
+		if prefix == "00042" {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	}), WithOrderedOutput(true))
+
+	if err = d.ProcessRanges(context.Background(), ranges, true); err == nil {
+		t.Fatalf("Download should fail with missing ranges")
+	}
+	if err = file.Close(); err != nil {
+		t.Fatalf("Should not fail closing file: %s", err)
+	}
+
+	checkOrderedRanges(t, fileName, ranges, 0x42)
+
+	// The retried range is put in its place
+	file, err = os.OpenFile(fileName, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("Should not fail opening file: %s", err)
+	}
+	d = newTestDownloader(t, file, 16, newRangeServer(t, nil), WithOrderedOutput(true), WithRetryFailed(true))
+	if err = d.ProcessRanges(context.Background(), ranges, true); err != nil {
+		t.Fatalf("Should not fail retrying the failed ranges: %s", err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatalf("Should not fail closing file: %s", err)
+	}
+
+	checkOrderedRanges(t, fileName, ranges)
+	checkManifest(t, fileName, ranges)
+	checkOrderedMetadata(t, fileName, ranges)
+}
+
+func TestDownloader_OrderedResume(t *testing.T) {
+	ranges := 64
+	fileName := filepath.Join(t.TempDir(), "pwned-sha1.txt")
+	file, err := os.Create(fileName)
+	if err != nil {
+		t.Fatalf("Should not fail creating a file: %s", err)
+	}
+
+	// Only the first half is downloaded, without 00005
+	d := newTestDownloader(t, file, 4, newRangeServer(t, func(prefix string) int {
+		if prefix == "00005" {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	}), WithOrderedOutput(true))
+	if err = d.ProcessPrefixes(context.Background(), 0, ranges/2-1, true); err == nil {
+		t.Fatalf("Download should fail with missing ranges")
+	}
 
-		if err := os.Remove(file.Name()); err != nil {
-			t.Fatalf("Should not fail deleting file: %s", err)
+	d = newTestDownloader(t, file, 4, newRangeServer(t, nil), WithOrderedOutput(true), WithResume(true))
+	if err = d.ProcessRanges(context.Background(), ranges, true); err != nil {
+		t.Fatalf("Should not fail resuming the download: %s", err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatalf("Should not fail closing file: %s", err)
+	}
+
+	checkOrderedRanges(t, fileName, ranges)
+	checkManifest(t, fileName, ranges)
+	checkOrderedMetadata(t, fileName, ranges)
+}
+
+// checkOrderedMetadata checks that the metadata has where each range is in a file with the ranges
+// in prefix order.
+func checkOrderedMetadata(t *testing.T, fileName string, ranges int) {
+	meta, err := loadMetadata(MetadataFileName(fileName))
+	if err != nil {
+		t.Fatalf("Should not fail loading metadata: %s", err)
+	}
+
+	size := uint64(len(formatRange("00000", []byte("00000000000000000000000000000000000:1\r\n"))) * 3)
+	for i := 0; i < ranges; i++ {
+		if r := meta.ranges[i]; !r.present || r.offset != uint64(i)*size || r.length != size {
+			t.Fatalf("Range %s is at %d (%d bytes) in the metadata, want: %d (%d bytes)", getHashRange(i), r.offset, r.length, uint64(i)*size, size)
 		}
+	}
+}
+
+// etagHandler answers range requests with an ETag for each version of a range, and with 304 Not
+// Modified when the client already has the current version.
+type etagHandler struct {
+	mu sync.Mutex
+	// Version of each range, 0 if missing
+	versions    map[string]int
+	notModified int
+}
+
+func (e *etagHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	prefix := path.Base(r.URL.Path)
+	version := e.versions[prefix]
+	etag := fmt.Sprintf("W/\"%s-%d\"", prefix, version)
+
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		e.notModified++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	_, _ = fmt.Fprintf(w, "%035X:%d\r\n", version, version+1)
+}
+
+func TestDownloader_Refresh(t *testing.T) {
+	ranges := 64
+	dir := t.TempDir()
+	handler := &etagHandler{versions: map[string]int{}}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	download := func(fileName string, opts ...Option) {
+		file, err := os.Create(fileName)
+		if err != nil {
+			t.Fatalf("Should not fail creating a file: %s", err)
+		}
+		defer file.Close()
+
+		d := newTestDownloader(t, file, 4, server, opts...)
+		if err = d.ProcessRanges(context.Background(), ranges, true); err != nil {
+			t.Fatalf("Should not fail download: %s", err)
+		}
+	}
+
+	first := filepath.Join(dir, "pwned-1.txt")
+	download(first)
+
+	handler.versions["00003"] = 1
+	handler.versions["00021"] = 2
+	second := filepath.Join(dir, "pwned-2.txt")
+	download(second, WithPrevious(first))
+
+	if handler.notModified != ranges-2 {
+		t.Errorf("%d ranges were not modified, want: %d", handler.notModified, ranges-2)
+	}
+
+	changed, err := os.ReadFile(ChangedFileName(second))
+	if err != nil {
+		t.Fatalf("Should not fail reading the changed ranges file: %s", err)
+	}
+	if string(changed) != "00003\n00021\n" {
+		t.Errorf("Changed ranges: %q, want: %q", changed, "00003\n00021\n")
+	}
+
+	lines := sortedLines(t, second)
+	if len(lines) != ranges {
+		t.Fatalf("File has %d lines, want: %d", len(lines), ranges)
+	}
+	for i, line := range lines {
+		version := handler.versions[getHashRange(i)]
+		if want := fmt.Sprintf("%s%035X:%d", getHashRange(i), version, version+1); line != want {
+			t.Errorf("Line %d: %s, want: %s", i, line, want)
+		}
+	}
+
+	// The refreshed download can be refreshed again
+	handler.notModified = 0
+	third := filepath.Join(dir, "pwned-3.txt")
+	download(third, WithPrevious(second))
+	if handler.notModified != ranges {
+		t.Errorf("%d ranges were not modified, want: %d", handler.notModified, ranges)
+	}
+	if _, err = os.Stat(ChangedFileName(third)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("No ranges changed, there should be no changed ranges file: %v", err)
+	}
+}
+
+func TestDownloader_Mirrors(t *testing.T) {
+	ranges := 16
+	fileName := filepath.Join(t.TempDir(), "pwned-sha1.txt")
+	file, err := os.Create(fileName)
+	if err != nil {
+		t.Fatalf("Should not fail creating a file: %s", err)
+	}
+	defer file.Close()
+
+	var primaryRequests atomic.Int32
+	primary := newRangeServer(t, func(prefix string) int {
+		primaryRequests.Add(1)
+		return http.StatusServiceUnavailable
 	})
+	mirror := newRangeServer(t, nil)
+
+	d := newTestDownloader(t, file, 1, primary, WithMirrors(mirror.URL+"/"))
+	if err = d.ProcessRanges(context.Background(), ranges, true); err != nil {
+		t.Fatalf("Should not fail download: %s", err)
+	}
+
+	// Once the primary fails, the mirror is used for the other ranges
+	if primaryRequests.Load() != 1 {
+		t.Errorf("Primary got %d requests, want: 1", primaryRequests.Load())
+	}
+	checkDownloadedRanges(t, fileName, ranges)
+}
+
+func TestDownloader_CABundle(t *testing.T) {
+	ranges := 4
+	dir := t.TempDir()
+	server := httptest.NewTLSServer(rangeHandler(nil))
+	defer server.Close()
+
+	caBundle := filepath.Join(dir, "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caBundle, certificate, 0644); err != nil {
+		t.Fatalf("Should not fail writing file: %s", err)
+	}
+
+	cases := []struct {
+		name string
+		opts []Option
+		fail bool
+	}{
+		{"untrusted", nil, true},
+		{"trusted", []Option{WithCABundle(caBundle)}, false},
+	}
+
+	for _, c := range cases {
+		fileName := filepath.Join(dir, c.name+".txt")
+		file, err := os.Create(fileName)
+		if err != nil {
+			t.Fatalf("Should not fail creating a file: %s", err)
+		}
+
+		d := newTestDownloader(t, file, 1, server, c.opts...)
+		err = d.ProcessRanges(context.Background(), ranges, true)
+		_ = file.Close()
+
+		if c.fail && err == nil {
+			t.Errorf("%s: download should fail", c.name)
+		} else if !c.fail && err != nil {
+			t.Errorf("%s: should not fail download: %s", c.name, err)
+		}
+	}
+
+	if _, err := NewDownloader(nil, 1, WithCABundle(filepath.Join(dir, "trusted.txt"))); err == nil {
+		t.Errorf("Should fail with a CA bundle without certificates")
+	}
+}
+
+func TestDownloader_Proxy(t *testing.T) {
+	ranges := 4
+	fileName := filepath.Join(t.TempDir(), "pwned-sha1.txt")
+	file, err := os.Create(fileName)
+	if err != nil {
+		t.Fatalf("Should not fail creating a file: %s", err)
+	}
+	defer file.Close()
+
+	// The proxy answers the requests itself, the base URL host doesn't exist
+	var proxied atomic.Int32
+	proxy := newRangeServer(t, func(prefix string) int {
+		proxied.Add(1)
+		return http.StatusOK
+	})
+
+	d, err := NewDownloader(file, 1, WithBaseURL("http://pwned.invalid"), WithProxy(proxy.URL))
+	if err != nil {
+		t.Fatalf("Should not fail creating the downloader: %s", err)
+	}
+	d.http.RetryMax = 0
+
+	if err = d.ProcessRanges(context.Background(), ranges, true); err != nil {
+		t.Fatalf("Should not fail download: %s", err)
+	}
+	if proxied.Load() != int32(ranges) {
+		t.Errorf("Proxy got %d requests, want: %d", proxied.Load(), ranges)
+	}
+	checkDownloadedRanges(t, fileName, ranges)
+}
+
+func TestDownloader_RateLimit(t *testing.T) {
+	ranges := 10
+	fileName := filepath.Join(t.TempDir(), "pwned-sha1.txt")
+	file, err := os.Create(fileName)
+	if err != nil {
+		t.Fatalf("Should not fail creating a file: %s", err)
+	}
+	defer file.Close()
+
+	d := newTestDownloader(t, file, 4, newRangeServer(t, nil), WithRateLimit(20))
+	start := time.Now()
+	if err = d.ProcessRanges(context.Background(), ranges, true); err != nil {
+		t.Fatalf("Should not fail download: %s", err)
+	}
+
+	// 50ms between requests
+	if elapsed := time.Since(start); elapsed < time.Duration(ranges-1)*40*time.Millisecond {
+		t.Errorf("Download took %v, requests were not rate limited", elapsed)
+	}
+}
+
+func TestDownloader_Progress(t *testing.T) {
+	ranges := 16
+	file, err := os.Create(filepath.Join(t.TempDir(), "pwned-sha1.txt"))
+	if err != nil {
+		t.Fatalf("Should not fail creating a file: %s", err)
+	}
+	defer file.Close()
+
+	var mu sync.Mutex
+	var events []progress.Event
+	d := newTestDownloader(t, file, 2, newRangeServer(t, nil), WithProgress(progress.ReporterFunc(func(e progress.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	})))
+	if err = d.ProcessRanges(context.Background(), ranges, true); err != nil {
+		t.Fatalf("Should not fail download: %s", err)
+	}
+
+	kinds := make([]progress.Kind, 0, len(events))
+	for _, e := range events {
+		kinds = append(kinds, e.Kind)
+	}
+	want := []progress.Kind{progress.StageStarted, progress.StageFinished, progress.Finished}
+	if fmt.Sprint(kinds) != fmt.Sprint(want) {
+		t.Fatalf("Events: %v, want: %v", kinds, want)
+	}
+
+	if e := events[1]; e.Process != "download" || e.Done != uint64(ranges) || e.Total != uint64(ranges) || e.Counters["hashes"] != uint64(ranges*3) {
+		t.Errorf("Download finished with %d of %d ranges and %d hashes, want: %d, %d and %d",
+			e.Done, e.Total, e.Counters["hashes"], ranges, ranges, ranges*3)
+	}
+}
+
+// rangeCollector keeps the ranges given to it by a downloader
+type rangeCollector struct {
+	mu     sync.Mutex
+	ranges map[int]string
+}
+
+func (c *rangeCollector) WriteRange(prefix int, lines []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ranges == nil {
+		c.ranges = make(map[int]string)
+	}
+	if _, found := c.ranges[prefix]; found {
+		return fmt.Errorf("range %d written twice", prefix)
+	}
+	c.ranges[prefix] = string(lines)
+	return nil
+}
+
+func TestDownloader_RangeWriter(t *testing.T) {
+	ranges := 32
+	for _, ordered := range []bool{false, true} {
+		collector := &rangeCollector{}
+		d := newTestDownloader(t, nil, 4, newRangeServer(t, nil), WithRangeWriter(collector), WithOrderedOutput(ordered))
+		if err := d.ProcessRanges(context.Background(), ranges, true); err != nil {
+			t.Fatalf("Should not fail download: %s", err)
+		}
+
+		if len(collector.ranges) != ranges {
+			t.Fatalf("%d ranges written, want: %d", len(collector.ranges), ranges)
+		}
+		for i := 0; i < ranges; i++ {
+			want := ""
+			for j := 0; j < 3; j++ {
+				want += fmt.Sprintf("%s%035X:%d\r\n", getHashRange(i), j, j+1)
+			}
+			if collector.ranges[i] != want {
+				t.Errorf("Range %d: %q, want: %q", i, collector.ranges[i], want)
+			}
+		}
+	}
+}
+
+func TestDownloader_RangeWriterFailed(t *testing.T) {
+	collector := &rangeCollector{}
+	d := newTestDownloader(t, nil, 1, newRangeServer(t, func(prefix string) int {
+		if prefix == "00003" {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	}), WithRangeWriter(collector))
+	if err := d.ProcessRanges(context.Background(), 8, true); err == nil {
+		t.Fatalf("Download should fail with missing ranges")
+	}
+
+	if _, found := collector.ranges[3]; found || len(collector.ranges) != 7 {
+		t.Errorf("%d ranges written, want all but the failed one", len(collector.ranges))
+	}
+}
+
+// failingWriter fails writing one of the ranges.
+type failingWriter struct {
+	rangeCollector
+	prefix int
+}
+
+var errWriteFailed = errors.New("no space left on device")
+
+func (w *failingWriter) WriteRange(prefix int, lines []byte) error {
+	if prefix == w.prefix {
+		return errWriteFailed
+	}
+	return w.rangeCollector.WriteRange(prefix, lines)
+}
+
+func TestDownloader_RangeWriterError(t *testing.T) {
+	ranges := 256
+	for _, ordered := range []bool{false, true} {
+		out := &failingWriter{prefix: 5}
+		d := newTestDownloader(t, nil, 1, newRangeServer(t, nil), WithRangeWriter(out), WithOrderedOutput(ordered))
+
+		err := d.ProcessRanges(context.Background(), ranges, true)
+		if !errors.Is(err, errWriteFailed) {
+			t.Fatalf("Ordered %v: download should fail with the write error, got: %v", ordered, err)
+		}
+		if !strings.Contains(err.Error(), getHashRange(5)) {
+			t.Errorf("Ordered %v: error %q should name the range", ordered, err)
+		}
+
+		// The download stops instead of going through the other ranges
+		if _, found := out.ranges[5]; found || len(out.ranges) >= ranges-1 {
+			t.Errorf("Ordered %v: %d ranges written, the download should stop after the error", ordered, len(out.ranges))
+		}
+	}
+}
+
+func TestNewDownloader_NoOutput(t *testing.T) {
+	cases := [][]Option{
+		nil,
+		{WithRangeWriter(&rangeCollector{}), WithResume(true)},
+		{WithRangeWriter(&rangeCollector{}), WithRetryFailed(true)},
+	}
+
+	for i, opts := range cases {
+		if _, err := NewDownloader(nil, 1, opts...); err == nil {
+			t.Errorf("Case %d: should fail without an output file", i)
+		}
+	}
+}
+
+func TestNewDownloader_InvalidURL(t *testing.T) {
+	cases := []Option{
+		WithBaseURL("api.pwnedpasswords.com"),
+		WithBaseURL("ftp://api.pwnedpasswords.com"),
+		WithMirrors("https://"),
+		WithProxy("not a url"),
+	}
+
+	for i, opt := range cases {
+		if _, err := NewDownloader(nil, 1, opt, WithRangeWriter(&rangeCollector{})); err == nil {
+			t.Errorf("Case %d: should fail with an invalid URL", i)
+		}
+	}
 }
@@ -0,0 +1,160 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package hibp
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ManifestFileName is the name of the JSON manifest of a download output file, kept next to it.
+func ManifestFileName(outFileName string) string {
+	return outFileName + ".manifest.json"
+}
+
+// Manifest describes a complete download output file, so it can be checked after being copied.
+type Manifest struct {
+	// Base name of the output file
+	File string `json:"file"`
+	Size int64  `json:"size"`
+	// SHA256 of the whole file, hex encoded
+	SHA256 string `json:"sha256"`
+	Lines  uint64 `json:"lines"`
+	// Lines of each range, by prefix
+	Ranges map[string]uint64 `json:"ranges"`
+	// Where the lines of each range are in the file, in file order. Only known for the manifests
+	// created from the file.
+	blocks []rangeBlock
+}
+
+// rangeBlock is where the lines of a range are in a Pwned Passwords file, with their line breaks.
+type rangeBlock struct {
+	prefix int
+	offset int64
+	length int64
+}
+
+// NewManifest reads a Pwned Passwords file (SHA1) to create its manifest.
+func NewManifest(fileName string) (*Manifest, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	m := &Manifest{
+		File:   filepath.Base(fileName),
+		Ranges: make(map[string]uint64),
+	}
+
+	// Offsets of the current line and the next one
+	var start, next int64
+	h := sha256.New()
+	scanner := bufio.NewScanner(io.TeeReader(file, h))
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil {
+			start = next
+		}
+		next += int64(advance)
+		return advance, token, err
+	})
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if len(line) < 5 {
+			return nil, fmt.Errorf("invalid line %d in Pwned Passwords file %s: %q", m.Lines+1, fileName, line)
+		}
+		i, err := ParsePrefix(line[:5])
+		if err != nil {
+			return nil, fmt.Errorf("invalid line %d in Pwned Passwords file %s: %q", m.Lines+1, fileName, line)
+		}
+
+		if n := len(m.blocks); n > 0 && m.blocks[n-1].prefix == i {
+			m.blocks[n-1].length = next - m.blocks[n-1].offset
+		} else {
+			m.blocks = append(m.blocks, rangeBlock{prefix: i, offset: start, length: next - start})
+		}
+
+		m.Ranges[strings.ToUpper(line[:5])]++
+		m.Lines++
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// The scanner stops at the end of the file, all of it went through the hash
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	m.Size = info.Size()
+	m.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	return m, nil
+}
+
+// sorted checks if the ranges of the file are in prefix order.
+func (m *Manifest) sorted() bool {
+	for n := 1; n < len(m.blocks); n++ {
+		if m.blocks[n].prefix <= m.blocks[n-1].prefix {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sortedBlocks are the blocks of the ranges of the file in prefix order. The lines of each range
+// must be together in the file.
+func (m *Manifest) sortedBlocks() ([]rangeBlock, error) {
+	blocks := slices.Clone(m.blocks)
+	slices.SortStableFunc(blocks, func(a, b rangeBlock) int {
+		return a.prefix - b.prefix
+	})
+
+	for n := 1; n < len(blocks); n++ {
+		if blocks[n].prefix == blocks[n-1].prefix {
+			return nil, fmt.Errorf("the lines of range %s are not together in %s", getHashRange(blocks[n].prefix), m.File)
+		}
+	}
+
+	return blocks, nil
+}
+
+// LoadManifest reads a manifest file written by Save.
+func LoadManifest(fileName string) (*Manifest, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid manifest file %s: %w", fileName, err)
+	}
+
+	return m, nil
+}
+
+// Save writes the manifest as indented JSON.
+func (m *Manifest) Save(fileName string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(fileName, append(data, '\n'), 0644)
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package hibp
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const metadataMagic = "[HIBP:m]"
+
+// MetadataFileName is the name of the file with the metadata of each range of a download output
+// file, kept next to it.
+func MetadataFileName(outFileName string) string {
+	return outFileName + ".meta"
+}
+
+// rangeMeta is where a range was written in the output file, and what the server said about it.
+// A later download uses it to ask the server only for the ranges that changed, reusing the
+// unchanged ones from this output file.
+type rangeMeta struct {
+	present bool
+	offset  uint64
+	length  uint64
+	// SHA1 of the lines of the range, as written to the output file
+	hash         [sha1.Size]byte
+	etag         string
+	lastModified string
+	// If the range changed since the previous download
+	changed bool
+}
+
+type metadata struct {
+	fileName string
+	ranges   []rangeMeta
+}
+
+func newMetadata(fileName string) *metadata {
+	return &metadata{fileName: fileName, ranges: make([]rangeMeta, Ranges)}
+}
+
+// loadMetadata reads the metadata file. If there is none, an empty metadata is returned.
+func loadMetadata(fileName string) (*metadata, error) {
+	m := newMetadata(fileName)
+
+	file, err := os.Open(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	magic := make([]byte, len(metadataMagic))
+	if _, err = io.ReadFull(r, magic); err != nil || string(magic) != metadataMagic {
+		return nil, fmt.Errorf("%s is not a download metadata file", fileName)
+	}
+
+	for i := range m.ranges {
+		if err = m.ranges[i].read(r); err != nil {
+			return nil, fmt.Errorf("invalid download metadata file %s: %w", fileName, err)
+		}
+	}
+
+	return m, nil
+}
+
+// save writes the metadata to a temporary file and renames it, so an interrupted save doesn't
+// leave a corrupt file behind.
+func (m *metadata) save() error {
+	tmp := m.fileName + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	_, err = w.WriteString(metadataMagic)
+	for i := 0; i < len(m.ranges) && err == nil; i++ {
+		err = m.ranges[i].write(w)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, m.fileName)
+}
+
+// truncate forgets the ranges written after the first size bytes of the output file.
+func (m *metadata) truncate(size uint64) {
+	for i, meta := range m.ranges {
+		if meta.present && meta.offset+meta.length > size {
+			m.ranges[i] = rangeMeta{}
+		}
+	}
+}
+
+// changedRanges are the ranges that changed since the previous download.
+func (m *metadata) changedRanges() []int {
+	var changed []int
+	for i, meta := range m.ranges {
+		if meta.present && meta.changed {
+			changed = append(changed, i)
+		}
+	}
+
+	return changed
+}
+
+// Each range is written as a present flag and, if present: offset, length, hash, changed flag, and
+// the length prefixed etag and last modified.
+func (r *rangeMeta) write(w *bufio.Writer) error {
+	if !r.present {
+		return w.WriteByte(0)
+	}
+
+	buf := make([]byte, 0, 64+len(r.etag)+len(r.lastModified))
+	buf = append(buf, 1)
+	buf = binary.BigEndian.AppendUint64(buf, r.offset)
+	buf = binary.BigEndian.AppendUint64(buf, r.length)
+	buf = append(buf, r.hash[:]...)
+	if r.changed {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	for _, s := range []string{r.etag, r.lastModified} {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+		buf = append(buf, s...)
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+func (r *rangeMeta) read(rd *bufio.Reader) error {
+	present, err := rd.ReadByte()
+	if err != nil || present == 0 {
+		return err
+	}
+
+	fixed := make([]byte, 8+8+sha1.Size+1)
+	if _, err = io.ReadFull(rd, fixed); err != nil {
+		return err
+	}
+
+	r.present = true
+	r.offset = binary.BigEndian.Uint64(fixed)
+	r.length = binary.BigEndian.Uint64(fixed[8:])
+	copy(r.hash[:], fixed[16:])
+	r.changed = fixed[16+sha1.Size] == 1
+
+	strs := make([]string, 2)
+	for i := range strs {
+		size := make([]byte, 2)
+		if _, err = io.ReadFull(rd, size); err != nil {
+			return err
+		}
+
+		s := make([]byte, binary.BigEndian.Uint16(size))
+		if _, err = io.ReadFull(rd, s); err != nil {
+			return err
+		}
+		strs[i] = string(s)
+	}
+	r.etag, r.lastModified = strs[0], strs[1]
+
+	return nil
+}
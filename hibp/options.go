@@ -0,0 +1,129 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package hibp
+
+import (
+	"github.com/alvinbaena/pwd-checker/progress"
+	"github.com/rs/zerolog/log"
+)
+
+// Option configures a Downloader.
+type Option func(*options)
+
+type options struct {
+	resume      bool
+	retryFailed bool
+	ordered     bool
+	previous    string
+	baseURL     string
+	mirrors     []string
+	proxy       string
+	caBundle    string
+	rateLimit   int
+	rangeWriter RangeWriter
+	progress    progress.Reporter
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{baseURL: DefaultBaseURL}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.progress == nil {
+		o.progress = progress.NewLogReporter(log.Logger)
+	}
+
+	return o
+}
+
+// WithResume continues a previous download of the same output file, skipping the ranges recorded
+// in its checkpoint. Without a checkpoint the download starts from the beginning. Without an output
+// file, the ranges the RangeChecker has are skipped.
+func WithResume(resume bool) Option {
+	return func(o *options) {
+		o.resume = resume
+	}
+}
+
+// WithRetryFailed downloads only the ranges listed in the failed ranges file of a previous download
+// of the same output file, appending them to it.
+func WithRetryFailed(retryFailed bool) Option {
+	return func(o *options) {
+		o.retryFailed = retryFailed
+	}
+}
+
+// WithOrderedOutput writes the ranges to the output file in prefix order, instead of the order in
+// which they finish downloading. The Pwned Passwords hashes are sorted within each range, so the
+// output is sorted by hash and the same data always produces the same file. The ranges downloaded
+// by WithResume or WithRetryFailed are appended, and the file is rewritten in prefix order once
+// it's complete.
+func WithOrderedOutput(ordered bool) Option {
+	return func(o *options) {
+		o.ordered = ordered
+	}
+}
+
+// WithPrevious refreshes the output file of a previous download. Each range is requested with the
+// ETag recorded in the metadata of the previous download, and the ranges that didn't change are
+// copied from its output file instead of downloaded.
+func WithPrevious(fileName string) Option {
+	return func(o *options) {
+		o.previous = fileName
+	}
+}
+
+// WithBaseURL downloads the ranges from a Pwned Passwords range API other than DefaultBaseURL,
+// requesting {baseURL}/range/{prefix}.
+func WithBaseURL(baseURL string) Option {
+	return func(o *options) {
+		o.baseURL = baseURL
+	}
+}
+
+// WithMirrors are base URLs of other range APIs, used in order when a request to the base URL fails
+// after all its retries.
+func WithMirrors(mirrors ...string) Option {
+	return func(o *options) {
+		o.mirrors = mirrors
+	}
+}
+
+// WithProxy sends the requests through an HTTP(S) proxy, instead of the one set by the HTTP_PROXY
+// and HTTPS_PROXY environment variables.
+func WithProxy(proxyURL string) Option {
+	return func(o *options) {
+		o.proxy = proxyURL
+	}
+}
+
+// WithCABundle trusts the certificates of a PEM file, besides the system CAs.
+func WithCABundle(fileName string) Option {
+	return func(o *options) {
+		o.caBundle = fileName
+	}
+}
+
+// WithRateLimit limits the requests sent per second, retries included. 0 means no limit.
+func WithRateLimit(requestsPerSecond int) Option {
+	return func(o *options) {
+		o.rateLimit = requestsPerSecond
+	}
+}
+
+// WithRangeWriter also gives each downloaded range to w, after it's written to the output file.
+// With a RangeWriter the output file is optional, see NewDownloader.
+func WithRangeWriter(w RangeWriter) Option {
+	return func(o *options) {
+		o.rangeWriter = w
+	}
+}
+
+// WithProgress sets the reporter of the progress of the download. By default the progress is
+// written to the log.
+func WithProgress(reporter progress.Reporter) Option {
+	return func(o *options) {
+		o.progress = reporter
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package hibp
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const rangeFileExt = ".txt"
+
+// RangeFileName is the name of the file of a range in a range directory, 00000.txt to FFFFF.txt.
+func RangeFileName(dir string, prefix int) string {
+	return filepath.Join(dir, getHashRange(prefix)+rangeFileExt)
+}
+
+// RangeDirectory writes each range to its own file in a directory, the layout used by the official
+// PwnedPasswordsDownloader. Like the range API responses, the files have the hash suffixes with
+// their counts, SUFFIX:COUNT, the prefix is the file name.
+type RangeDirectory struct {
+	dir string
+}
+
+// NewRangeDirectory writer of the ranges to dir, which is created if missing.
+func NewRangeDirectory(dir string) (*RangeDirectory, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &RangeDirectory{dir: dir}, nil
+}
+
+// WriteRange writes the range to a temporary file and renames it, so a range file is either
+// complete or missing.
+func (d *RangeDirectory) WriteRange(prefix int, lines []byte) error {
+	var b bytes.Buffer
+	b.Grow(len(lines))
+	for len(lines) > 0 {
+		line, rest, _ := bytes.Cut(lines, []byte("\n"))
+		lines = rest
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		if len(line) < 5 {
+			return fmt.Errorf("invalid line in range %s: %q", getHashRange(prefix), line)
+		}
+		b.Write(line[5:])
+		b.WriteByte('\n')
+	}
+
+	fileName := RangeFileName(d.dir, prefix)
+	tmp := fileName + ".tmp"
+	if err := os.WriteFile(tmp, b.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, fileName)
+}
+
+// WrittenRanges are the ranges with a file in the directory, downloaded before. A range with files
+// in both cases is listed once.
+func (d *RangeDirectory) WrittenRanges() ([]int, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var written []int
+	seen := newCheckpoint("")
+	for _, entry := range entries {
+		if prefix, ok := rangeFilePrefix(entry.Name()); ok && entry.Type().IsRegular() {
+			i, _ := ParsePrefix(prefix)
+			if !seen.isDone(i) {
+				seen.markDone(i)
+				written = append(written, i)
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// ReadRanges reads the ranges of a range directory, or of a tarball of one (optionally gzipped),
+// calling fn with each of their lines with the prefix added, HASH:COUNT. The line is only valid
+// during the call. Files with other names are ignored. It returns the number of distinct ranges
+// read, a range in more than one file is counted once.
+func ReadRanges(fileName string, fn func(line []byte) error) (int, error) {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return 0, err
+	}
+
+	if info.IsDir() {
+		return readRangeDirectory(fileName, fn)
+	}
+
+	return readRangeTar(fileName, fn)
+}
+
+func readRangeDirectory(dir string, fn func(line []byte) error) (int, error) {
+	// Sorted by name, so the ranges are read in prefix order
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	read := newCheckpoint("")
+	for _, entry := range entries {
+		prefix, ok := rangeFilePrefix(entry.Name())
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+
+		if err = readRangeFile(filepath.Join(dir, entry.Name()), prefix, fn); err != nil {
+			return read.count(), err
+		}
+		markRead(read, prefix)
+	}
+
+	return read.count(), nil
+}
+
+func readRangeFile(fileName string, prefix string, fn func(line []byte) error) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err = readRangeLines(file, prefix, fn); err != nil {
+		return fmt.Errorf("error reading range file %s: %w", fileName, err)
+	}
+
+	return nil
+}
+
+func readRangeTar(fileName string, fn func(line []byte) error) (int, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var in io.Reader = bufio.NewReader(file)
+	// gzip magic number
+	if magic, err := in.(*bufio.Reader).Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		in = gz
+	}
+
+	// Tar entries can repeat a range, the ranges read are counted once
+	read := newCheckpoint("")
+	tr := tar.NewReader(in)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return read.count(), nil
+		}
+		if err != nil {
+			return read.count(), fmt.Errorf("error reading tarball %s: %w", fileName, err)
+		}
+
+		prefix, ok := rangeFilePrefix(path.Base(header.Name))
+		if !ok || header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if err = readRangeLines(tr, prefix, fn); err != nil {
+			return read.count(), fmt.Errorf("error reading range %s of tarball %s: %w", header.Name, fileName, err)
+		}
+		markRead(read, prefix)
+	}
+}
+
+// markRead marks the range of a valid prefix as read.
+func markRead(read *checkpoint, prefix string) {
+	i, _ := ParsePrefix(prefix)
+	read.markDone(i)
+}
+
+// readRangeLines calls fn with each line of a range file, with the prefix added.
+func readRangeLines(r io.Reader, prefix string, fn func(line []byte) error) error {
+	line := []byte(prefix)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		suffix := bytes.TrimSpace(scanner.Bytes())
+		if len(suffix) == 0 {
+			continue
+		}
+
+		line = append(line[:len(prefix)], suffix...)
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// rangeFilePrefix is the prefix of a range file name, PPPPP.txt, in upper case.
+func rangeFilePrefix(name string) (string, bool) {
+	prefix, found := strings.CutSuffix(name, rangeFileExt)
+	if !found {
+		return "", false
+	}
+
+	if _, err := ParsePrefix(prefix); err != nil {
+		return "", false
+	}
+
+	return strings.ToUpper(prefix), true
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package hibp
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRangeDirectory(t *testing.T) {
+	ranges := 16
+	dir := filepath.Join(t.TempDir(), "ranges")
+	out, err := NewRangeDirectory(dir)
+	if err != nil {
+		t.Fatalf("Should not fail creating directory: %s", err)
+	}
+
+	d := newTestDownloader(t, nil, 4, newRangeServer(t, nil), WithRangeWriter(out))
+	if err = d.ProcessRanges(context.Background(), ranges, true); err != nil {
+		t.Fatalf("Should not fail download: %s", err)
+	}
+
+	for i := 0; i < ranges; i++ {
+		data, err := os.ReadFile(RangeFileName(dir, i))
+		if err != nil {
+			t.Fatalf("Should not fail reading range file: %s", err)
+		}
+
+		want := fmt.Sprintf("%035X:1\r\n%035X:2\r\n%035X:3\r\n", 0, 1, 2)
+		if string(data) != want {
+			t.Errorf("Range file %d: %q, want: %q", i, data, want)
+		}
+	}
+
+	// Only the missing ranges are downloaded when resuming
+	for _, i := range []int{3, 9} {
+		if err = os.Remove(RangeFileName(dir, i)); err != nil {
+			t.Fatalf("Should not fail deleting range file: %s", err)
+		}
+	}
+
+	var requested atomic.Int32
+	d = newTestDownloader(t, nil, 1, newRangeServer(t, func(prefix string) int {
+		requested.Add(1)
+		return http.StatusOK
+	}), WithRangeWriter(out), WithResume(true))
+	if err = d.ProcessRanges(context.Background(), ranges, true); err != nil {
+		t.Fatalf("Should not fail resuming download: %s", err)
+	}
+	if requested.Load() != 2 {
+		t.Errorf("Resume requested %d ranges, want: 2", requested.Load())
+	}
+
+	var lines []string
+	read, err := ReadRanges(dir, func(line []byte) error {
+		lines = append(lines, string(line))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Should not fail reading ranges: %s", err)
+	}
+	if read != ranges || len(lines) != ranges*3 {
+		t.Fatalf("Read %d ranges and %d lines, want: %d and %d", read, len(lines), ranges, ranges*3)
+	}
+	if !sort.StringsAreSorted(lines) {
+		t.Errorf("Ranges of a directory should be read in prefix order")
+	}
+	if want := fmt.Sprintf("00002%035X:3", 2); lines[8] != want {
+		t.Errorf("Line: %q, want: %q", lines[8], want)
+	}
+}
+
+func TestReadRanges_Tarball(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		fileName := filepath.Join(t.TempDir(), "ranges.tar")
+		writeTestTarball(t, fileName, compressed, map[string]string{
+			"ranges/0000A.txt": "0123456789ABCDEF0123456789ABCDEF012:10\r\n",
+			"ranges/fffff.txt": "0123456789ABCDEF0123456789ABCDEF012:1\r\n0123456789ABCDEF0123456789ABCDEF013:2\r\n",
+			"ranges/README":    "not a range",
+		})
+
+		var lines []string
+		read, err := ReadRanges(fileName, func(line []byte) error {
+			lines = append(lines, string(line))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Should not fail reading ranges: %s", err)
+		}
+
+		sort.Strings(lines)
+		want := []string{
+			"0000A0123456789ABCDEF0123456789ABCDEF012:10",
+			"FFFFF0123456789ABCDEF0123456789ABCDEF012:1",
+			"FFFFF0123456789ABCDEF0123456789ABCDEF013:2",
+		}
+		if read != 2 || fmt.Sprint(lines) != fmt.Sprint(want) {
+			t.Errorf("Read %d ranges: %q, want: 2 ranges: %q", read, lines, want)
+		}
+	}
+}
+
+func TestReadRanges_Repeated(t *testing.T) {
+	line := "0123456789ABCDEF0123456789ABCDEF012:1\r\n"
+
+	// Entries in other directories, or the prefix in another case, have the same range
+	tarball := filepath.Join(t.TempDir(), "ranges.tar")
+	writeTestTarball(t, tarball, false, map[string]string{
+		"ranges/0000A.txt": line,
+		"copy/0000A.txt":   line,
+		"ranges/0000a.txt": line,
+		"ranges/00002.txt": line,
+	})
+
+	dir := t.TempDir()
+	for _, name := range []string{"0000A.txt", "0000a.txt", "00002.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(line), 0644); err != nil {
+			t.Fatalf("Should not fail writing file: %s", err)
+		}
+	}
+
+	for _, fileName := range []string{tarball, dir} {
+		read, err := ReadRanges(fileName, func(line []byte) error {
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Should not fail reading ranges: %s", err)
+		}
+		if read != 2 {
+			t.Errorf("%s: read %d ranges, want each range counted once: 2", fileName, read)
+		}
+	}
+
+	out, err := NewRangeDirectory(dir)
+	if err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+	written, err := out.WrittenRanges()
+	if err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+	if fmt.Sprint(written) != "[2 10]" {
+		t.Errorf("Written ranges %v, want each range once: [2 10]", written)
+	}
+}
+
+func writeTestTarball(t *testing.T, fileName string, compressed bool, files map[string]string) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		t.Fatalf("Should not fail creating file: %s", err)
+	}
+	defer file.Close()
+
+	var gz *gzip.Writer
+	tw := tar.NewWriter(file)
+	if compressed {
+		gz = gzip.NewWriter(file)
+		tw = tar.NewWriter(gz)
+	}
+
+	for name, data := range files {
+		if err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("Should not fail writing tarball: %s", err)
+		}
+		if _, err = tw.Write([]byte(data)); err != nil {
+			t.Fatalf("Should not fail writing tarball: %s", err)
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		t.Fatalf("Should not fail writing tarball: %s", err)
+	}
+	if gz != nil {
+		if err = gz.Close(); err != nil {
+			t.Fatalf("Should not fail writing tarball: %s", err)
+		}
+	}
+}
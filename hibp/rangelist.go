@@ -0,0 +1,87 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package hibp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// FailedFileName is the name of the file that lists the ranges a download could not get, kept
+// next to the download output file.
+func FailedFileName(outFileName string) string {
+	return outFileName + ".failed"
+}
+
+// ChangedFileName is the name of the file that lists the ranges that changed since the previous
+// download, kept next to the download output file.
+func ChangedFileName(outFileName string) string {
+	return outFileName + ".changed"
+}
+
+// readRangeList reads the prefixes of a ranges list file, one per line.
+func readRangeList(fileName string) ([]int, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var ranges []int
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		i, err := ParsePrefix(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q in %s: %w", line, fileName, err)
+		}
+		ranges = append(ranges, i)
+	}
+
+	return ranges, scanner.Err()
+}
+
+// writeRangeList writes the prefixes of the ranges, sorted, or removes the file if there are none.
+func writeRangeList(fileName string, ranges []int) error {
+	if len(ranges) == 0 {
+		if err := os.Remove(fileName); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	sort.Ints(ranges)
+	var b strings.Builder
+	for _, i := range ranges {
+		b.WriteString(getHashRange(i))
+		b.WriteByte('\n')
+	}
+
+	return os.WriteFile(fileName, []byte(b.String()), 0644)
+}
+
+// formatRanges lists the prefixes of the ranges for messages, only the first ones when there are
+// many.
+func formatRanges(ranges []int) string {
+	const max = 10
+
+	prefixes := make([]string, 0, max)
+	for _, i := range ranges[:min(len(ranges), max)] {
+		prefixes = append(prefixes, getHashRange(i))
+	}
+
+	if len(ranges) > max {
+		return fmt.Sprintf("%s and %d more", strings.Join(prefixes, ", "), len(ranges)-max)
+	}
+
+	return strings.Join(prefixes, ", ")
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package hibp
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Ranges downloaded ahead of the next one to write are held in memory, about 32KiB each.
+const reorderWindow = 1024
+
+// reorderBuffer writes the downloaded ranges in the order of a list of ranges, no matter the order
+// in which they finish downloading. Only a window of ranges after the next one to write can be
+// downloaded at a time, so the memory used is bounded.
+type reorderBuffer struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	order []int
+	// Position in order of the next range to write
+	next   int
+	window int
+	ready  map[int]readyRange
+	write  func(i int, r *downloadedRange) error
+}
+
+type readyRange struct {
+	r *downloadedRange
+	// A skipped range is not written, it failed to download
+	skipped bool
+}
+
+// newReorderBuffer buffer for the ranges of order, which must be sorted. Ranges waiting for their
+// turn to download are released when ctx is done.
+func newReorderBuffer(ctx context.Context, order []int, window int, write func(i int, r *downloadedRange) error) *reorderBuffer {
+	b := &reorderBuffer{
+		order:  order,
+		window: window,
+		ready:  make(map[int]readyRange),
+		write:  write,
+	}
+	b.cond = sync.NewCond(&b.mu)
+
+	context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.cond.Broadcast()
+	})
+
+	return b
+}
+
+// reserve waits until the range is inside the window of ranges that can be downloaded. It returns
+// false if ctx is done first.
+func (b *reorderBuffer) reserve(ctx context.Context, i int) bool {
+	pos := sort.SearchInts(b.order, i)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for pos >= b.next+b.window && ctx.Err() == nil {
+		b.cond.Wait()
+	}
+
+	return ctx.Err() == nil
+}
+
+// put adds a downloaded range, writing it and the ranges after it that are ready if it's the next
+// one in order.
+func (b *reorderBuffer) put(i int, r *downloadedRange) error {
+	return b.add(i, readyRange{r: r})
+}
+
+// skip marks a range as failed, so the ranges after it are not held waiting for it.
+func (b *reorderBuffer) skip(i int) error {
+	return b.add(i, readyRange{skipped: true})
+}
+
+func (b *reorderBuffer) add(i int, r readyRange) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.ready[i] = r
+	for b.next < len(b.order) {
+		next, found := b.ready[b.order[b.next]]
+		if !found {
+			break
+		}
+
+		if !next.skipped {
+			if err := b.write(b.order[b.next], next.r); err != nil {
+				return err
+			}
+		}
+
+		delete(b.ready, b.order[b.next])
+		b.next++
+		b.cond.Broadcast()
+	}
+
+	return nil
+}
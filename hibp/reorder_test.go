@@ -0,0 +1,91 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package hibp
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReorderBuffer(t *testing.T) {
+	order := []int{1, 2, 3, 5, 8, 13, 21, 34, 55, 89}
+	window := 2
+
+	var written []int
+	var b *reorderBuffer
+	b = newReorderBuffer(context.Background(), order, window, func(i int, r *downloadedRange) error {
+		if int(r.data[0]) != i {
+			t.Errorf("Range %d written with the data of %d", i, r.data[0])
+		}
+		written = append(written, i)
+		return nil
+	})
+
+	wg := sync.WaitGroup{}
+	for _, i := range order {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if !b.reserve(context.Background(), i) {
+				t.Errorf("Should reserve range %d", i)
+				return
+			}
+
+			// Only the ranges in the window can be downloaded at once
+			b.mu.Lock()
+			if ahead := len(b.ready); ahead >= window {
+				t.Errorf("%d ranges are waiting to be written, window is %d", ahead, window)
+			}
+			b.mu.Unlock()
+
+			time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+			var err error
+			if i == 13 {
+				err = b.skip(i)
+			} else {
+				err = b.put(i, &downloadedRange{data: []byte{byte(i)}})
+			}
+			if err != nil {
+				t.Errorf("Should not fail: %s", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	want := []int{1, 2, 3, 5, 8, 21, 34, 55, 89}
+	if len(written) != len(want) {
+		t.Fatalf("Written ranges: %v, want: %v", written, want)
+	}
+	for i := range want {
+		if written[i] != want[i] {
+			t.Fatalf("Written ranges: %v, want: %v", written, want)
+		}
+	}
+}
+
+func TestReorderBuffer_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := newReorderBuffer(ctx, []int{0, 1, 2}, 1, func(i int, r *downloadedRange) error {
+		return nil
+	})
+
+	done := make(chan bool)
+	go func() {
+		// Waits for range 0, which never arrives
+		done <- b.reserve(ctx, 2)
+	}()
+
+	cancel()
+	select {
+	case reserved := <-done:
+		if reserved {
+			t.Errorf("Should not reserve a range after cancelling")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Waiting ranges should be released when cancelled")
+	}
+}
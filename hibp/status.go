@@ -4,6 +4,7 @@
 package hibp
 
 import (
+	"github.com/alvinbaena/pwd-checker/progress"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
@@ -14,7 +15,9 @@ import (
 
 type status struct {
 	rangesDownloaded           uint64
+	rangesSkipped              uint64
 	hashesDownloaded           uint64
+	rangesChanged              uint64
 	cloudflareRequests         uint64
 	cloudflareHits             uint64
 	cloudflareMisses           uint64
@@ -23,38 +26,72 @@ type status struct {
 	ticker                     *time.Ticker
 	progress                   chan bool
 	totalRanges                int
+	// If the download is a refresh of a previous one, reporting the changed ranges
+	refresh  bool
+	reporter progress.Reporter
 }
 
-func newStatus(totalRanges int) *status {
+const (
+	progressProcess = "download"
+	progressStage   = "Download"
+)
+
+func newStatus(totalRanges int, refresh bool, reporter progress.Reporter) *status {
 	return &status{
 		start:       time.Now(),
 		ticker:      time.NewTicker(10 * time.Second),
 		progress:    make(chan bool),
 		totalRanges: totalRanges,
+		refresh:     refresh,
+		reporter:    reporter,
 	}
 }
 
 // BeginProgress reports the progress of the download every 10 seconds.
 func (s *status) BeginProgress() {
+	s.reporter.Report(progress.NewEvent(progress.StageStarted, progressProcess, progressStage, 0, uint64(s.totalRanges), 0))
 	go func() {
 		for {
 			select {
 			case <-s.progress:
 				return
 			case <-s.ticker.C:
-				total := float64(s.totalRanges)
-				log.Info().Msgf("%.2f%% Hash ranges downloaded. %.0f hashes/s", (float64(s.rangesDownloaded)*100)/total, s.hashesPerSecond())
+				s.reporter.Report(s.event(progress.StageProgress))
 			}
 		}
 	}()
 }
 
-func (s *status) RangeDownloaded() {
+// event is the progress of the download. The rate and ETA only count the ranges downloaded, not the
+// ones skipped because they were already downloaded.
+func (s *status) event(kind progress.Kind) progress.Event {
+	done := atomic.LoadUint64(&s.rangesDownloaded)
+	skipped := atomic.LoadUint64(&s.rangesSkipped)
+	total := uint64(s.totalRanges)
+
+	e := progress.NewEvent(kind, progressProcess, progressStage, done-skipped, total-skipped, time.Since(s.start))
+	e.Done, e.Total = done, total
+	e.Counters = map[string]uint64{"hashes": atomic.LoadUint64(&s.hashesDownloaded)}
+	if s.refresh {
+		e.Counters["changed"] = atomic.LoadUint64(&s.rangesChanged)
+	}
+
+	return e
+}
+
+// RangeDownloaded counts a range written to the output file, with its hashes.
+func (s *status) RangeDownloaded(hashes uint64, changed bool) {
 	atomic.AddUint64(&s.rangesDownloaded, 1)
+	atomic.AddUint64(&s.hashesDownloaded, hashes)
+	if changed {
+		atomic.AddUint64(&s.rangesChanged, 1)
+	}
 }
 
-func (s *status) HashDownloaded() {
-	atomic.AddUint64(&s.hashesDownloaded, 1)
+// RangeSkipped counts a range that was already downloaded.
+func (s *status) RangeSkipped() {
+	atomic.AddUint64(&s.rangesDownloaded, 1)
+	atomic.AddUint64(&s.rangesSkipped, 1)
 }
 
 func (s *status) RequestComplete(res *http.Response, millis int64) {
@@ -82,12 +119,18 @@ func (s *status) hashesPerSecond() float64 {
 
 func (s *status) Done() {
 	s.progress <- true
+	s.reporter.Report(s.event(progress.StageFinished))
+	s.reporter.Report(progress.NewEvent(progress.Finished, progressProcess, "", 0, 0, time.Since(s.start)))
+
 	cloudflareHitPercent := float64(s.cloudflareHits*100) / float64(s.cloudflareRequests)
 	cloudflareMissPercent := float64(s.cloudflareMisses*100) / float64(s.cloudflareRequests)
 	requestAverage := float64(s.cloudflareRequestTimeTotal) / float64(s.cloudflareRequests)
 
 	p := message.NewPrinter(language.English)
 	log.Info().Msgf("finished downloading all hash ranges in %v. %.0f hashes/s", time.Since(s.start), s.hashesPerSecond())
+	if s.refresh {
+		log.Info().Msgf("%s ranges changed since the previous download", p.Sprintf("%d", s.rangesChanged))
+	}
 	log.Debug().Msgf("made %s Cloudflare requests. Average response time %.2f ms", p.Sprintf("%d", s.cloudflareRequests), requestAverage)
 	log.Debug().Msgf("cloudflare cache hits: %s (%.2f%%), misses: %s (%.2f%%)", p.Sprintf("%d", s.cloudflareHits), cloudflareHitPercent, p.Sprintf("%d", s.cloudflareMisses), cloudflareMissPercent)
 }
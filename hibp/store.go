@@ -0,0 +1,285 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package hibp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Ranges is the number of hash ranges (5 hex characters prefixes) of the Pwned Passwords API.
+	Ranges = 1024 * 1024
+	// A stored entry is the 35 hex characters suffix (padded to 18 bytes) and the count (4 bytes)
+	storeSuffixSize = 18
+	storeEntrySize  = storeSuffixSize + 4
+	// number of entries [magic]
+	storeFooterSize = 16
+	storeMagic      = "[HIBP:0]"
+)
+
+var (
+	// ErrNotStore is returned when a file is not a range store.
+	ErrNotStore = errors.New("not a range store file")
+	// ErrInvalidPrefix is returned when a range prefix is not 5 hex characters.
+	ErrInvalidPrefix = errors.New("the hash prefix was not in a valid format")
+)
+
+// RangeEntry is a line of a Pwned Passwords range: the hash suffix and how many times it has been
+// seen in breaches.
+type RangeEntry struct {
+	Suffix string
+	Count  uint32
+}
+
+// StoreBuilder creates a range store from a file written by the Downloader. The store has the
+// entries of each range together, so a range can be read without scanning the file.
+type StoreBuilder struct {
+	in  *os.File
+	out *os.File
+}
+
+// NewStoreBuilder builder for a range store. The input file is read twice, once to count the
+// entries of each range and once to write them.
+func NewStoreBuilder(in *os.File, out *os.File) *StoreBuilder {
+	return &StoreBuilder{in: in, out: out}
+}
+
+// Process creates the store file: the entries of each range, followed by the index of the first
+// entry of each range and a footer.
+func (b *StoreBuilder) Process() error {
+	log.Info().Msg("counting the hashes of each range")
+	counts := make([]uint64, Ranges)
+	err := b.scan(func(prefix int, _ []byte) error {
+		counts[prefix]++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Index of the first entry of each range, and the total at the end
+	offsets := make([]uint64, Ranges+1)
+	for i, count := range counts {
+		offsets[i+1] = offsets[i] + count
+	}
+	log.Info().Msgf("writing %d hashes to the range store", offsets[Ranges])
+
+	// Downloaded files have the lines of each range together, so they are written to the store in
+	// runs. The written count of each range is kept in case a range is split.
+	written := make([]uint64, Ranges)
+	run := make([]byte, 0, 1024*storeEntrySize)
+	runPrefix := -1
+	flush := func() error {
+		if runPrefix < 0 || len(run) == 0 {
+			return nil
+		}
+
+		pos := (offsets[runPrefix] + written[runPrefix]) * storeEntrySize
+		if _, err := b.out.WriteAt(run, int64(pos)); err != nil {
+			return err
+		}
+
+		written[runPrefix] += uint64(len(run) / storeEntrySize)
+		run = run[:0]
+		return nil
+	}
+
+	err = b.scan(func(prefix int, entry []byte) error {
+		if prefix != runPrefix {
+			if err := flush(); err != nil {
+				return err
+			}
+			runPrefix = prefix
+		}
+
+		run = append(run, entry...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = flush(); err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(io.NewOffsetWriter(b.out, int64(offsets[Ranges])*storeEntrySize))
+	buf := make([]byte, 8)
+	// The footer starts with the number of entries, which is the last offset
+	for _, offset := range append(offsets, offsets[Ranges]) {
+		binary.BigEndian.PutUint64(buf, offset)
+		if _, err = out.Write(buf); err != nil {
+			return err
+		}
+	}
+	if _, err = out.Write([]byte(storeMagic)); err != nil {
+		return err
+	}
+
+	return out.Flush()
+}
+
+// scan calls fn with the range and encoded entry of each line of the input file.
+func (b *StoreBuilder) scan(fn func(prefix int, entry []byte) error) error {
+	if _, err := b.in.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	entry := make([]byte, storeEntrySize)
+	scanner := bufio.NewScanner(b.in)
+	for line := 1; scanner.Scan(); line++ {
+		prefix, err := encodeEntry(scanner.Text(), entry)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if err = fn(prefix, entry); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// encodeEntry encodes a "HASH:COUNT" line, returning the range of the hash.
+func encodeEntry(line string, entry []byte) (int, error) {
+	hash, count, found := strings.Cut(line, ":")
+	if !found || len(hash) != 40 {
+		return 0, fmt.Errorf("invalid Pwned Passwords line %q", line)
+	}
+
+	prefix, err := ParsePrefix(hash[:5])
+	if err != nil {
+		return 0, err
+	}
+
+	// Pad the suffix to a whole number of bytes
+	if _, err = hex.Decode(entry[:storeSuffixSize], []byte(hash[5:]+"0")); err != nil {
+		return 0, fmt.Errorf("invalid Pwned Passwords line %q", line)
+	}
+
+	c, err := strconv.ParseUint(count, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Pwned Passwords line %q", line)
+	}
+	binary.BigEndian.PutUint32(entry[storeSuffixSize:], uint32(c))
+
+	return prefix, nil
+}
+
+// ParsePrefix parses a 5 hex characters range prefix, in upper or lower case.
+func ParsePrefix(prefix string) (int, error) {
+	if len(prefix) != 5 {
+		return 0, ErrInvalidPrefix
+	}
+
+	v, err := strconv.ParseUint(prefix, 16, 32)
+	if err != nil {
+		return 0, ErrInvalidPrefix
+	}
+
+	return int(v), nil
+}
+
+// Store reads the ranges of a range store file.
+type Store struct {
+	file    *os.File
+	offsets []uint64
+}
+
+// OpenStore opens a range store file, loading its index (8MiB) in memory.
+func OpenStore(fileName string) (*Store, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{file: file}
+	if err = s.readIndex(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Store) readIndex() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	indexSize := int64(Ranges+1) * 8
+	if info.Size() < indexSize+storeFooterSize {
+		return ErrNotStore
+	}
+
+	footer := make([]byte, storeFooterSize)
+	if _, err = s.file.ReadAt(footer, info.Size()-storeFooterSize); err != nil {
+		return err
+	}
+	if string(footer[8:]) != storeMagic {
+		return ErrNotStore
+	}
+
+	entries := binary.BigEndian.Uint64(footer)
+	dataSize := info.Size() - indexSize - storeFooterSize
+	if uint64(dataSize) != entries*storeEntrySize {
+		return fmt.Errorf("range store size does not match its %d entries", entries)
+	}
+
+	index := bufio.NewReader(io.NewSectionReader(s.file, dataSize, indexSize))
+	buf := make([]byte, 8)
+	s.offsets = make([]uint64, Ranges+1)
+	for i := range s.offsets {
+		if _, err = io.ReadFull(index, buf); err != nil {
+			return err
+		}
+
+		s.offsets[i] = binary.BigEndian.Uint64(buf)
+		if s.offsets[i] > entries || (i > 0 && s.offsets[i] < s.offsets[i-1]) {
+			return fmt.Errorf("invalid range store index")
+		}
+	}
+
+	return nil
+}
+
+// Range returns the entries of a range, in the order they were downloaded.
+func (s *Store) Range(prefix int) ([]RangeEntry, error) {
+	if prefix < 0 || prefix >= Ranges {
+		return nil, ErrInvalidPrefix
+	}
+
+	start, end := s.offsets[prefix], s.offsets[prefix+1]
+	data := make([]byte, (end-start)*storeEntrySize)
+	if _, err := s.file.ReadAt(data, int64(start)*storeEntrySize); err != nil {
+		return nil, err
+	}
+
+	entries := make([]RangeEntry, 0, end-start)
+	for len(data) > 0 {
+		entries = append(entries, RangeEntry{
+			// Remove the padding
+			Suffix: strings.ToUpper(hex.EncodeToString(data[:storeSuffixSize]))[:35],
+			Count:  binary.BigEndian.Uint32(data[storeSuffixSize:storeEntrySize]),
+		})
+		data = data[storeEntrySize:]
+	}
+
+	return entries, nil
+}
+
+// Close closes the store file.
+func (s *Store) Close() error {
+	return s.file.Close()
+}
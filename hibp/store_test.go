@@ -0,0 +1,117 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package hibp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func buildTestStore(t *testing.T) string {
+	in, err := os.Open("../test/data/pwned-sample-sha1.txt")
+	if err != nil {
+		t.Fatalf("Should not fail opening file: %s", err)
+	}
+	defer in.Close()
+
+	fileName := filepath.Join(t.TempDir(), "pwned.range")
+	out, err := os.Create(fileName)
+	if err != nil {
+		t.Fatalf("Should not fail creating file: %s", err)
+	}
+	defer out.Close()
+
+	if err = NewStoreBuilder(in, out).Process(); err != nil {
+		t.Fatalf("Should not fail building the store: %s", err)
+	}
+
+	return fileName
+}
+
+func TestStore(t *testing.T) {
+	store, err := OpenStore(buildTestStore(t))
+	if err != nil {
+		t.Fatalf("Should not fail opening the store: %s", err)
+	}
+	defer store.Close()
+
+	sample, err := os.ReadFile("../test/data/pwned-sample-sha1.txt")
+	if err != nil {
+		t.Fatalf("Should not fail reading file: %s", err)
+	}
+
+	want := make(map[int][]RangeEntry)
+	for _, line := range strings.Split(strings.TrimSpace(string(sample)), "\n") {
+		hash, count, _ := strings.Cut(line, ":")
+		prefix, err := ParsePrefix(hash[:5])
+		if err != nil {
+			t.Fatalf("Should not fail: %s", err)
+		}
+
+		c, err := strconv.ParseUint(count, 10, 32)
+		if err != nil {
+			t.Fatalf("Should not fail: %s", err)
+		}
+		want[prefix] = append(want[prefix], RangeEntry{Suffix: hash[5:], Count: uint32(c)})
+	}
+
+	for _, prefix := range []int{0x00000, 0x5BAA6, 0x7110E, 0x301B7, 0x00001, 0xFFFFF} {
+		entries, err := store.Range(prefix)
+		if err != nil {
+			t.Fatalf("Should not fail: %s", err)
+		}
+
+		if len(entries) != len(want[prefix]) {
+			t.Fatalf("Range(%05X) has %d entries, want: %d", prefix, len(entries), len(want[prefix]))
+		}
+		for i, entry := range entries {
+			if entry != want[prefix][i] {
+				t.Errorf("Range(%05X)[%d]: %v, want: %v", prefix, i, entry, want[prefix][i])
+			}
+		}
+	}
+}
+
+func TestStore_InvalidFile(t *testing.T) {
+	if _, err := OpenStore("../test/data/pwned-sample-sha1.txt"); !errors.Is(err, ErrNotStore) {
+		t.Errorf("Should fail with ErrNotStore, got: %v", err)
+	}
+}
+
+func TestParsePrefix(t *testing.T) {
+	cases := []struct {
+		prefix string
+		want   int
+		fail   bool
+	}{
+		{"00000", 0, false},
+		{"5BAA6", 0x5BAA6, false},
+		{"5baa6", 0x5BAA6, false},
+		{"FFFFF", 0xFFFFF, false},
+		{"FFFF", 0, true},
+		{"FFFFFF", 0, true},
+		{"GGGGG", 0, true},
+		{"+FFFF", 0, true},
+	}
+
+	for _, c := range cases {
+		got, err := ParsePrefix(c.prefix)
+		if c.fail {
+			if err == nil {
+				t.Errorf("ParsePrefix(%q) should fail", c.prefix)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Should not fail: %s", err)
+		} else if got != c.want {
+			t.Errorf("ParsePrefix(%q): %X, want: %X", c.prefix, got, c.want)
+		}
+	}
+}
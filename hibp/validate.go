@@ -0,0 +1,76 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package hibp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Length of the hash suffix of a range line, the 40 hex characters of a SHA1 without the prefix.
+const suffixLen = 35
+
+// ErrInvalidRange is returned when the body of a range response is not a valid range, like the
+// error page of a proxy or a truncated response.
+var ErrInvalidRange = errors.New("invalid range data")
+
+// validateRange checks the body of a range response: it has at least one line, each line is a
+// 35 hex characters suffix and a count, SUFFIX:COUNT, and the suffixes are sorted and unique.
+func validateRange(body []byte) error {
+	var previous [suffixLen]byte
+	lines := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := bytes.TrimSuffix(scanner.Bytes(), []byte("\r"))
+		lines++
+
+		if len(line) < suffixLen+2 || line[suffixLen] != ':' {
+			return fmt.Errorf("%w: line %d is not SUFFIX:COUNT: %q", ErrInvalidRange, lines, truncateLine(line))
+		}
+
+		// Upper case, so suffixes in any case can be compared
+		var suffix [suffixLen]byte
+		for i, c := range line[:suffixLen] {
+			switch {
+			case c >= '0' && c <= '9', c >= 'A' && c <= 'F':
+				suffix[i] = c
+			case c >= 'a' && c <= 'f':
+				suffix[i] = c - 'a' + 'A'
+			default:
+				return fmt.Errorf("%w: line %d has an invalid hash suffix: %q", ErrInvalidRange, lines, truncateLine(line))
+			}
+		}
+
+		if _, err := strconv.ParseUint(string(line[suffixLen+1:]), 10, 64); err != nil {
+			return fmt.Errorf("%w: line %d has an invalid count: %q", ErrInvalidRange, lines, truncateLine(line))
+		}
+
+		if lines > 1 && bytes.Compare(suffix[:], previous[:]) <= 0 {
+			return fmt.Errorf("%w: line %d is not sorted after the previous one, or repeats it", ErrInvalidRange, lines)
+		}
+		previous = suffix
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRange, err)
+	}
+
+	if lines == 0 {
+		return fmt.Errorf("%w: the range is empty", ErrInvalidRange)
+	}
+
+	return nil
+}
+
+// truncateLine shortens a line for an error message, an error page can have very long lines.
+func truncateLine(line []byte) []byte {
+	if len(line) > 64 {
+		return line[:64]
+	}
+
+	return line
+}
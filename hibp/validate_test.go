@@ -0,0 +1,47 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package hibp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateRange(t *testing.T) {
+	suffix := func(c string) string {
+		return strings.Repeat(c, suffixLen)
+	}
+
+	cases := []struct {
+		name string
+		body string
+		fail bool
+	}{
+		{name: "valid", body: suffix("0") + ":1\r\n" + suffix("1") + ":22\r\n" + suffix("A") + ":0", fail: false},
+		{name: "lower case", body: suffix("a") + ":1\n" + suffix("B") + ":2\n", fail: false},
+		{name: "empty", body: "", fail: true},
+		{name: "html", body: "<html><body>Service Unavailable</body></html>", fail: true},
+		{name: "short suffix", body: suffix("0")[1:] + ":1", fail: true},
+		{name: "long suffix", body: suffix("0") + "0:1", fail: true},
+		{name: "not hex", body: suffix("G") + ":1", fail: true},
+		{name: "no count", body: suffix("0") + ":", fail: true},
+		{name: "count not numeric", body: suffix("0") + ":1x", fail: true},
+		{name: "negative count", body: suffix("0") + ":-1", fail: true},
+		{name: "not sorted", body: suffix("1") + ":1\r\n" + suffix("0") + ":1", fail: true},
+		{name: "repeated", body: suffix("1") + ":1\r\n" + suffix("1") + ":2", fail: true},
+		{name: "blank line", body: suffix("0") + ":1\r\n\r\n" + suffix("1") + ":1", fail: true},
+		{name: "truncated", body: suffix("0") + ":1\r\n" + suffix("1")[:20], fail: true},
+	}
+
+	for _, c := range cases {
+		err := validateRange([]byte(c.body))
+		if c.fail && !errors.Is(err, ErrInvalidRange) {
+			t.Errorf("%s: should fail with ErrInvalidRange, got: %v", c.name, err)
+		}
+		if !c.fail && err != nil {
+			t.Errorf("%s: should not fail: %s", c.name, err)
+		}
+	}
+}
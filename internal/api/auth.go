@@ -0,0 +1,294 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/alvinbaena/pwd-checker/internal/util"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// apiKeyName is the gin context key of the name of the API key of the request.
+const apiKeyName = "apiKeyName"
+
+// APIKeys are the API keys allowed to call the API. Only the SHA256 of the keys are kept, in
+// memory and in the keys file. The file can be reloaded to rotate the keys without a restart.
+type APIKeys struct {
+	fileName string
+	// Hashes given on the command line, always allowed
+	static map[string]string
+	// Hash to name of all the allowed keys
+	keys atomic.Pointer[map[string]string]
+	// Only one reload at a time
+	rm     sync.Mutex
+	loaded os.FileInfo
+}
+
+// NewAPIKeys creates the allowed API keys from the hashes and the keys file, if not empty. Each
+// line of the file is the hex encoded SHA256 of a key, optionally followed by a name for the key
+// used in the logs. Empty lines and lines starting with # are ignored.
+func NewAPIKeys(fileName string, hashes []string) (*APIKeys, error) {
+	k := &APIKeys{fileName: fileName, static: make(map[string]string)}
+	for _, hash := range hashes {
+		if err := addKeyHash(k.static, hash, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// GenerateAPIKey creates a random API key, and its hash for the keys file.
+func GenerateAPIKey() (key string, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", err
+	}
+
+	key = hex.EncodeToString(buf)
+	return key, HashAPIKey(key), nil
+}
+
+// HashAPIKey is the hex encoded SHA256 of a key, as it's written in the keys file.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func addKeyHash(keys map[string]string, hash string, name string) error {
+	hash = strings.ToLower(hash)
+	if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("API key hash %q is not a hex encoded SHA256", hash)
+	}
+
+	if name == "" {
+		name = hash[:8]
+	}
+	keys[hash] = name
+	return nil
+}
+
+// Reload reads the keys file again. If it's not valid the current keys are kept.
+func (k *APIKeys) Reload() error {
+	k.rm.Lock()
+	defer k.rm.Unlock()
+
+	keys := make(map[string]string, len(k.static))
+	for hash, name := range k.static {
+		keys[hash] = name
+	}
+
+	if k.fileName != "" {
+		info, err := os.Stat(k.fileName)
+		if err != nil {
+			return err
+		}
+
+		if err = readKeysFile(k.fileName, keys); err != nil {
+			return err
+		}
+		k.loaded = info
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("there are no API keys")
+	}
+
+	k.keys.Store(&keys)
+	return nil
+}
+
+func readKeysFile(fileName string, keys map[string]string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+
+	defer func(file *os.File) {
+		if err := file.Close(); err != nil {
+			log.Error().Err(err).Msgf("error closing API keys file")
+		}
+	}(file)
+
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hash, name, _ := strings.Cut(line, " ")
+		if err = addKeyHash(keys, hash, strings.TrimSpace(name)); err != nil {
+			return fmt.Errorf("invalid line %d of API keys file %s: %s", n, fileName, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Watch polls the keys file every interval, and reloads the keys when the file changes.
+func (k *APIKeys) Watch(ctx context.Context, interval time.Duration) {
+	if k.fileName == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(k.fileName)
+			if err != nil {
+				log.Warn().Err(err).Msgf("error checking API keys file %s", k.fileName)
+				continue
+			}
+
+			if !k.changed(info) {
+				continue
+			}
+
+			log.Info().Msgf("API keys file %s changed, reloading keys", k.fileName)
+			if err = k.Reload(); err != nil {
+				log.Error().Err(err).Msg("error reloading API keys, keeping the current ones")
+			} else {
+				log.Info().Msg("API keys reloaded")
+			}
+		}
+	}
+}
+
+func (k *APIKeys) changed(info os.FileInfo) bool {
+	k.rm.Lock()
+	defer k.rm.Unlock()
+
+	return !util.SameFileInfo(k.loaded, info)
+}
+
+// Check returns the name of the key, and false if the key is not allowed.
+func (k *APIKeys) Check(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+
+	name, ok := (*k.keys.Load())[HashAPIKey(key)]
+	return name, ok
+}
+
+// requestKey gets the key of the X-API-Key header, or of a bearer Authorization header.
+func requestKey(apiKey string, authorization string) string {
+	if apiKey != "" {
+		return apiKey
+	}
+
+	if scheme, token, ok := strings.Cut(authorization, " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+
+	return ""
+}
+
+// Middleware rejects the requests without an allowed API key.
+func (k *APIKeys) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name, ok := k.Check(requestKey(c.GetHeader("X-API-Key"), c.GetHeader("Authorization")))
+		if !ok {
+			c.Header("WWW-Authenticate", "Bearer")
+			abortWithError(c, http.StatusUnauthorized, codeUnauthorized, "a valid API key is required")
+			return
+		}
+
+		c.Set(apiKeyName, name)
+		c.Next()
+	}
+}
+
+// APIKeyName is the name of the API key used by the request, empty if the API doesn't use keys.
+func APIKeyName(c *gin.Context) string {
+	return c.GetString(apiKeyName)
+}
+
+// apiKeyContext is the context key of the name of the API key of a gRPC call.
+type apiKeyContext struct{}
+
+// grpcAPIKeyName is the name of the API key used by the gRPC call, empty if the API doesn't use keys.
+func grpcAPIKeyName(ctx context.Context) string {
+	name, _ := ctx.Value(apiKeyContext{}).(string)
+	return name
+}
+
+// contextStream is a gRPC stream with a different context.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+func (k *APIKeys) checkGrpc(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var apiKey, authorization string
+	if values := md.Get("x-api-key"); len(values) > 0 {
+		apiKey = values[0]
+	}
+	if values := md.Get("authorization"); len(values) > 0 {
+		authorization = values[0]
+	}
+
+	name, ok := k.Check(requestKey(apiKey, authorization))
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "a valid API key is required")
+	}
+
+	return context.WithValue(ctx, apiKeyContext{}, name), nil
+}
+
+// UnaryInterceptor rejects the gRPC calls without an allowed API key, in the x-api-key or
+// authorization metadata.
+func (k *APIKeys) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := k.checkGrpc(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor rejects the gRPC streams without an allowed API key.
+func (k *APIKeys) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := k.checkGrpc(ss.Context())
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestRequestKey(t *testing.T) {
+	cases := []struct {
+		name          string
+		apiKey        string
+		authorization string
+		want          string
+	}{
+		{name: "none", want: ""},
+		{name: "X-API-Key", apiKey: "key1", want: "key1"},
+		{name: "bearer", authorization: "Bearer key2", want: "key2"},
+		{name: "bearer lower case", authorization: "bearer key2", want: "key2"},
+		{name: "bearer spaces", authorization: "Bearer  key2 ", want: "key2"},
+		{name: "X-API-Key first", apiKey: "key1", authorization: "Bearer key2", want: "key1"},
+		{name: "basic", authorization: "Basic a2V5Mjo=", want: ""},
+		{name: "no scheme", authorization: "key2", want: ""},
+	}
+
+	for _, c := range cases {
+		if got := requestKey(c.apiKey, c.authorization); got != c.want {
+			t.Errorf("%s: key %q, want: %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestReadKeysFile(t *testing.T) {
+	hash1, hash2 := HashAPIKey("key1"), HashAPIKey("key2")
+
+	cases := []struct {
+		name     string
+		contents string
+		want     map[string]string
+		fail     bool
+	}{
+		{name: "hashes", contents: hash1 + "\n" + hash2 + "\n", want: map[string]string{hash1: hash1[:8], hash2: hash2[:8]}},
+		{name: "names", contents: hash1 + " service one\n" + hash2 + " other\n", want: map[string]string{hash1: "service one", hash2: "other"}},
+		{name: "comments and empty lines", contents: "# keys\n\n  " + hash1 + " one  \n# " + hash2 + "\n", want: map[string]string{hash1: "one"}},
+		{name: "upper case", contents: strings.ToUpper(hash1) + " one\n", want: map[string]string{hash1: "one"}},
+		{name: "empty", contents: "", want: map[string]string{}},
+		{name: "not hex", contents: strings.Repeat("z", 64) + "\n", fail: true},
+		{name: "short hash", contents: hash1[:62] + "\n", fail: true},
+		{name: "SHA1 hash", contents: "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8\n", fail: true},
+		{name: "tab separator", contents: hash1 + "\tone\n", fail: true},
+		{name: "key instead of hash", contents: hash1 + "\nkey2\n", fail: true},
+	}
+
+	for _, c := range cases {
+		fileName := filepath.Join(t.TempDir(), "keys.txt")
+		if err := os.WriteFile(fileName, []byte(c.contents), 0600); err != nil {
+			t.Fatalf("Should not fail writing file: %s", err)
+		}
+
+		keys := make(map[string]string)
+		err := readKeysFile(fileName, keys)
+		if c.fail {
+			if err == nil {
+				t.Errorf("%s: should fail", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: should not fail: %s", c.name, err)
+			continue
+		}
+		if !maps.Equal(keys, c.want) {
+			t.Errorf("%s: keys %v, want: %v", c.name, keys, c.want)
+		}
+	}
+
+	if err := readKeysFile(filepath.Join(t.TempDir(), "missing.txt"), map[string]string{}); err == nil {
+		t.Errorf("Should fail reading a missing file")
+	}
+}
+
+func TestAPIKeys_Reload(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "keys.txt")
+	writeKeysFile(t, fileName, HashAPIKey("key1")+" one\n")
+
+	keys, err := NewAPIKeys(fileName, []string{HashAPIKey("static")})
+	if err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+	checkKeys(t, keys, map[string]string{"key1": "one", "static": HashAPIKey("static")[:8]}, "key2")
+
+	// The new key replaces the old one
+	writeKeysFile(t, fileName, HashAPIKey("key2")+" two\n")
+	if err = keys.Reload(); err != nil {
+		t.Fatalf("Should not fail reloading keys: %s", err)
+	}
+	checkKeys(t, keys, map[string]string{"key2": "two", "static": HashAPIKey("static")[:8]}, "key1")
+
+	// Bad reloads keep the current keys
+	writeKeysFile(t, fileName, HashAPIKey("key3")+" three\nnot-a-hash\n")
+	if err = keys.Reload(); err == nil {
+		t.Errorf("Reload should fail with an invalid keys file")
+	}
+	checkKeys(t, keys, map[string]string{"key2": "two"}, "key3")
+
+	if err = os.Remove(fileName); err != nil {
+		t.Fatalf("Should not fail removing file: %s", err)
+	}
+	if err = keys.Reload(); err == nil {
+		t.Errorf("Reload should fail without the keys file")
+	}
+	checkKeys(t, keys, map[string]string{"key2": "two"}, "key3")
+}
+
+func TestNewAPIKeys_Invalid(t *testing.T) {
+	if _, err := NewAPIKeys("", []string{"abc"}); err == nil {
+		t.Errorf("Should fail with an invalid hash")
+	}
+
+	// A server with keys enabled but none allowed would reject everything
+	fileName := filepath.Join(t.TempDir(), "keys.txt")
+	writeKeysFile(t, fileName, "# no keys yet\n")
+	if _, err := NewAPIKeys(fileName, nil); err == nil {
+		t.Errorf("Should fail without keys")
+	}
+}
+
+func TestAPIKeys_Watch(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "keys.txt")
+	writeKeysFile(t, fileName, HashAPIKey("key1")+"\n")
+
+	keys, err := NewAPIKeys(fileName, nil)
+	if err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go keys.Watch(ctx, 10*time.Millisecond)
+
+	writeKeysFile(t, fileName, HashAPIKey("key2")+"\n")
+	// Changes within the resolution of the modification time are still seen
+	later := time.Now().Add(time.Second)
+	if err = os.Chtimes(fileName, later, later); err != nil {
+		t.Fatalf("Should not fail changing file times: %s", err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, ok := keys.Check("key2"); ok {
+			break
+		}
+	}
+	checkKeys(t, keys, map[string]string{"key2": HashAPIKey("key2")[:8]}, "key1")
+}
+
+func TestAPIKeys_Middleware(t *testing.T) {
+	keys, err := NewAPIKeys("", []string{HashAPIKey("key1")})
+	if err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+
+	router := gin.New()
+	router.GET("/test", keys.Middleware(), func(c *gin.Context) {
+		c.String(http.StatusOK, APIKeyName(c))
+	})
+
+	cases := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{name: "no key", status: http.StatusUnauthorized},
+		{name: "wrong key", headers: map[string]string{"X-API-Key": "key2"}, status: http.StatusUnauthorized},
+		{name: "wrong bearer", headers: map[string]string{"Authorization": "Bearer key2"}, status: http.StatusUnauthorized},
+		{name: "hash as key", headers: map[string]string{"X-API-Key": HashAPIKey("key1")}, status: http.StatusUnauthorized},
+		{name: "X-API-Key", headers: map[string]string{"X-API-Key": "key1"}, status: http.StatusOK},
+		{name: "bearer", headers: map[string]string{"Authorization": "Bearer key1"}, status: http.StatusOK},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		for name, value := range c.headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != c.status {
+			t.Errorf("%s: status %d, want: %d", c.name, w.Code, c.status)
+			continue
+		}
+
+		if c.status == http.StatusOK {
+			if w.Body.String() != HashAPIKey("key1")[:8] {
+				t.Errorf("%s: key name %q, want: %q", c.name, w.Body.String(), HashAPIKey("key1")[:8])
+			}
+			continue
+		}
+
+		if got := w.Header().Get("WWW-Authenticate"); got != "Bearer" {
+			t.Errorf("%s: WWW-Authenticate %q, want: %q", c.name, got, "Bearer")
+		}
+		var resp errorResponse
+		if err = json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Code != codeUnauthorized {
+			t.Errorf("%s: response %s, want the %s code", c.name, w.Body.String(), codeUnauthorized)
+		}
+	}
+}
+
+func TestAPIKeys_UnaryInterceptor(t *testing.T) {
+	keys, err := NewAPIKeys("", []string{HashAPIKey("key1")})
+	if err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+
+	interceptor := keys.UnaryInterceptor()
+	handler := func(ctx context.Context, req any) (any, error) {
+		return grpcAPIKeyName(ctx), nil
+	}
+
+	cases := []struct {
+		name string
+		md   metadata.MD
+		code codes.Code
+	}{
+		{name: "no key", md: metadata.MD{}, code: codes.Unauthenticated},
+		{name: "wrong key", md: metadata.Pairs("x-api-key", "key2"), code: codes.Unauthenticated},
+		{name: "x-api-key", md: metadata.Pairs("x-api-key", "key1"), code: codes.OK},
+		{name: "bearer", md: metadata.Pairs("authorization", "Bearer key1"), code: codes.OK},
+	}
+
+	for _, c := range cases {
+		ctx := metadata.NewIncomingContext(context.Background(), c.md)
+		resp, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test"}, handler)
+		if code := status.Code(err); code != c.code {
+			t.Errorf("%s: code %s, want: %s", c.name, code, c.code)
+			continue
+		}
+		if c.code == codes.OK && resp != HashAPIKey("key1")[:8] {
+			t.Errorf("%s: key name %q, want: %q", c.name, resp, HashAPIKey("key1")[:8])
+		}
+	}
+}
+
+func writeKeysFile(t *testing.T, fileName string, contents string) {
+	if err := os.WriteFile(fileName, []byte(contents), 0600); err != nil {
+		t.Fatalf("Should not fail writing file: %s", err)
+	}
+}
+
+// checkKeys checks that the keys are allowed with their names, and the denied ones are not.
+func checkKeys(t *testing.T, keys *APIKeys, allowed map[string]string, denied ...string) {
+	t.Helper()
+	for key, want := range allowed {
+		if name, ok := keys.Check(key); !ok || name != want {
+			t.Errorf("Key %s should be allowed as %q, got: %q, %v", key, want, name, ok)
+		}
+	}
+	for _, key := range denied {
+		if _, ok := keys.Check(key); ok {
+			t.Errorf("Key %s should not be allowed", key)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/alvinbaena/pwd-checker/gcs"
+	"github.com/alvinbaena/pwd-checker/internal/util"
+	"github.com/rs/zerolog/log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Database holds the GCS reader used by the API, and allows replacing it with a new one while
+// the server is running.
+type Database struct {
+	fileName string
+	metrics  *Metrics
+	current  atomic.Pointer[readerHandle]
+	// Only one reload at a time
+	rm        sync.Mutex
+	reloading atomic.Bool
+	loaded    databaseFiles
+}
+
+// databaseFiles are the GCS file and its exact sidecar, which is nil when there is none.
+type databaseFiles struct {
+	gcs, exact os.FileInfo
+}
+
+// readerHandle tracks the in-flight queries of a reader, so it's only closed after they finish.
+type readerHandle struct {
+	reader  *gcs.Reader
+	info    DatabaseInfo
+	mu      sync.RWMutex
+	retired bool
+}
+
+// DatabaseInfo describes the loaded GCS database.
+type DatabaseInfo struct {
+	FileName string
+	// Number of items in the database
+	Num uint64
+	// False positive rate, 1-in-p
+	Probability uint64
+	// Version of the file format
+	Version int
+	// Number of index points
+	IndexLen uint64
+	// If matches are confirmed with an exact sidecar
+	Exact bool
+	// Modification time of the file when it was loaded
+	ModTime  time.Time
+	LoadedAt time.Time
+}
+
+// NewDatabase creates the database of a GCS file, which is loaded by calling Reload. Until then
+// queries fail and it's not ready. The metrics are optional.
+func NewDatabase(fileName string, metrics *Metrics) *Database {
+	return &Database{fileName: fileName, metrics: metrics}
+}
+
+// Lookup queries the current reader of the database.
+func (d *Database) Lookup(hash gcs.Hash) (gcs.Result, error) {
+	h, err := d.acquire()
+	if err != nil {
+		return gcs.Result{}, err
+	}
+	defer h.mu.RUnlock()
+
+	start := time.Now()
+	result, err := h.reader.Lookup(hash)
+	if err == nil {
+		d.metrics.observeLookup(time.Since(start), result)
+	}
+
+	return result, err
+}
+
+// Info describes the current database. It only has the file name until the database is loaded.
+func (d *Database) Info() DatabaseInfo {
+	h := d.current.Load()
+	if h == nil {
+		return DatabaseInfo{FileName: d.fileName}
+	}
+
+	return h.info
+}
+
+// Ready checks that the database can answer queries: it's loaded and not being reloaded, its file
+// still exists, and the current reader can still decode its data.
+func (d *Database) Ready() error {
+	if d.reloading.Load() {
+		return fmt.Errorf("GCS database is being loaded")
+	}
+
+	if _, err := os.Stat(d.fileName); err != nil {
+		return fmt.Errorf("GCS database file is not available: %s", err)
+	}
+
+	h, err := d.acquire()
+	if err != nil {
+		return err
+	}
+	defer h.mu.RUnlock()
+
+	if err = h.reader.Check(); err != nil {
+		return fmt.Errorf("GCS database can't be queried: %s", err)
+	}
+
+	return nil
+}
+
+func (d *Database) acquire() (*readerHandle, error) {
+	for {
+		h := d.current.Load()
+		if h == nil {
+			return nil, fmt.Errorf("GCS database is not loaded")
+		}
+
+		h.mu.RLock()
+		if !h.retired {
+			return h, nil
+		}
+		h.mu.RUnlock()
+
+		// Retired without a new reader swapped in.
+		if d.current.Load() == h {
+			return nil, fmt.Errorf("GCS database is closed")
+		}
+	}
+}
+
+// Reload initializes a new reader for the database file, validates it, and swaps it in. The old
+// reader is closed once all of its in-flight queries finish. If the new file is not valid the
+// current reader is kept.
+func (d *Database) Reload() error {
+	d.rm.Lock()
+	defer d.rm.Unlock()
+
+	d.reloading.Store(true)
+	defer d.reloading.Store(false)
+
+	files, err := d.stat()
+	if err != nil {
+		return err
+	}
+
+	opts := []gcs.Option{gcs.WithLogger(log.Logger), gcs.WithCacheObserver(d.metrics.observeCache)}
+	// The exact sidecar is optional, and used if it's next to the GCS file
+	if files.exact != nil {
+		opts = append(opts, gcs.WithExactSidecar(gcs.SidecarFileName(d.fileName)))
+	}
+
+	reader, err := gcs.NewReader(d.fileName, opts...)
+	if err != nil {
+		return err
+	}
+
+	if err = reader.Initialize(); err != nil {
+		_ = reader.Close()
+		return err
+	}
+
+	if err = validateReader(reader); err != nil {
+		_ = reader.Close()
+		return err
+	}
+
+	old := d.current.Swap(&readerHandle{
+		reader: reader,
+		info: DatabaseInfo{
+			FileName:    d.fileName,
+			Num:         reader.Num(),
+			Probability: reader.Probability(),
+			Version:     reader.Version(),
+			IndexLen:    reader.IndexLen(),
+			Exact:       reader.HasExactSidecar(),
+			ModTime:     files.gcs.ModTime(),
+			LoadedAt:    time.Now(),
+		},
+	})
+	d.loaded = files
+
+	if old != nil {
+		go retire(old)
+	}
+
+	return nil
+}
+
+// validateReader checks that the reader can decode data by querying the lowest and highest
+// possible values.
+func validateReader(reader *gcs.Reader) error {
+	if err := reader.Check(); err != nil {
+		return fmt.Errorf("invalid GCS database: %s", err)
+	}
+
+	return nil
+}
+
+func retire(h *readerHandle) {
+	// Waits for the in-flight queries
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.retired = true
+	if err := h.reader.Close(); err != nil {
+		log.Warn().Err(err).Msg("error closing previous GCS database")
+	}
+	log.Debug().Msg("previous GCS database closed")
+}
+
+// Watch polls the database file and its exact sidecar every interval, and reloads the database
+// when either changes. A change is only applied once the files stay the same for a whole interval,
+// so files that are still being copied are not loaded.
+func (d *Database) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// previous are the files as seen on the last tick, failed the last ones that could not be loaded.
+	var previous, failed *databaseFiles
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			files, err := d.stat()
+			if err != nil {
+				log.Warn().Err(err).Msgf("error checking GCS file %s", d.fileName)
+				previous = nil
+				continue
+			}
+
+			if !d.changed(files) || (failed != nil && failed.same(files)) {
+				previous = nil
+				continue
+			}
+
+			if previous != nil && previous.same(files) {
+				log.Info().Msgf("GCS file %s or its exact sidecar changed, reloading database", d.fileName)
+				if err = d.Reload(); err != nil {
+					log.Error().Err(err).Msg("error reloading GCS database, keeping the current one")
+					failed = &files
+				} else {
+					log.Info().Msg("GCS database reloaded")
+				}
+				previous = nil
+				continue
+			}
+
+			previous = &files
+		}
+	}
+}
+
+// stat gets the file info of the GCS file, and of its exact sidecar if there is one.
+func (d *Database) stat() (databaseFiles, error) {
+	info, err := os.Stat(d.fileName)
+	if err != nil {
+		return databaseFiles{}, err
+	}
+
+	files := databaseFiles{gcs: info}
+	if info, err = os.Stat(gcs.SidecarFileName(d.fileName)); err == nil {
+		files.exact = info
+	} else if !os.IsNotExist(err) {
+		return databaseFiles{}, err
+	}
+
+	return files, nil
+}
+
+func (d *Database) changed(files databaseFiles) bool {
+	d.rm.Lock()
+	defer d.rm.Unlock()
+
+	return !d.loaded.same(files)
+}
+
+func (f databaseFiles) same(other databaseFiles) bool {
+	if (f.exact == nil) != (other.exact == nil) {
+		return false
+	}
+
+	return util.SameFileInfo(f.gcs, other.gcs) && (f.exact == nil || util.SameFileInfo(f.exact, other.exact))
+}
+
+// Close closes the current reader of the database.
+func (d *Database) Close() error {
+	h := d.current.Load()
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.retired = true
+	return h.reader.Close()
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// Codes of the JSON errors of the API, listed in the Error schema of openapi.json. Clients should
+// check them instead of the messages.
+const (
+	codeInvalidRequest  = "invalid_request"
+	codeInvalidHash     = "invalid_hash"
+	codeUnauthorized    = "unauthorized"
+	codeRateLimited     = "rate_limited"
+	codeTooManyRequests = "too_many_concurrent_requests"
+	codeNotFound        = "not_found"
+	codeNotReady        = "not_ready"
+	codeInternalError   = "internal_error"
+)
+
+// abortWithError stops the request with a JSON error.
+func abortWithError(c *gin.Context, status int, code string, message string, details ...string) {
+	c.AbortWithStatusJSON(status, errorResponse{Code: code, Error: message, Details: details})
+}
+
+// NotFound answers the requests of unknown routes with a JSON error.
+func NotFound(c *gin.Context) {
+	abortWithError(c, http.StatusNotFound, codeNotFound, "route not found")
+}
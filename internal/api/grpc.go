@@ -0,0 +1,128 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alvinbaena/pwd-checker/gcs"
+	pb "github.com/alvinbaena/pwd-checker/proto/pwdchecker/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+)
+
+// errInvalidQuery is wrapped by the errors of requests that can't be checked.
+var errInvalidQuery = errors.New("invalid query")
+
+type grpcApi struct {
+	pb.UnimplementedPwdCheckerServer
+	db *Database
+}
+
+func (g *grpcApi) CheckHash(_ context.Context, req *pb.CheckHashRequest) (*pb.CheckResponse, error) {
+	resp, err := g.checkHash(req.GetHash())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return resp, nil
+}
+
+func (g *grpcApi) CheckPassword(_ context.Context, req *pb.CheckPasswordRequest) (*pb.CheckResponse, error) {
+	resp, err := g.checkPassword(req.GetPassword())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return resp, nil
+}
+
+// CheckBatch answers each request of the stream as it arrives, in the same order.
+func (g *grpcApi) CheckBatch(stream pb.PwdChecker_CheckBatchServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var result *pb.CheckResponse
+		switch query := req.GetQuery().(type) {
+		case *pb.CheckBatchRequest_Hash:
+			result, err = g.checkHash(query.Hash)
+		case *pb.CheckBatchRequest_Password:
+			result, err = g.checkPassword(query.Password)
+		default:
+			err = fmt.Errorf("%w: hash or password is required", errInvalidQuery)
+		}
+
+		resp := &pb.CheckBatchResponse{Id: req.GetId(), Result: result}
+		if err != nil {
+			// The database failing is not a problem of the request, so the stream ends
+			if !errors.Is(err, errInvalidQuery) {
+				return grpcError(err)
+			}
+			resp.Error = err.Error()
+		}
+
+		if err = stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (g *grpcApi) checkHash(hex string) (*pb.CheckResponse, error) {
+	hash, err := gcs.HashFromHex(hex)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidQuery, err)
+	}
+
+	result, err := g.db.Lookup(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.CheckResponse{Pwned: result.Found, Exact: result.Exact}, nil
+}
+
+func (g *grpcApi) checkPassword(password string) (*pb.CheckResponse, error) {
+	if password == "" {
+		return nil, fmt.Errorf("%w: password is required", errInvalidQuery)
+	}
+
+	result, err := g.db.Lookup(gcs.HashFromPassword(password))
+	if err != nil {
+		return nil, err
+	}
+
+	s := strength(password)
+	g.db.metrics.observeScore(s.Score)
+	return &pb.CheckResponse{
+		Pwned: result.Found,
+		Exact: result.Exact,
+		Strength: &pb.PasswordStrength{
+			CrackTime:        s.CrackTime,
+			CrackTimeDisplay: s.CrackTimeDisplay,
+			Score:            int32(s.Score),
+		},
+	}, nil
+}
+
+func grpcError(err error) error {
+	if errors.Is(err, errInvalidQuery) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
+
+// RegisterGrpcApi registers the gRPC service, which queries the same database as the REST API.
+func RegisterGrpcApi(srv *grpc.Server, db *Database) {
+	pb.RegisterPwdCheckerServer(srv, &grpcApi{db: db})
+}
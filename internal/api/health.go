@@ -0,0 +1,63 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type healthApi struct {
+	db      *Database
+	version string
+}
+
+// healthz answers while the process is up, even if the database is not loaded yet.
+func (h *healthApi) healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// readyz answers with 503 while the database can't be queried.
+func (h *healthApi) readyz(c *gin.Context) {
+	if err := h.db.Ready(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, healthResponse{Status: "not ready", Error: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, healthResponse{Status: "ready"})
+}
+
+func (h *healthApi) info(c *gin.Context) {
+	info := h.db.Info()
+	if info.LoadedAt.IsZero() {
+		abortWithError(c, http.StatusServiceUnavailable, codeNotReady, "GCS database is not loaded")
+		return
+	}
+
+	c.JSON(http.StatusOK, infoResponse{
+		Items:         info.Num,
+		Probability:   info.Probability,
+		Version:       info.Version,
+		IndexPoints:   info.IndexLen,
+		Exact:         info.Exact,
+		ModTime:       info.ModTime,
+		LoadedAt:      info.LoadedAt,
+		ServerVersion: h.version,
+	})
+}
+
+// RegisterHealthApi registers the liveness and readiness endpoints, /healthz and /readyz.
+func RegisterHealthApi(group *gin.RouterGroup, db *Database) {
+	h := &healthApi{db: db}
+
+	group.GET("/healthz", h.healthz)
+	group.GET("/readyz", h.readyz)
+}
+
+// RegisterInfoApi registers the endpoint describing the database and the server.
+func RegisterInfoApi(group *gin.RouterGroup, db *Database, version string) {
+	h := &healthApi{db: db, version: version}
+
+	group.GET("/info", h.info)
+}
@@ -0,0 +1,385 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clients that made no requests for this long are forgotten, their buckets are full again anyway.
+const clientIdleTime = 5 * time.Minute
+
+// RateLimit is a token bucket: a client can make Burst requests at once, and then Rate requests per
+// second.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseRateLimit parses a limit of the form [ROUTE=]RATE[:BURST], like /v1/check/password=5:10. The
+// route is empty when it's the limit of all the routes. The burst is the rate rounded up by default.
+func ParseRateLimit(s string) (string, RateLimit, error) {
+	route, limit, found := strings.Cut(s, "=")
+	if !found {
+		route, limit = "", s
+	}
+
+	rateText, burstText, hasBurst := strings.Cut(limit, ":")
+	r, err := strconv.ParseFloat(rateText, 64)
+	if err != nil || r <= 0 || math.IsInf(r, 0) {
+		return "", RateLimit{}, fmt.Errorf("invalid rate limit %q, the rate must be a number of requests per second greater than 0", s)
+	}
+
+	burst := int(math.Ceil(r))
+	if hasBurst {
+		if burst, err = strconv.Atoi(burstText); err != nil || burst < 1 {
+			return "", RateLimit{}, fmt.Errorf("invalid rate limit %q, the burst must be a number of requests greater than 0", s)
+		}
+	}
+
+	return strings.TrimSpace(route), RateLimit{Rate: r, Burst: burst}, nil
+}
+
+type clientRoute struct {
+	client string
+	route  string
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter limits the requests of each client, by API key or IP address, to each route. It also limits
+// how many queries run at the same time, making the other requests wait for their turn or fail.
+type Limiter struct {
+	// Limit of each route, the empty route is the limit of all the others
+	limits map[string]RateLimit
+	// Tokens of the queries that can run, nil when they are not limited
+	slots        chan struct{}
+	queueTimeout time.Duration
+	// Proxies whose forwarded IP address metadata is used as the IP of gRPC calls
+	trustedProxies []*net.IPNet
+
+	mu        sync.Mutex
+	clients   map[clientRoute]*clientLimiter
+	lastSweep time.Time
+}
+
+// NewLimiter creates a limiter with the rate limits of each route. maxConcurrent is how many queries
+// can run at the same time, 0 for no limit; other queries wait up to queueTimeout for their turn.
+func NewLimiter(limits map[string]RateLimit, maxConcurrent int, queueTimeout time.Duration) *Limiter {
+	l := &Limiter{
+		limits:       limits,
+		queueTimeout: queueTimeout,
+		clients:      make(map[clientRoute]*clientLimiter),
+		lastSweep:    time.Now(),
+	}
+
+	if maxConcurrent > 0 {
+		l.slots = make(chan struct{}, maxConcurrent)
+	}
+
+	return l
+}
+
+// limiter gets the rate limiter of the client for the route, nil if the route is not limited.
+func (l *Limiter) limiter(client string, route string) *rate.Limiter {
+	limit, ok := l.limits[route]
+	if !ok {
+		if limit, ok = l.limits[""]; !ok {
+			return nil
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > clientIdleTime {
+		for key, c := range l.clients {
+			if now.Sub(c.lastSeen) > clientIdleTime {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	key := clientRoute{client: client, route: route}
+	c, ok := l.clients[key]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.Burst)}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+
+	return c.limiter
+}
+
+// allow takes a token of the bucket of the client for the route. If there are none left, it returns
+// how long until there is one.
+func (l *Limiter) allow(client string, route string) (bool, time.Duration) {
+	limiter := l.limiter(client, route)
+	if limiter == nil {
+		return true, 0
+	}
+
+	r := limiter.Reserve()
+	if delay := r.Delay(); delay > 0 {
+		r.Cancel()
+		return false, delay
+	}
+
+	return true, 0
+}
+
+// acquire waits for a query to be allowed to run, for up to the queue timeout. The release function
+// must be called once the query ends.
+func (l *Limiter) acquire(ctx context.Context) (func(), bool) {
+	if l.slots == nil {
+		return func() {}, true
+	}
+
+	release := func() { <-l.slots }
+	select {
+	case l.slots <- struct{}{}:
+		return release, true
+	default:
+	}
+
+	if l.queueTimeout <= 0 {
+		return nil, false
+	}
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, true
+	case <-timer.C:
+		return nil, false
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// retryAfter is the value of the Retry-After header, in whole seconds.
+func retryAfter(delay time.Duration) string {
+	return strconv.Itoa(max(int(math.Ceil(delay.Seconds())), 1))
+}
+
+// Middleware limits the requests by the route, and the API key of the request, or its IP address
+// when the API doesn't use keys. Requests over the limits get a 429 response with a Retry-After header.
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		client := "ip:" + c.ClientIP()
+		if name := APIKeyName(c); name != "" {
+			client = "key:" + name
+		}
+
+		if ok, delay := l.allow(client, c.FullPath()); !ok {
+			c.Header("Retry-After", retryAfter(delay))
+			abortWithError(c, http.StatusTooManyRequests, codeRateLimited, "rate limit exceeded")
+			return
+		}
+
+		release, ok := l.acquire(c.Request.Context())
+		if !ok {
+			c.Header("Retry-After", retryAfter(time.Second))
+			abortWithError(c, http.StatusTooManyRequests, codeTooManyRequests, "too many concurrent requests")
+			return
+		}
+		defer release()
+
+		c.Next()
+	}
+}
+
+// SetTrustedProxies sets the IP addresses or CIDR ranges of the proxies in front of the gRPC API. The
+// IP of the calls they make is taken from the x-forwarded-for and x-real-ip metadata, like the
+// trusted proxies of the REST router. Without them the IP is the address of the connection.
+func (l *Limiter) SetTrustedProxies(proxies []string) error {
+	trusted := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy %s", proxy)
+			}
+			if ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+
+		_, cidr, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %s: %s", proxy, err)
+		}
+		trusted = append(trusted, cidr)
+	}
+
+	l.trustedProxies = trusted
+	return nil
+}
+
+func (l *Limiter) trusted(ip net.IP) bool {
+	for _, cidr := range l.trustedProxies {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// grpcClient is the API key of the call, or its IP address when the API doesn't use keys.
+func (l *Limiter) grpcClient(ctx context.Context) string {
+	if name := grpcAPIKeyName(ctx); name != "" {
+		return "key:" + name
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "ip:"
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return "ip:" + p.Addr.String()
+	}
+
+	if ip := net.ParseIP(host); ip != nil && l.trusted(ip) {
+		if forwarded, ok := l.forwardedIP(ctx); ok {
+			return "ip:" + forwarded
+		}
+	}
+
+	return "ip:" + host
+}
+
+// forwardedIP is the client IP of the forwarded metadata of a trusted proxy. Like gin, the
+// x-forwarded-for addresses are read from the right, skipping the trusted proxies.
+func (l *Limiter) forwardedIP(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	if values := md.Get("x-forwarded-for"); len(values) > 0 {
+		items := strings.Split(strings.Join(values, ","), ",")
+		for i := len(items) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(items[i]))
+			if ip == nil {
+				break
+			}
+			if i == 0 || !l.trusted(ip) {
+				return ip.String(), true
+			}
+		}
+	}
+
+	if values := md.Get("x-real-ip"); len(values) > 0 {
+		if ip := net.ParseIP(strings.TrimSpace(values[0])); ip != nil {
+			return ip.String(), true
+		}
+	}
+
+	return "", false
+}
+
+// UnaryInterceptor limits the gRPC calls like the REST requests, the route is the full method name
+// like /pwdchecker.v1.PwdChecker/CheckHash. Calls over the limits fail with RESOURCE_EXHAUSTED and a
+// retry-after header.
+func (l *Limiter) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if ok, delay := l.allow(l.grpcClient(ctx), info.FullMethod); !ok {
+			_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter(delay)))
+			return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+		}
+
+		release, ok := l.acquire(ctx)
+		if !ok {
+			_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter(time.Second)))
+			return nil, status.Error(codes.ResourceExhausted, "too many concurrent requests")
+		}
+		defer release()
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor limits each message of a gRPC stream. Instead of failing, a message over the
+// limits waits until it's allowed, slowing down the stream.
+func (l *Limiter) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ls := &limitedStream{
+			ServerStream: ss,
+			limiter:      l,
+			rate:         l.limiter(l.grpcClient(ss.Context()), info.FullMethod),
+		}
+		defer ls.releaseSlot()
+
+		return handler(srv, ls)
+	}
+}
+
+// limitedStream takes a token and a query slot when a message is received, holding the slot until
+// the next message is requested, which is when the previous one has been answered.
+type limitedStream struct {
+	grpc.ServerStream
+	limiter *Limiter
+	rate    *rate.Limiter
+	release func()
+}
+
+func (s *limitedStream) releaseSlot() {
+	if s.release != nil {
+		s.release()
+		s.release = nil
+	}
+}
+
+func (s *limitedStream) RecvMsg(m any) error {
+	s.releaseSlot()
+
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	ctx := s.Context()
+	if s.rate != nil {
+		if err := s.rate.Wait(ctx); err != nil {
+			return status.FromContextError(err).Err()
+		}
+	}
+
+	if s.limiter.slots != nil {
+		select {
+		case s.limiter.slots <- struct{}{}:
+			s.release = func() { <-s.limiter.slots }
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,355 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	cases := []struct {
+		name  string
+		s     string
+		route string
+		limit RateLimit
+		fail  bool
+	}{
+		{name: "rate", s: "5", limit: RateLimit{Rate: 5, Burst: 5}},
+		{name: "fractional rate", s: "0.5", limit: RateLimit{Rate: 0.5, Burst: 1}},
+		{name: "burst", s: "5:10", limit: RateLimit{Rate: 5, Burst: 10}},
+		{name: "route", s: "/v1/check/password=2.5", route: "/v1/check/password", limit: RateLimit{Rate: 2.5, Burst: 3}},
+		{name: "route and burst", s: "/v1/check/password=5:1", route: "/v1/check/password", limit: RateLimit{Rate: 5, Burst: 1}},
+		{name: "empty", s: "", fail: true},
+		{name: "not a number", s: "fast", fail: true},
+		{name: "zero rate", s: "0", fail: true},
+		{name: "negative rate", s: "-1", fail: true},
+		{name: "infinite rate", s: "Inf", fail: true},
+		{name: "no rate", s: "/v1/check/password=", fail: true},
+		{name: "zero burst", s: "5:0", fail: true},
+		{name: "invalid burst", s: "5:many", fail: true},
+	}
+
+	for _, c := range cases {
+		route, limit, err := ParseRateLimit(c.s)
+		if c.fail {
+			if err == nil {
+				t.Errorf("%s: should fail", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: should not fail: %s", c.name, err)
+			continue
+		}
+		if route != c.route || limit != c.limit {
+			t.Errorf("%s: limit %q %+v, want: %q %+v", c.name, route, limit, c.route, c.limit)
+		}
+	}
+}
+
+func TestLimiter_Limits(t *testing.T) {
+	l := NewLimiter(map[string]RateLimit{
+		"":      {Rate: 1, Burst: 2},
+		"/fast": {Rate: 1, Burst: 4},
+	}, 0, 0)
+
+	cases := []struct {
+		name    string
+		client  string
+		route   string
+		allowed int
+	}{
+		{name: "route", client: "a", route: "/fast", allowed: 4},
+		{name: "default", client: "a", route: "/slow", allowed: 2},
+		{name: "other default route", client: "a", route: "/other", allowed: 2},
+		{name: "other client", client: "b", route: "/fast", allowed: 4},
+	}
+
+	for _, c := range cases {
+		for n := 0; n < c.allowed; n++ {
+			if ok, _ := l.allow(c.client, c.route); !ok {
+				t.Errorf("%s: request %d should be allowed", c.name, n+1)
+			}
+		}
+
+		ok, delay := l.allow(c.client, c.route)
+		if ok {
+			t.Errorf("%s: request %d should not be allowed", c.name, c.allowed+1)
+		}
+		if delay <= 0 || delay > time.Second {
+			t.Errorf("%s: delay %s, want up to 1s", c.name, delay)
+		}
+	}
+
+	// Without a default limit other routes are not limited
+	l = NewLimiter(map[string]RateLimit{"/fast": {Rate: 1, Burst: 1}}, 0, 0)
+	if l.limiter("a", "/slow") != nil {
+		t.Errorf("Route without a limit should not have a rate limiter")
+	}
+	for n := 0; n < 10; n++ {
+		if ok, _ := l.allow("a", "/slow"); !ok {
+			t.Fatalf("Request %d of a route without a limit should be allowed", n+1)
+		}
+	}
+}
+
+func TestLimiter_Sweep(t *testing.T) {
+	l := NewLimiter(map[string]RateLimit{"": {Rate: 1, Burst: 1}}, 0, 0)
+	l.limiter("idle", "/")
+	l.limiter("active", "/")
+
+	l.clients[clientRoute{client: "idle", route: "/"}].lastSeen = time.Now().Add(-2 * clientIdleTime)
+	l.lastSweep = time.Now().Add(-2 * clientIdleTime)
+	l.limiter("new", "/")
+
+	if _, ok := l.clients[clientRoute{client: "idle", route: "/"}]; ok {
+		t.Errorf("Idle client should be forgotten")
+	}
+	for _, client := range []string{"active", "new"} {
+		if _, ok := l.clients[clientRoute{client: client, route: "/"}]; !ok {
+			t.Errorf("Client %s should not be forgotten", client)
+		}
+	}
+}
+
+func TestLimiter_Middleware(t *testing.T) {
+	l := NewLimiter(map[string]RateLimit{"/test": {Rate: 0.5, Burst: 2}}, 0, 0)
+
+	router := gin.New()
+	router.GET("/test", l.Middleware(), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	request := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for n := 0; n < 2; n++ {
+		if w := request("192.0.2.1"); w.Code != http.StatusNoContent {
+			t.Fatalf("Request %d should be allowed, status: %d", n+1, w.Code)
+		}
+	}
+
+	w := request("192.0.2.1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Request after the burst should fail with status %d, got: %d", http.StatusTooManyRequests, w.Code)
+	}
+	// A token every 2 seconds
+	if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry < 1 || retry > 2 {
+		t.Errorf("Retry-After %q, want 1 or 2 seconds", w.Header().Get("Retry-After"))
+	}
+	var resp errorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Code != codeRateLimited {
+		t.Errorf("Response %s, want the %s code", w.Body.String(), codeRateLimited)
+	}
+
+	// Each IP has its own bucket
+	if w = request("192.0.2.2"); w.Code != http.StatusNoContent {
+		t.Errorf("Request of another client should be allowed, status: %d", w.Code)
+	}
+}
+
+func TestLimiter_Acquire(t *testing.T) {
+	l := NewLimiter(nil, 2, 50*time.Millisecond)
+
+	var releases []func()
+	for n := 0; n < 2; n++ {
+		release, ok := l.acquire(context.Background())
+		if !ok {
+			t.Fatalf("Query %d should be allowed", n+1)
+		}
+		releases = append(releases, release)
+	}
+
+	start := time.Now()
+	if _, ok := l.acquire(context.Background()); ok {
+		t.Fatalf("Query over the limit should not be allowed")
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("Query over the limit waited %s, want the queue timeout", waited)
+	}
+
+	// The context ends the wait before the timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l.queueTimeout = time.Hour
+	if _, ok := l.acquire(ctx); ok {
+		t.Errorf("Query with a cancelled context should not be allowed")
+	}
+
+	// A query waiting in the queue runs once another ends
+	done := make(chan bool)
+	go func() {
+		_, ok := l.acquire(context.Background())
+		done <- ok
+	}()
+	time.Sleep(10 * time.Millisecond)
+	releases[0]()
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Errorf("Waiting query should be allowed once a slot is released")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Waiting query should run once a slot is released")
+	}
+
+	// Without a queue timeout queries over the limit fail right away
+	l = NewLimiter(nil, 1, 0)
+	if _, ok := l.acquire(context.Background()); !ok {
+		t.Fatalf("Query should be allowed")
+	}
+	if _, ok := l.acquire(context.Background()); ok {
+		t.Errorf("Query over the limit should not be allowed")
+	}
+}
+
+func TestLimiter_Middleware_Concurrent(t *testing.T) {
+	l := NewLimiter(nil, 1, 0)
+	release, ok := l.acquire(context.Background())
+	if !ok {
+		t.Fatalf("Query should be allowed")
+	}
+
+	router := gin.New()
+	router.GET("/test", l.Middleware(), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Request over the limit should fail with status %d and Retry-After, got: %d", http.StatusTooManyRequests, w.Code)
+	}
+	var resp errorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Code != codeTooManyRequests {
+		t.Errorf("Response %s, want the %s code", w.Body.String(), codeTooManyRequests)
+	}
+
+	release()
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Request should be allowed once the slot is released, status: %d", w.Code)
+	}
+	if len(l.slots) != 0 {
+		t.Errorf("Request should release its slot")
+	}
+}
+
+// testStream is a server stream that receives messages until the context ends.
+type testStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testStream) RecvMsg(any) error {
+	return s.ctx.Err()
+}
+
+func TestLimitedStream_RecvMsg(t *testing.T) {
+	l := NewLimiter(nil, 1, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &limitedStream{ServerStream: &testStream{ctx: ctx}, limiter: l}
+
+	// Each message holds the slot until the next one is requested
+	for n := 0; n < 3; n++ {
+		if err := s.RecvMsg(nil); err != nil {
+			t.Fatalf("Message %d should be received: %s", n+1, err)
+		}
+		if len(l.slots) != 1 {
+			t.Fatalf("Message %d should hold the slot", n+1)
+		}
+		if _, ok := l.acquire(ctx); ok {
+			t.Fatalf("Query should not be allowed while message %d is answered", n+1)
+		}
+	}
+
+	// The slot is released when the stream ends
+	cancel()
+	if err := s.RecvMsg(nil); err == nil {
+		t.Errorf("Message should not be received after the stream ends")
+	}
+	if len(l.slots) != 0 {
+		t.Errorf("Ended stream should release the slot")
+	}
+}
+
+func TestLimiter_GrpcClient(t *testing.T) {
+	l := NewLimiter(nil, 0, 0)
+	if err := l.SetTrustedProxies([]string{"10.0.0.1", "192.168.0.0/16", "::1"}); err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+
+	cases := []struct {
+		name string
+		peer string
+		md   metadata.MD
+		want string
+	}{
+		{name: "untrusted peer", peer: "203.0.113.1:1234", want: "ip:203.0.113.1"},
+		{name: "spoofed x-forwarded-for", peer: "203.0.113.1:1234", md: metadata.Pairs("x-forwarded-for", "198.51.100.1"), want: "ip:203.0.113.1"},
+		{name: "spoofed x-real-ip", peer: "203.0.113.1:1234", md: metadata.Pairs("x-real-ip", "198.51.100.1"), want: "ip:203.0.113.1"},
+		{name: "trusted peer", peer: "10.0.0.1:1234", md: metadata.Pairs("x-forwarded-for", "198.51.100.1"), want: "ip:198.51.100.1"},
+		{name: "trusted IPv6 peer", peer: "[::1]:1234", md: metadata.Pairs("x-forwarded-for", "2001:db8::1"), want: "ip:2001:db8::1"},
+		{name: "trusted peer without metadata", peer: "10.0.0.1:1234", want: "ip:10.0.0.1"},
+		{name: "trusted hops", peer: "10.0.0.1:1234", md: metadata.Pairs("x-forwarded-for", "198.51.100.1, 198.51.100.2, 192.168.1.1, 10.0.0.1"), want: "ip:198.51.100.2"},
+		{name: "spoofed first hop", peer: "10.0.0.1:1234", md: metadata.Pairs("x-forwarded-for", "198.51.100.9", "x-forwarded-for", "198.51.100.1, 192.168.1.1"), want: "ip:198.51.100.1"},
+		{name: "only trusted hops", peer: "10.0.0.1:1234", md: metadata.Pairs("x-forwarded-for", "192.168.1.2, 192.168.1.1"), want: "ip:192.168.1.2"},
+		{name: "invalid hop", peer: "10.0.0.1:1234", md: metadata.Pairs("x-forwarded-for", "198.51.100.1, unknown, 192.168.1.1", "x-real-ip", "198.51.100.3"), want: "ip:198.51.100.3"},
+		{name: "x-real-ip", peer: "10.0.0.1:1234", md: metadata.Pairs("x-real-ip", " 198.51.100.1 "), want: "ip:198.51.100.1"},
+		{name: "invalid x-real-ip", peer: "10.0.0.1:1234", md: metadata.Pairs("x-real-ip", "unknown"), want: "ip:10.0.0.1"},
+	}
+
+	for _, c := range cases {
+		addr, err := net.ResolveTCPAddr("tcp", c.peer)
+		if err != nil {
+			t.Fatalf("%s: should not fail: %s", c.name, err)
+		}
+
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+		if c.md != nil {
+			ctx = metadata.NewIncomingContext(ctx, c.md)
+		}
+		if got := l.grpcClient(ctx); got != c.want {
+			t.Errorf("%s: client %s, want: %s", c.name, got, c.want)
+		}
+	}
+
+	// The API key is the client, no matter the IP
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("203.0.113.1"), Port: 1234}})
+	ctx = context.WithValue(ctx, apiKeyContext{}, "service")
+	if got := l.grpcClient(ctx); got != "key:service" {
+		t.Errorf("Client %s, want: key:service", got)
+	}
+}
+
+func TestLimiter_SetTrustedProxies(t *testing.T) {
+	l := NewLimiter(nil, 0, 0)
+	for _, proxies := range [][]string{{"proxy.local"}, {"10.0.0.1/33"}, {"10.0.0.1", "::1/129"}} {
+		if err := l.SetTrustedProxies(proxies); err == nil {
+			t.Errorf("%v: should fail", proxies)
+		}
+	}
+}
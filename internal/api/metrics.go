@@ -0,0 +1,220 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package api
+
+import (
+	"context"
+	"github.com/alvinbaena/pwd-checker/gcs"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"time"
+)
+
+const metricsNamespace = "pwdchecker"
+
+// Metrics are the Prometheus metrics of the server. A Database with nil Metrics records nothing.
+type Metrics struct {
+	registry       *prometheus.Registry
+	httpRequests   *prometheus.CounterVec
+	httpDuration   *prometheus.HistogramVec
+	grpcRequests   *prometheus.CounterVec
+	grpcDuration   *prometheus.HistogramVec
+	checks         *prometheus.CounterVec
+	passwordScores *prometheus.CounterVec
+	lookupDuration prometheus.Histogram
+	cacheLookups   *prometheus.CounterVec
+}
+
+// NewMetrics creates the metrics of the server, with the Go runtime and process metrics, like the
+// open file descriptors.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time to answer the HTTP requests by route and method.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"route", "method"}),
+		grpcRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "grpc_requests_total",
+			Help:      "gRPC calls by method and status code.",
+		}, []string{"method", "code"}),
+		grpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "grpc_request_duration_seconds",
+			Help:      "Time to answer the gRPC calls by method.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"method"}),
+		checks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "checks_total",
+			Help:      "Hashes and passwords checked, by if they were pwned and if the result was exact.",
+		}, []string{"pwned", "exact"}),
+		passwordScores: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "password_score_total",
+			Help:      "Passwords checked by zxcvbn strength score, from 0 to 4.",
+		}, []string{"score"}),
+		lookupDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "lookup_duration_seconds",
+			Help:      "Time to look up a hash in the GCS database, including the cache and the exact sidecar.",
+			Buckets:   prometheus.ExponentialBuckets(0.000001, 4, 10),
+		}),
+		cacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cache_lookups_total",
+			Help:      "Queries of the GCS database cache, by result (hit or miss).",
+		}, []string{"result"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.grpcRequests,
+		m.grpcDuration,
+		m.checks,
+		m.passwordScores,
+		m.lookupDuration,
+		m.cacheLookups,
+	)
+
+	return m
+}
+
+// RegisterDatabase adds the metrics of the loaded database: its number of items, false positive
+// rate, and file age.
+func (m *Metrics) RegisterDatabase(db *Database) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "database_items",
+			Help:      "Number of items (N) in the GCS database.",
+		}, func() float64 {
+			return float64(db.Info().Num)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "database_probability",
+			Help:      "False positive rate (P) of the GCS database, 1-in-P.",
+		}, func() float64 {
+			return float64(db.Info().Probability)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "database_exact",
+			Help:      "1 if the matches of the GCS database are confirmed with an exact sidecar.",
+		}, func() float64 {
+			if db.Info().Exact {
+				return 1
+			}
+			return 0
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "database_file_age_seconds",
+			Help:      "Time since the loaded GCS database file was modified.",
+		}, func() float64 {
+			return time.Since(db.Info().ModTime).Seconds()
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "database_loaded_timestamp_seconds",
+			Help:      "Unix time when the GCS database was loaded.",
+		}, func() float64 {
+			return float64(db.Info().LoadedAt.Unix())
+		}),
+	)
+}
+
+// Handler serves the metrics in the Prometheus format.
+func (m *Metrics) Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry}))
+}
+
+// Middleware counts the HTTP requests and their time by route.
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		m.httpRequests.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+		m.httpDuration.WithLabelValues(route, c.Request.Method).Observe(time.Since(start).Seconds())
+	}
+}
+
+// UnaryInterceptor counts the gRPC calls and their time by method.
+func (m *Metrics) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.observeGrpc(info.FullMethod, start, err)
+
+		return resp, err
+	}
+}
+
+// StreamInterceptor counts the gRPC streams and their time by method.
+func (m *Metrics) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		m.observeGrpc(info.FullMethod, start, err)
+
+		return err
+	}
+}
+
+func (m *Metrics) observeGrpc(method string, start time.Time, err error) {
+	m.grpcRequests.WithLabelValues(method, status.Code(err).String()).Inc()
+	m.grpcDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+func (m *Metrics) observeLookup(duration time.Duration, result gcs.Result) {
+	if m == nil {
+		return
+	}
+
+	m.lookupDuration.Observe(duration.Seconds())
+	m.checks.WithLabelValues(strconv.FormatBool(result.Found), strconv.FormatBool(result.Exact)).Inc()
+}
+
+func (m *Metrics) observeScore(score int) {
+	if m == nil {
+		return
+	}
+
+	m.passwordScores.WithLabelValues(strconv.Itoa(score)).Inc()
+}
+
+func (m *Metrics) observeCache(hit bool) {
+	if m == nil {
+		return
+	}
+
+	if hit {
+		m.cacheLookups.WithLabelValues("hit").Inc()
+	} else {
+		m.cacheLookups.WithLabelValues("miss").Inc()
+	}
+}
@@ -3,12 +3,16 @@
 
 package api
 
+import "time"
+
 type queryRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
 type queryResponse struct {
-	Pwned    bool              `json:"pwned"`
+	Pwned bool `json:"pwned"`
+	// Exact is false when pwned is only probable, it may be a false positive.
+	Exact    bool              `json:"exact"`
 	Strength *passwordStrength `json:"strength,omitempty"`
 }
 
@@ -22,3 +26,32 @@ type passwordStrength struct {
 	CrackTimeDisplay string  `json:"crackTimeDisplay"`
 	Score            int     `json:"score"`
 }
+
+type healthResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type infoResponse struct {
+	// Number of hashes (N)
+	Items uint64 `json:"items"`
+	// False positive rate, 1-in-probability (P)
+	Probability uint64 `json:"probability"`
+	// File format version, 0 with a flat index and 1 with a compact index
+	Version     int    `json:"version"`
+	IndexPoints uint64 `json:"indexPoints"`
+	// If matches are confirmed with an exact sidecar
+	Exact         bool      `json:"exact"`
+	ModTime       time.Time `json:"modTime"`
+	LoadedAt      time.Time `json:"loadedAt"`
+	ServerVersion string    `json:"serverVersion"`
+}
+
+// errorResponse is the body of the JSON errors of the API. Code is stable and meant for clients,
+// Error is a message for people.
+type errorResponse struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
+	// What is wrong with the request, for invalid_request errors
+	Details []string `json:"details,omitempty"`
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package api
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+// openAPISpec documents the REST API. It must be updated with the routes and model.go.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// Validator checks the requests against the OpenAPI document of the API.
+type Validator struct {
+	doc *openapi3.T
+}
+
+// NewValidator loads the OpenAPI document of the API, failing if it's not valid.
+func NewValidator() (*Validator, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	if err != nil {
+		return nil, fmt.Errorf("error loading OpenAPI document: %s", err)
+	}
+
+	if err = doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %s", err)
+	}
+
+	return &Validator{doc: doc}, nil
+}
+
+// Middleware rejects the requests that don't match the operation of their route with an
+// invalid_request error listing what is wrong. Routes missing from the document are not checked.
+func (v *Validator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if details := v.validate(c); len(details) > 0 {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "request does not match the API schema", details...)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// TextMiddleware is Middleware for the routes with plain text errors, like the Pwned Passwords
+// compatible range API. The response lists what is wrong, one problem per line.
+func (v *Validator) TextMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if details := v.validate(c); len(details) > 0 {
+			c.String(http.StatusBadRequest, strings.Join(details, "\r\n"))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// validate checks the request against the operation of its route, returning what is wrong.
+func (v *Validator) validate(c *gin.Context) []string {
+	route := v.route(c)
+	if route == nil {
+		return nil
+	}
+
+	params := make(map[string]string, len(c.Params))
+	for _, p := range c.Params {
+		params[p.Key] = p.Value
+	}
+
+	err := openapi3filter.ValidateRequest(c.Request.Context(), &openapi3filter.RequestValidationInput{
+		Request:    c.Request,
+		PathParams: params,
+		Route:      route,
+		Options: &openapi3filter.Options{
+			MultiError: true,
+			// The API keys are checked by their own middleware
+			AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		},
+	})
+	if err != nil {
+		return validationDetails(err)
+	}
+
+	return nil
+}
+
+// route finds the operation of the gin route of the request, /range/:prefix being /range/{prefix}.
+func (v *Validator) route(c *gin.Context) *routers.Route {
+	segments := strings.Split(c.FullPath(), "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+
+	path := strings.Join(segments, "/")
+	item := v.doc.Paths.Value(path)
+	if item == nil {
+		return nil
+	}
+
+	op := item.GetOperation(c.Request.Method)
+	if op == nil {
+		return nil
+	}
+
+	return &routers.Route{Spec: v.doc, Path: path, PathItem: item, Method: c.Request.Method, Operation: op}
+}
+
+// validationDetails describes each problem of a request, without the values of the request, so
+// passwords don't end up in the responses or logs.
+func validationDetails(err error) []string {
+	// Only the top level list, the errors of a parameter are under its RequestError
+	if multi, ok := err.(openapi3.MultiError); ok {
+		var details []string
+		for _, e := range multi {
+			details = append(details, validationDetails(e)...)
+		}
+		return details
+	}
+
+	// Parameters are named, their schema errors have no path
+	var reqErr *openapi3filter.RequestError
+	if errors.As(err, &reqErr) && reqErr.Parameter != nil {
+		reason := reqErr.Reason
+		var schemaErr *openapi3.SchemaError
+		if errors.As(reqErr.Err, &schemaErr) {
+			reason = schemaErr.Reason
+		} else if reason == "" && reqErr.Err != nil {
+			reason = reqErr.Err.Error()
+		}
+		return []string{fmt.Sprintf("parameter %s: %s", reqErr.Parameter.Name, reason)}
+	}
+
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) {
+		if pointer := schemaErr.JSONPointer(); len(pointer) > 0 {
+			return []string{fmt.Sprintf("%s: %s", strings.Join(pointer, "."), schemaErr.Reason)}
+		}
+		return []string{schemaErr.Reason}
+	}
+
+	if errors.As(err, &reqErr) {
+		return []string{reqErr.Reason}
+	}
+
+	return []string{err.Error()}
+}
+
+// RegisterOpenAPI registers the endpoint serving the OpenAPI document of the API, /openapi.json.
+func RegisterOpenAPI(group *gin.RouterGroup) {
+	group.GET("/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", openAPISpec)
+	})
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package api
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewValidator(t *testing.T) {
+	v, err := NewValidator()
+	if err != nil {
+		t.Fatalf("Should not fail loading the embedded document: %s", err)
+	}
+
+	for _, path := range []string{"/v1/check/password", "/v1/check/hash", "/v1/info", "/range/{prefix}", "/healthz", "/readyz"} {
+		if v.doc.Paths.Value(path) == nil {
+			t.Errorf("Path %s should be documented", path)
+		}
+	}
+}
+
+// newValidatedRouter routes the check and range requests through the validator, answering the
+// valid ones with 204.
+func newValidatedRouter(t *testing.T) *gin.Engine {
+	v, err := NewValidator()
+	if err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+
+	ok := func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	}
+
+	router := gin.New()
+	v1 := router.Group("/v1", v.Middleware())
+	v1.POST("/check/password", ok)
+	v1.POST("/undocumented", ok)
+	router.Group("/", v.TextMiddleware()).GET("/range/:prefix", ok)
+
+	return router
+}
+
+func TestValidator_Middleware(t *testing.T) {
+	router := newValidatedRouter(t)
+	secret := "s3cret-" + strings.Repeat("p", 256)
+
+	cases := []struct {
+		name string
+		path string
+		body string
+		fail bool
+	}{
+		{name: "valid", path: "/v1/check/password", body: `{"password":"s3cret"}`},
+		{name: "password too long", path: "/v1/check/password", body: `{"password":"` + secret + `"}`, fail: true},
+		{name: "empty password", path: "/v1/check/password", body: `{"password":""}`, fail: true},
+		{name: "password not a string", path: "/v1/check/password", body: `{"password":["s3cret"]}`, fail: true},
+		{name: "no password", path: "/v1/check/password", body: `{"pass":"s3cret"}`, fail: true},
+		{name: "invalid JSON", path: "/v1/check/password", body: `{"password":"s3cret"`, fail: true},
+		{name: "no body", path: "/v1/check/password", body: "", fail: true},
+		{name: "undocumented route", path: "/v1/undocumented", body: `{"password":""}`},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(c.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if !c.fail {
+			if w.Code != http.StatusNoContent {
+				t.Errorf("%s: status %d, want: %d, response: %s", c.name, w.Code, http.StatusNoContent, w.Body.String())
+			}
+			continue
+		}
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want: %d", c.name, w.Code, http.StatusBadRequest)
+			continue
+		}
+		var resp errorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Code != codeInvalidRequest || len(resp.Details) == 0 {
+			t.Errorf("%s: response %s, want the %s code with details", c.name, w.Body.String(), codeInvalidRequest)
+		}
+		// The submitted password must not be echoed back
+		if strings.Contains(w.Body.String(), "s3cret") {
+			t.Errorf("%s: response should not contain the password: %s", c.name, w.Body.String())
+		}
+	}
+}
+
+func TestValidator_TextMiddleware(t *testing.T) {
+	router := newValidatedRouter(t)
+
+	cases := []struct {
+		name   string
+		prefix string
+		fail   bool
+	}{
+		{name: "upper case", prefix: "ABCDE"},
+		{name: "lower case", prefix: "abcde"},
+		{name: "not hex", prefix: "ZZZZZ", fail: true},
+		{name: "short", prefix: "ABCD", fail: true},
+		{name: "long", prefix: "ABCDEF", fail: true},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/range/"+c.prefix, nil))
+
+		if !c.fail {
+			if w.Code != http.StatusNoContent {
+				t.Errorf("%s: status %d, want: %d, response: %s", c.name, w.Code, http.StatusNoContent, w.Body.String())
+			}
+			continue
+		}
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want: %d", c.name, w.Code, http.StatusBadRequest)
+			continue
+		}
+		if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+			t.Errorf("%s: content type %s, want: text/plain", c.name, contentType)
+		}
+		if !strings.HasPrefix(w.Body.String(), "parameter prefix: ") {
+			t.Errorf("%s: response %q should name the prefix parameter", c.name, w.Body.String())
+		}
+	}
+}
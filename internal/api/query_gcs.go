@@ -4,82 +4,77 @@
 package api
 
 import (
-	"crypto/sha1"
-	"encoding/binary"
 	"github.com/alvinbaena/pwd-checker/gcs"
 	"github.com/gin-gonic/gin"
 	"github.com/nbutton23/zxcvbn-go"
+	"github.com/rs/zerolog/log"
 	"net/http"
-	"regexp"
-	"strings"
 )
 
 type queryApi struct {
-	searcher *gcs.Reader
+	db *Database
 }
 
 func (q *queryApi) checkPassword(c *gin.Context) {
 	var req queryRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "invalid request body", err.Error())
 		return
 	}
 
-	h := sha1.New()
-	h.Write([]byte(req.Password))
-	buf := h.Sum(nil)
-	hash := binary.BigEndian.Uint64(buf)
-	exists, err := q.searcher.Exists(hash)
+	result, err := q.db.Lookup(gcs.HashFromPassword(req.Password))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Error().Err(err).Msg("error querying GCS database")
+		abortWithError(c, http.StatusInternalServerError, codeInternalError, "error querying the database")
 		return
 	}
 
-	entropy := zxcvbn.PasswordStrength(req.Password, nil)
 	resp := queryResponse{
-		Pwned: exists,
-		Strength: &passwordStrength{
-			CrackTime:        entropy.CrackTime,
-			CrackTimeDisplay: entropy.CrackTimeDisplay,
-			Score:            entropy.Score,
-		},
+		Pwned:    result.Found,
+		Exact:    result.Exact,
+		Strength: strength(req.Password),
 	}
+	q.db.metrics.observeScore(resp.Strength.Score)
 
 	c.JSON(http.StatusOK, resp)
 }
 
+// strength estimates the strength of a password, the same way for the REST and gRPC APIs.
+func strength(password string) *passwordStrength {
+	entropy := zxcvbn.PasswordStrength(password, nil)
+	return &passwordStrength{
+		CrackTime:        entropy.CrackTime,
+		CrackTimeDisplay: entropy.CrackTimeDisplay,
+		Score:            entropy.Score,
+	}
+}
+
 func (q *queryApi) checkHash(c *gin.Context) {
 	var req hashRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "invalid request body", err.Error())
 		return
 	}
 
-	if match, _ := regexp.MatchString("^[a-fA-F\\d]{40}$", strings.ToUpper(req.Hash)); !match {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "input is not a valid SHA1 Hexadecimal hash"})
+	hash, err := gcs.HashFromHex(req.Hash)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, codeInvalidHash, err.Error())
 		return
 	}
 
-	hash := gcs.U64FromHex([]byte(strings.ToUpper(req.Hash))[0:16])
-	exists, err := q.searcher.Exists(hash)
+	result, err := q.db.Lookup(hash)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Error().Err(err).Msg("error querying GCS database")
+		abortWithError(c, http.StatusInternalServerError, codeInternalError, "error querying the database")
 		return
 	}
 
-	c.JSON(http.StatusOK, queryResponse{Pwned: exists})
+	c.JSON(http.StatusOK, queryResponse{Pwned: result.Found, Exact: result.Exact})
 }
 
-func RegisterQueryApi(group *gin.RouterGroup, fileName string) error {
-	searcher := gcs.NewReader(fileName)
-	if err := searcher.Initialize(); err != nil {
-		return err
-	}
-
-	q := &queryApi{searcher: searcher}
+func RegisterQueryApi(group *gin.RouterGroup, db *Database) {
+	q := &queryApi{db: db}
 
 	group.POST("/password", q.checkPassword)
 	group.POST("/hash", q.checkHash)
-
-	return nil
 }
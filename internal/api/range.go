@@ -0,0 +1,107 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/alvinbaena/pwd-checker/hibp"
+	"github.com/gin-gonic/gin"
+	"math/big"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Padded responses have a random amount of lines between these, like the Pwned Passwords API
+	minPaddedLines = 800
+	maxPaddedLines = 1000
+)
+
+type rangeApi struct {
+	store *hibp.Store
+}
+
+// getRange responds like the Pwned Passwords range API: a SUFFIX:COUNT line for each hash of the
+// range, separated by CRLF. With the Add-Padding header, the response is padded with random
+// suffixes with a count of 0.
+func (r *rangeApi) getRange(c *gin.Context) {
+	prefix, err := hibp.ParsePrefix(c.Param("prefix"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "The hash prefix was not in a valid format")
+		return
+	}
+
+	entries, err := r.store.Range(prefix)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if strings.EqualFold(c.GetHeader("Add-Padding"), "true") {
+		if entries, err = padRange(entries); err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	var body strings.Builder
+	for i, entry := range entries {
+		if i > 0 {
+			body.WriteString("\r\n")
+		}
+		body.WriteString(entry.Suffix)
+		body.WriteByte(':')
+		body.WriteString(strconv.FormatUint(uint64(entry.Count), 10))
+	}
+
+	c.String(http.StatusOK, body.String())
+}
+
+// padRange adds random suffixes with a count of 0 to the entries, keeping them sorted.
+func padRange(entries []hibp.RangeEntry) ([]hibp.RangeEntry, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(maxPaddedLines-minPaddedLines+1))
+	if err != nil {
+		return nil, err
+	}
+
+	lines := minPaddedLines + int(n.Int64())
+	if len(entries) >= lines {
+		return entries, nil
+	}
+
+	seen := make(map[string]bool, lines)
+	for _, entry := range entries {
+		seen[entry.Suffix] = true
+	}
+
+	padded := slices.Clone(entries)
+	buf := make([]byte, 18)
+	for len(padded) < lines {
+		if _, err = rand.Read(buf); err != nil {
+			return nil, err
+		}
+
+		suffix := strings.ToUpper(hex.EncodeToString(buf))[:35]
+		if !seen[suffix] {
+			seen[suffix] = true
+			padded = append(padded, hibp.RangeEntry{Suffix: suffix})
+		}
+	}
+
+	slices.SortFunc(padded, func(a, b hibp.RangeEntry) int {
+		return strings.Compare(a.Suffix, b.Suffix)
+	})
+	return padded, nil
+}
+
+// RegisterRangeApi registers the Pwned Passwords compatible range API, so clients of
+// https://api.pwnedpasswords.com/range/{prefix} only need to change their base URL.
+func RegisterRangeApi(group *gin.RouterGroup, store *hibp.Store) {
+	r := &rangeApi{store: store}
+
+	group.GET("/range/:prefix", r.getRange)
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/shirou/gopsutil/v3/disk"
 	"github.com/shirou/gopsutil/v3/mem"
+	"os"
 	"regexp"
 	"runtime"
 	"strings"
@@ -83,3 +84,9 @@ func ToScreamingSnakeCase(str string) string {
 	snake = matchAllCap.ReplaceAllString(snake, "${1}_${2}")
 	return strings.ToUpper(snake)
 }
+
+// SameFileInfo checks if two stats are of the same file, unchanged: same size and modification time.
+// Files watched for changes are reloaded when it's false.
+func SameFileInfo(a os.FileInfo, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+// Package progress reports the progress of long-running processes, like building a GCS database
+// or downloading the Pwned Passwords ranges, to the log, as JSON lines, or to a callback.
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind of progress event.
+type Kind string
+
+const (
+	// StageStarted is sent when a stage starts.
+	StageStarted Kind = "stage_started"
+	// StageProgress is sent periodically while a stage runs.
+	StageProgress Kind = "progress"
+	// StageFinished is sent when a stage ends.
+	StageFinished Kind = "stage_finished"
+	// Finished is sent when the whole process ends.
+	Finished Kind = "finished"
+)
+
+// Event is the progress of a stage of a process.
+type Event struct {
+	Kind Kind
+	Time time.Time
+	// Process that sent the event, like "build" or "download"
+	Process string
+	// Stage of the process, empty for Finished events
+	Stage string
+	// Work done and total work of the stage. Total is 0 when it's not known.
+	Done  uint64
+	Total uint64
+	// Work done per second in the stage
+	Rate float64
+	// Estimated time left for the stage, 0 when it's not known
+	ETA time.Duration
+	// Time since the stage started, or since the process started for Finished events
+	Elapsed time.Duration
+	// Other counts of the process, like the hashes downloaded
+	Counters map[string]uint64
+}
+
+// NewEvent creates an event of a stage, with the rate and ETA of the work done in elapsed time.
+func NewEvent(kind Kind, process string, stage string, done uint64, total uint64, elapsed time.Duration) Event {
+	e := Event{
+		Kind:    kind,
+		Time:    time.Now(),
+		Process: process,
+		Stage:   stage,
+		Done:    done,
+		Total:   total,
+		Elapsed: elapsed,
+	}
+
+	if elapsed > 0 {
+		e.Rate = float64(done) / elapsed.Seconds()
+	}
+	if e.Rate > 0 && total > done {
+		e.ETA = time.Duration(float64(total-done) / e.Rate * float64(time.Second))
+	}
+
+	return e
+}
+
+// Percent is the work done of the stage, 0 when the total is not known.
+func (e Event) Percent() float64 {
+	if e.Total == 0 {
+		return 0
+	}
+
+	return float64(e.Done) / float64(e.Total) * 100
+}
+
+// MarshalJSON writes the durations in seconds.
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind     Kind              `json:"kind"`
+		Time     time.Time         `json:"time"`
+		Process  string            `json:"process"`
+		Stage    string            `json:"stage,omitempty"`
+		Done     uint64            `json:"done"`
+		Total    uint64            `json:"total"`
+		Percent  float64           `json:"percent"`
+		Rate     float64           `json:"rate"`
+		ETA      float64           `json:"eta_seconds"`
+		Elapsed  float64           `json:"elapsed_seconds"`
+		Counters map[string]uint64 `json:"counters,omitempty"`
+	}{
+		Kind:     e.Kind,
+		Time:     e.Time,
+		Process:  e.Process,
+		Stage:    e.Stage,
+		Done:     e.Done,
+		Total:    e.Total,
+		Percent:  e.Percent(),
+		Rate:     e.Rate,
+		ETA:      e.ETA.Seconds(),
+		Elapsed:  e.Elapsed.Seconds(),
+		Counters: e.Counters,
+	})
+}
+
+// Reporter receives the progress events of a process. It can be called from several goroutines.
+type Reporter interface {
+	Report(e Event)
+}
+
+// ReporterFunc is a callback used as a Reporter.
+type ReporterFunc func(e Event)
+
+func (f ReporterFunc) Report(e Event) {
+	f(e)
+}
+
+// Multi sends the events to all the reporters.
+func Multi(reporters ...Reporter) Reporter {
+	return ReporterFunc(func(e Event) {
+		for _, r := range reporters {
+			r.Report(e)
+		}
+	})
+}
+
+type logReporter struct {
+	log zerolog.Logger
+}
+
+// NewLogReporter writes the events to a logger, the way the commands always did.
+func NewLogReporter(log zerolog.Logger) Reporter {
+	return &logReporter{log: log}
+}
+
+func (r *logReporter) Report(e Event) {
+	switch e.Kind {
+	case StageStarted:
+		r.log.Info().Msgf("%s starting...", e.Stage)
+	case StageProgress:
+		var b strings.Builder
+		if e.Total > 0 {
+			b.WriteString(fmt.Sprintf("%s: %d of %d, %.2f%%, %.0f/s", e.Stage, e.Done, e.Total, e.Percent(), e.Rate))
+		} else {
+			b.WriteString(fmt.Sprintf("%s: %d, %.0f/s", e.Stage, e.Done, e.Rate))
+		}
+		if eta := e.ETA.Round(time.Second); eta > 0 {
+			b.WriteString(fmt.Sprintf(", ETA %v", eta))
+		}
+
+		names := make([]string, 0, len(e.Counters))
+		for name := range e.Counters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			b.WriteString(fmt.Sprintf(", %s: %d", name, e.Counters[name]))
+		}
+
+		r.log.Info().Msg(b.String())
+	case StageFinished:
+		r.log.Info().Msgf("%s complete in %v", e.Stage, e.Elapsed)
+	case Finished:
+		r.log.Info().Msgf("complete in %v", e.Elapsed)
+	}
+}
+
+type jsonReporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONReporter writes each event as a line of JSON, for other programs to follow the progress.
+// Errors writing the events are ignored.
+func NewJSONReporter(w io.Writer) Reporter {
+	return &jsonReporter{enc: json.NewEncoder(w)}
+}
+
+func (r *jsonReporter) Report(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_ = r.enc.Encode(e)
+}
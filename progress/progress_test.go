@@ -0,0 +1,71 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewEvent(t *testing.T) {
+	e := NewEvent(StageProgress, "build", "Read", 250, 1000, 10*time.Second)
+	if e.Rate != 25 {
+		t.Errorf("Rate: %f, want: 25", e.Rate)
+	}
+	if e.ETA != 30*time.Second {
+		t.Errorf("ETA: %v, want: 30s", e.ETA)
+	}
+	if e.Percent() != 25 {
+		t.Errorf("Percent: %f, want: 25", e.Percent())
+	}
+
+	// Nothing done yet, or no total, there is no ETA
+	for _, e := range []Event{
+		NewEvent(StageProgress, "build", "Read", 0, 1000, 10*time.Second),
+		NewEvent(StageProgress, "build", "Read", 250, 0, 10*time.Second),
+		NewEvent(StageStarted, "build", "Read", 0, 1000, 0),
+	} {
+		if e.ETA != 0 {
+			t.Errorf("ETA: %v, want: 0", e.ETA)
+		}
+	}
+}
+
+func TestJSONReporter(t *testing.T) {
+	var b bytes.Buffer
+	r := NewJSONReporter(&b)
+	r.Report(NewEvent(StageStarted, "download", "Download", 0, 100, 0))
+	e := NewEvent(StageProgress, "download", "Download", 50, 100, 5*time.Second)
+	e.Counters = map[string]uint64{"hashes": 1500}
+	r.Report(e)
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d lines written, want: 2", len(lines))
+	}
+
+	var got struct {
+		Kind     Kind              `json:"kind"`
+		Process  string            `json:"process"`
+		Stage    string            `json:"stage"`
+		Done     uint64            `json:"done"`
+		Total    uint64            `json:"total"`
+		Percent  float64           `json:"percent"`
+		Rate     float64           `json:"rate"`
+		ETA      float64           `json:"eta_seconds"`
+		Elapsed  float64           `json:"elapsed_seconds"`
+		Counters map[string]uint64 `json:"counters"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &got); err != nil {
+		t.Fatalf("Should not fail: %s", err)
+	}
+
+	if got.Kind != StageProgress || got.Process != "download" || got.Stage != "Download" || got.Done != 50 ||
+		got.Total != 100 || got.Percent != 50 || got.Rate != 10 || got.ETA != 5 || got.Elapsed != 5 || got.Counters["hashes"] != 1500 {
+		t.Errorf("Unexpected event: %s", lines[1])
+	}
+}
@@ -0,0 +1,7 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+// Package pwdcheckerv1 has the gRPC service of the server, generated from pwdchecker.proto.
+package pwdcheckerv1
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative pwdchecker.proto
@@ -0,0 +1,227 @@
+// Copyright (c) 2022. Alvin Baena.
+// SPDX-License-Identifier: MIT
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.25.3
+// source: pwdchecker.proto
+
+package pwdcheckerv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	PwdChecker_CheckHash_FullMethodName     = "/pwdchecker.v1.PwdChecker/CheckHash"
+	PwdChecker_CheckPassword_FullMethodName = "/pwdchecker.v1.PwdChecker/CheckPassword"
+	PwdChecker_CheckBatch_FullMethodName    = "/pwdchecker.v1.PwdChecker/CheckBatch"
+)
+
+// PwdCheckerClient is the client API for PwdChecker service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PwdCheckerClient interface {
+	// CheckHash checks a SHA1 hash of a password.
+	CheckHash(ctx context.Context, in *CheckHashRequest, opts ...grpc.CallOption) (*CheckResponse, error)
+	// CheckPassword checks a plain text password, and also returns its strength.
+	CheckPassword(ctx context.Context, in *CheckPasswordRequest, opts ...grpc.CallOption) (*CheckResponse, error)
+	// CheckBatch checks every hash or password sent on the stream, and sends a response with the same
+	// id for each of them. An invalid request doesn't end the stream, its response has the error.
+	CheckBatch(ctx context.Context, opts ...grpc.CallOption) (PwdChecker_CheckBatchClient, error)
+}
+
+type pwdCheckerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPwdCheckerClient(cc grpc.ClientConnInterface) PwdCheckerClient {
+	return &pwdCheckerClient{cc}
+}
+
+func (c *pwdCheckerClient) CheckHash(ctx context.Context, in *CheckHashRequest, opts ...grpc.CallOption) (*CheckResponse, error) {
+	out := new(CheckResponse)
+	err := c.cc.Invoke(ctx, PwdChecker_CheckHash_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pwdCheckerClient) CheckPassword(ctx context.Context, in *CheckPasswordRequest, opts ...grpc.CallOption) (*CheckResponse, error) {
+	out := new(CheckResponse)
+	err := c.cc.Invoke(ctx, PwdChecker_CheckPassword_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pwdCheckerClient) CheckBatch(ctx context.Context, opts ...grpc.CallOption) (PwdChecker_CheckBatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &PwdChecker_ServiceDesc.Streams[0], PwdChecker_CheckBatch_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &pwdCheckerCheckBatchClient{stream}
+	return x, nil
+}
+
+type PwdChecker_CheckBatchClient interface {
+	Send(*CheckBatchRequest) error
+	Recv() (*CheckBatchResponse, error)
+	grpc.ClientStream
+}
+
+type pwdCheckerCheckBatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *pwdCheckerCheckBatchClient) Send(m *CheckBatchRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *pwdCheckerCheckBatchClient) Recv() (*CheckBatchResponse, error) {
+	m := new(CheckBatchResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// PwdCheckerServer is the server API for PwdChecker service.
+// All implementations must embed UnimplementedPwdCheckerServer
+// for forward compatibility
+type PwdCheckerServer interface {
+	// CheckHash checks a SHA1 hash of a password.
+	CheckHash(context.Context, *CheckHashRequest) (*CheckResponse, error)
+	// CheckPassword checks a plain text password, and also returns its strength.
+	CheckPassword(context.Context, *CheckPasswordRequest) (*CheckResponse, error)
+	// CheckBatch checks every hash or password sent on the stream, and sends a response with the same
+	// id for each of them. An invalid request doesn't end the stream, its response has the error.
+	CheckBatch(PwdChecker_CheckBatchServer) error
+	mustEmbedUnimplementedPwdCheckerServer()
+}
+
+// UnimplementedPwdCheckerServer must be embedded to have forward compatible implementations.
+type UnimplementedPwdCheckerServer struct {
+}
+
+func (UnimplementedPwdCheckerServer) CheckHash(context.Context, *CheckHashRequest) (*CheckResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckHash not implemented")
+}
+func (UnimplementedPwdCheckerServer) CheckPassword(context.Context, *CheckPasswordRequest) (*CheckResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckPassword not implemented")
+}
+func (UnimplementedPwdCheckerServer) CheckBatch(PwdChecker_CheckBatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method CheckBatch not implemented")
+}
+func (UnimplementedPwdCheckerServer) mustEmbedUnimplementedPwdCheckerServer() {}
+
+// UnsafePwdCheckerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PwdCheckerServer will
+// result in compilation errors.
+type UnsafePwdCheckerServer interface {
+	mustEmbedUnimplementedPwdCheckerServer()
+}
+
+func RegisterPwdCheckerServer(s grpc.ServiceRegistrar, srv PwdCheckerServer) {
+	s.RegisterService(&PwdChecker_ServiceDesc, srv)
+}
+
+func _PwdChecker_CheckHash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckHashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PwdCheckerServer).CheckHash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PwdChecker_CheckHash_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PwdCheckerServer).CheckHash(ctx, req.(*CheckHashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PwdChecker_CheckPassword_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckPasswordRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PwdCheckerServer).CheckPassword(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PwdChecker_CheckPassword_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PwdCheckerServer).CheckPassword(ctx, req.(*CheckPasswordRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PwdChecker_CheckBatch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PwdCheckerServer).CheckBatch(&pwdCheckerCheckBatchServer{stream})
+}
+
+type PwdChecker_CheckBatchServer interface {
+	Send(*CheckBatchResponse) error
+	Recv() (*CheckBatchRequest, error)
+	grpc.ServerStream
+}
+
+type pwdCheckerCheckBatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *pwdCheckerCheckBatchServer) Send(m *CheckBatchResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *pwdCheckerCheckBatchServer) Recv() (*CheckBatchRequest, error) {
+	m := new(CheckBatchRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// PwdChecker_ServiceDesc is the grpc.ServiceDesc for PwdChecker service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PwdChecker_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "pwdchecker.v1.PwdChecker",
+	HandlerType: (*PwdCheckerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CheckHash",
+			Handler:    _PwdChecker_CheckHash_Handler,
+		},
+		{
+			MethodName: "CheckPassword",
+			Handler:    _PwdChecker_CheckPassword_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "CheckBatch",
+			Handler:       _PwdChecker_CheckBatch_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "pwdchecker.proto",
+}